@@ -0,0 +1,100 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+)
+
+// The icon of the app is src/assets/icon.svg: a white check on a red rounded
+// square, on a 512 unit grid. Browsers want PNG icons to install the app, so it
+// is drawn again here, with the same geometry.
+var (
+	iconColor  = color.NRGBA{0xaf, 0x2f, 0x2f, 0xff}
+	checkColor = color.NRGBA{0xff, 0xff, 0xff, 0xff}
+
+	// The square spans [iconMin, iconMax] with corners of radius iconRadius.
+	iconMin, iconMax, iconRadius = 24.0, 488.0, 96.0
+
+	// The check is a polyline of width checkWidth.
+	checkPoints = [][2]float64{{144, 268}, {224, 348}, {376, 172}}
+	checkWidth  = 48.0
+)
+
+// inSquare reports whether the point x, y of the grid is in the rounded square.
+func inSquare(x, y float64) bool {
+	if x < iconMin || x > iconMax || y < iconMin || y > iconMax {
+		return false
+	}
+	dx := math.Max(0, math.Max(iconMin+iconRadius-x, x-(iconMax-iconRadius)))
+	dy := math.Max(0, math.Max(iconMin+iconRadius-y, y-(iconMax-iconRadius)))
+	return dx*dx+dy*dy <= iconRadius*iconRadius
+}
+
+// onCheck reports whether the point x, y of the grid is on the check.
+func onCheck(x, y float64) bool {
+	for i := 1; i < len(checkPoints); i++ {
+		a, b := checkPoints[i-1], checkPoints[i]
+		vx, vy := b[0]-a[0], b[1]-a[1]
+		t := ((x-a[0])*vx + (y-a[1])*vy) / (vx*vx + vy*vy)
+		t = math.Max(0, math.Min(1, t))
+		dx, dy := x-(a[0]+t*vx), y-(a[1]+t*vy)
+		if dx*dx+dy*dy <= checkWidth*checkWidth/4 {
+			return true
+		}
+	}
+	return false
+}
+
+// drawIcon draws the icon at size pixels, antialiased. Maskable icons, which
+// the platform crops to its own shape, fill their whole square.
+func drawIcon(size int, maskable bool) *image.NRGBA {
+	const samples = 4
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	scale := 512 / float64(size)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			var square, check float64
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					gx := (float64(x) + (float64(sx)+0.5)/samples) * scale
+					gy := (float64(y) + (float64(sy)+0.5)/samples) * scale
+					if maskable || inSquare(gx, gy) {
+						square++
+						if onCheck(gx, gy) {
+							check++
+						}
+					}
+				}
+			}
+			if square == 0 {
+				continue
+			}
+			mix := func(c, i uint8) uint8 {
+				return uint8((float64(c)*check + float64(i)*(square-check)) / square)
+			}
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: mix(checkColor.R, iconColor.R),
+				G: mix(checkColor.G, iconColor.G),
+				B: mix(checkColor.B, iconColor.B),
+				A: uint8(255 * square / samples / samples),
+			})
+		}
+	}
+	return img
+}
+
+// writeIcon writes the icon at size pixels to path, as a PNG.
+func writeIcon(path string, size int, maskable bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, drawIcon(size, maskable)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,223 @@
+// Command pwa makes a built client installable and able to load offline. It
+// adds to the build directory:
+//
+//   - manifest.webmanifest, the web app manifest, and the icons it lists,
+//     drawn after src/assets/icon.svg, in icons/;
+//   - sw.js, a service worker precaching every file of the build, the wasm
+//     binary, wasm_exec.js, the stylesheet and the page included, in a cache
+//     named after a hash of their contents;
+//
+// and links the manifest and icons from index.html. The client registers the
+// service worker itself, and offers to reload when a new build is available.
+//
+// Usage:
+//
+//	pwa [-client ./dev/build/app]
+//
+// It is run after every build of the client: running it again on the same
+// build changes nothing.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// manifest is the web app manifest.
+type manifest struct {
+	Name            string         `json:"name"`
+	ShortName       string         `json:"short_name"`
+	Description     string         `json:"description"`
+	StartURL        string         `json:"start_url"`
+	Scope           string         `json:"scope"`
+	Display         string         `json:"display"`
+	BackgroundColor string         `json:"background_color"`
+	ThemeColor      string         `json:"theme_color"`
+	Icons           []manifestIcon `json:"icons"`
+}
+
+type manifestIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// icons are the PNG icons drawn, in icons/.
+var icons = []struct {
+	name     string
+	size     int
+	maskable bool
+}{
+	{"icon-192.png", 192, false},
+	{"icon-512.png", 512, false},
+	{"icon-maskable-512.png", 512, true},
+}
+
+const themeColor = "#af2f2f"
+
+func writeManifest(dir string) error {
+	m := manifest{
+		Name:            "Todo",
+		ShortName:       "Todo",
+		Description:     "A todo list that works offline.",
+		StartURL:        "./",
+		Scope:           "./",
+		Display:         "standalone",
+		BackgroundColor: "#f5f5f5",
+		ThemeColor:      themeColor,
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "icons"), 0o755); err != nil {
+		return err
+	}
+	for _, icon := range icons {
+		if err := writeIcon(filepath.Join(dir, "icons", icon.name), icon.size, icon.maskable); err != nil {
+			return err
+		}
+		size := strconv.Itoa(icon.size)
+		i := manifestIcon{Src: "icons/" + icon.name, Sizes: size + "x" + size, Type: "image/png"}
+		if icon.maskable {
+			i.Purpose = "maskable"
+		}
+		m.Icons = append(m.Icons, i)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "assets", "icon.svg")); err == nil {
+		m.Icons = append(m.Icons, manifestIcon{Src: "assets/icon.svg", Sizes: "any", Type: "image/svg+xml"})
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "manifest.webmanifest"), append(b, '\n'), 0o644)
+}
+
+var (
+	headTag   = regexp.MustCompile(`(?i)</head>`)
+	errNoHead = errors.New("index.html has no head")
+)
+
+// headLinks link the manifest and the icons from index.html.
+const headLinks = `<link rel="manifest" href="./manifest.webmanifest">
+<meta name="theme-color" content="` + themeColor + `">
+<link rel="icon" type="image/svg+xml" href="./assets/icon.svg">
+<link rel="apple-touch-icon" href="./icons/icon-192.png">
+`
+
+// linkManifest adds headLinks to index.html, unless they are there already.
+func linkManifest(dir string) error {
+	path := filepath.Join(dir, "index.html")
+	html, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(html, []byte(`rel="manifest"`)) {
+		return nil
+	}
+	head := headTag.FindIndex(html)
+	if head == nil {
+		return errNoHead
+	}
+	var b bytes.Buffer
+	b.Write(html[:head[0]])
+	b.WriteString(headLinks)
+	b.Write(html[head[0]:])
+	return os.WriteFile(path, b.Bytes(), 0o644)
+}
+
+// precache returns the URLs of the files of the build to precache, relative to
+// dir, and the version of the build: a hash of their contents. The page is
+// precached as "./", the URL the app is started from.
+func precache(dir string) (urls []string, version string, err error) {
+	var files []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || d.Name() == "sw.js" && filepath.Dir(path) == dir {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, f := range files {
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(f)))
+		if err != nil {
+			return nil, "", err
+		}
+		h.Write([]byte(f))
+		h.Write([]byte{0})
+		h.Write(b)
+
+		if f == "index.html" {
+			urls = append(urls, "./")
+			continue
+		}
+		u := url.URL{Path: f}
+		urls = append(urls, "./"+u.EscapedPath())
+	}
+	return urls, hex.EncodeToString(h.Sum(nil)[:6]), nil
+}
+
+func writeServiceWorker(dir string) (version string, err error) {
+	urls, version, err := precache(dir)
+	if err != nil {
+		return "", err
+	}
+	files, err := json.Marshal(urls)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := swTemplate.Execute(&b, swData{Version: version, Files: string(files)}); err != nil {
+		return "", err
+	}
+	return version, os.WriteFile(filepath.Join(dir, "sw.js"), b.Bytes(), 0o644)
+}
+
+func main() {
+	client := flag.String("client", filepath.Join("dev", "build", "app"), "directory of the built client")
+	flag.Parse()
+
+	if err := writeManifest(*client); err != nil {
+		log.Fatal(err)
+	}
+	if err := linkManifest(*client); err != nil {
+		log.Fatal(err)
+	}
+	// The service worker comes last: its version covers the files written
+	// above.
+	version, err := writeServiceWorker(*client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %s, version %s", filepath.Join(*client, "sw.js"), version)
+}
@@ -0,0 +1,82 @@
+package main
+
+import "text/template"
+
+// swData is what the service worker template renders.
+type swData struct {
+	Version string
+	Files   string // JSON array of the URLs to precache
+}
+
+// swTemplate is the service worker of the app. Each version of the build has
+// its own cache, filled when the worker installs: files are fetched bypassing
+// the HTTP cache, so that a new version never mixes with the files of an old
+// one. The first version takes control of the app at once. Later ones wait
+// until the app, asked by the user, tells them to take over (see src/pwa.go),
+// then drop the caches of older versions.
+//
+// Files are precached without credentials, so that the page precached is the
+// page as built, rather than rendered by the server with the list of the user
+// (see cmd/todoserver/render.go). Pages are loaded from the network, and never
+// cached, as they may be rendered with a list or carry a share link in their
+// URL: offline, the page precached is served, and the app starts from the copy
+// of the list kept by its store. The API is never cached either.
+var swTemplate = template.Must(template.New("sw.js").Parse(`// Service worker of the app, generated by cmd/pwa: do not edit.
+const VERSION = "{{.Version}}";
+const CACHE = "todomvc2-" + VERSION;
+const PRECACHE = {{.Files}};
+
+self.addEventListener("install", (event) => {
+	event.waitUntil(
+		caches.open(CACHE).then((cache) =>
+			Promise.all(PRECACHE.map((url) =>
+				fetch(new Request(url, { cache: "reload", credentials: "omit" })).then((resp) => {
+					if (!resp.ok) {
+						throw new Error(url + ": status " + resp.status);
+					}
+					return cache.put(url, resp);
+				})
+			))
+		).then(() => self.registration.active ? undefined : self.skipWaiting())
+	);
+});
+
+self.addEventListener("activate", (event) => {
+	event.waitUntil(
+		caches.keys()
+			.then((keys) => Promise.all(keys
+				.filter((key) => key.startsWith("todomvc2-") && key !== CACHE)
+				.map((key) => caches.delete(key))))
+			.then(() => self.clients.claim())
+	);
+});
+
+self.addEventListener("message", (event) => {
+	if (event.data && event.data.type === "skipWaiting") {
+		self.skipWaiting();
+	}
+});
+
+self.addEventListener("fetch", (event) => {
+	const req = event.request;
+	const url = new URL(req.url);
+	if (req.method !== "GET" || url.origin !== self.location.origin || url.pathname.startsWith("/api/")) {
+		return;
+	}
+	if (req.mode === "navigate") {
+		event.respondWith(
+			fetch(req).catch(() =>
+				caches.open(CACHE)
+					.then((cache) => cache.match("./"))
+					.then((resp) => resp || Response.error())
+			)
+		);
+		return;
+	}
+	event.respondWith(
+		caches.open(CACHE).then((cache) =>
+			cache.match(req, { ignoreSearch: true }).then((resp) => resp || fetch(req))
+		)
+	);
+});
+`))
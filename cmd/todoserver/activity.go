@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// The activity log of a list records every change of its todos: who made it,
+// when, and the field changed with its old and new values. It can be read by
+// every viewer of the list:
+//
+//	GET /api/lists/{id}/activity               the latest changes: {"activity": [...]}
+//	GET /api/lists/{id}/activity?todo={todo}   the latest changes of a todo
+//
+// Changes come newest first, at most limit of them (100 by default).
+
+func (a *api) getActivity(w http.ResponseWriter, r *http.Request) {
+	limit := defaultPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(n, maxPageSize)
+	}
+	changes, err := a.store.Activity(r.PathValue("id"), r.URL.Query().Get("todo"), limit)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if changes == nil {
+		changes = []model.Change{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"activity": changes})
+}
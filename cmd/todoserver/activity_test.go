@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+func TestActivity(t *testing.T) {
+	s, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create("l", "List", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if changes, err := s.Activity("l", "", 10); err != nil || len(changes) != 0 {
+		t.Fatalf("Activity of an empty log = %v, %v", changes, err)
+	}
+
+	// Enough changes, some longer than a read, for the log to span many
+	// reads.
+	var all []model.Change
+	for i := 0; i < 2000; i++ {
+		c := model.Change{Kind: model.OpCreate, Todo: "t" + strconv.Itoa(i%3), Title: "Todo " + strconv.Itoa(i)}
+		if i%500 == 7 {
+			c.Title = strings.Repeat("x", activityChunk+100)
+		}
+		all = append(all, c)
+	}
+	s.mu.Lock()
+	err = s.record("l", all)
+	s.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := s.Activity("l", "", len(all)+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != len(all) {
+		t.Fatalf("Activity returned %d changes, want %d", len(changes), len(all))
+	}
+	for i, c := range changes {
+		if want := all[len(all)-1-i]; c.Title != want.Title {
+			t.Fatalf("change %d is %.20q, want %.20q", i, c.Title, want.Title)
+		}
+	}
+
+	changes, err = s.Activity("l", "t1", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, c := range changes {
+		titles = append(titles, c.Title)
+	}
+	want := "Todo 1999, Todo 1996, Todo 1993, Todo 1990, Todo 1987"
+	if got := strings.Join(titles, ", "); got != want {
+		t.Errorf("latest changes of t1 = %s, want %s", got, want)
+	}
+
+	if _, err := s.Activity("missing", "", 10); err != errNotFound {
+		t.Errorf("Activity of a missing list: %v", err)
+	}
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 500
+	maxBodySize     = 4 << 20
+)
+
+// api serves the REST API of the todo lists:
+//
+//	GET    /api/lists                      every list, without todos
+//	POST   /api/lists                      create a list: {"id", "title"}
+//	GET    /api/lists/{id}                 a list, without todos
+//	PATCH  /api/lists/{id}                 rename a list: {"title"}
+//	DELETE /api/lists/{id}                 delete a list
+//	GET    /api/lists/{id}/todos           the todos, one page at a time
+//	PUT    /api/lists/{id}/todos           replace every todo: {"todos": [...]}
+//	POST   /api/lists/{id}/todos           append a todo
+//	GET    /api/lists/{id}/todos/{todo}    a todo
+//	PUT    /api/lists/{id}/todos/{todo}    replace a todo
+//	PATCH  /api/lists/{id}/todos/{todo}    change some fields of a todo
+//	DELETE /api/lists/{id}/todos/{todo}    delete a todo
+//	POST   /api/lists/{id}/ops             apply ops: {"ops": [...]}
+//	GET    /api/lists/{id}/live            WebSocket of changes and viewers
+//	GET    /api/lists/{id}/events          Server-Sent Events of changes
+//	GET    /api/lists/{id}/activity        the history of the todos (see activity.go)
+//
+// Every endpoint requires a signed in user (see auth). Viewers of a list can
+// read it, editors can also change its todos, and owners can also rename it,
+// delete it and manage its members (see members.go) and its share links (see
+// share.go), which let anyone holding one read the list. Lists a user is not a
+// member of are not found.
+//
+// Lists carry their revision as ETag and todos a hash of their content.
+// Requests with If-Match fail with 412 Precondition Failed when the list or
+// todo has changed, and GET requests with If-None-Match get 304 Not Modified
+// when it has not.
+type api struct {
+	store    *Store
+	presence *presence
+	auth     *auth
+	shares   *shareSigner
+}
+
+func (a *api) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/signup", a.signup)
+	mux.HandleFunc("POST /api/login", a.login)
+	mux.HandleFunc("POST /api/logout", a.signedIn(a.logout))
+	mux.HandleFunc("GET /api/session", a.signedIn(a.getSession))
+
+	mux.HandleFunc("GET /api/lists", a.signedIn(a.getLists))
+	mux.HandleFunc("POST /api/lists", a.signedIn(a.createList))
+	mux.HandleFunc("GET /api/lists/{id}", a.allowShared(roleViewer, a.getList))
+	mux.HandleFunc("PATCH /api/lists/{id}", a.allow(roleOwner, a.renameList))
+	mux.HandleFunc("DELETE /api/lists/{id}", a.allow(roleOwner, a.deleteList))
+	mux.HandleFunc("GET /api/lists/{id}/members", a.allow(roleViewer, a.getMembers))
+	mux.HandleFunc("PUT /api/lists/{id}/members/{user}", a.allow(roleOwner, a.setMember))
+	mux.HandleFunc("DELETE /api/lists/{id}/members/{user}", a.allow(roleOwner, a.removeMember))
+	mux.HandleFunc("GET /api/lists/{id}/shares", a.allow(roleOwner, a.getShares))
+	mux.HandleFunc("POST /api/lists/{id}/shares", a.allow(roleOwner, a.createShare))
+	mux.HandleFunc("DELETE /api/lists/{id}/shares/{share}", a.allow(roleOwner, a.deleteShare))
+	mux.HandleFunc("GET /api/lists/{id}/todos", a.allowShared(roleViewer, a.getTodos))
+	mux.HandleFunc("PUT /api/lists/{id}/todos", a.allow(roleEditor, a.replaceTodos))
+	mux.HandleFunc("POST /api/lists/{id}/todos", a.allow(roleEditor, a.createTodo))
+	mux.HandleFunc("GET /api/lists/{id}/todos/{todo}", a.allowShared(roleViewer, a.getTodo))
+	mux.HandleFunc("PUT /api/lists/{id}/todos/{todo}", a.allow(roleEditor, a.replaceTodo))
+	mux.HandleFunc("PATCH /api/lists/{id}/todos/{todo}", a.allow(roleEditor, a.patchTodo))
+	mux.HandleFunc("DELETE /api/lists/{id}/todos/{todo}", a.allow(roleEditor, a.deleteTodo))
+	mux.HandleFunc("POST /api/lists/{id}/ops", a.allow(roleEditor, a.applyOps))
+	mux.HandleFunc("GET /api/lists/{id}/live", a.allowShared(roleViewer, a.live))
+	mux.HandleFunc("GET /api/lists/{id}/events", a.allowShared(roleViewer, a.events))
+	mux.HandleFunc("GET /api/lists/{id}/activity", a.allow(roleViewer, a.getActivity))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print(err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// writeStoreError maps the errors of the store to HTTP statuses.
+func writeStoreError(w http.ResponseWriter, err error) {
+	var invalid *invalidError
+	switch {
+	case errors.Is(err, errNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errExists):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, errForbidden):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, errPrecondition):
+		writeError(w, http.StatusPreconditionFailed, err.Error())
+	case errors.As(err, &invalid):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		log.Print(err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBodySize))
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+var errPrecondition = errors.New("precondition failed")
+
+func listETag(l *List) string {
+	return `"` + strconv.FormatInt(l.Revision, 10) + `"`
+}
+
+func todoETag(t model.Todo) string {
+	b, _ := json.Marshal(t)
+	h := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(h[:8]) + `"`
+}
+
+// matches reports whether etag is listed in an If-Match or If-None-Match
+// header.
+func matches(header string, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// precondition checks the If-Match header of a request against etag.
+func precondition(r *http.Request, etag string) error {
+	if h := r.Header.Get("If-Match"); h != "" && !matches(h, etag) {
+		return errPrecondition
+	}
+	return nil
+}
+
+// notModified answers 304 Not Modified when the If-None-Match header of a
+// request lists etag.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if h := r.Header.Get("If-None-Match"); h != "" && matches(h, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+func (a *api) getLists(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"lists": a.store.Lists(userOf(r))})
+}
+
+func (a *api) createList(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.ID == "" {
+		req.ID = newID()
+	}
+	if !validID.MatchString(req.ID) {
+		writeError(w, http.StatusBadRequest, "invalid list id")
+		return
+	}
+	l, err := a.store.Create(req.ID, req.Title, userOf(r))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", listETag(l))
+	w.Header().Set("Location", "/api/lists/"+l.ID)
+	info := l.Info()
+	info.Role = roleOwner
+	writeJSON(w, http.StatusCreated, info)
+}
+
+func (a *api) getList(w http.ResponseWriter, r *http.Request) {
+	l, err := a.store.Get(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if notModified(w, r, listETag(l)) {
+		return
+	}
+	info := l.Info()
+	info.Role = roleIn(r, l)
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (a *api) renameList(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Title string `json:"title"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	l, err := a.store.Update(r.PathValue("id"), func(l *List) error {
+		if err := precondition(r, listETag(l)); err != nil {
+			return err
+		}
+		l.Title = req.Title
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", listETag(l))
+	info := l.Info()
+	info.Role = roleIn(r, l)
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (a *api) deleteList(w http.ResponseWriter, r *http.Request) {
+	// The role and the revision are checked again along with the deletion,
+	// since they may have changed since the request was let in.
+	err := a.store.Delete(r.PathValue("id"), func(l *List) error {
+		if err := checkRole(r, l, roleOwner); err != nil {
+			return err
+		}
+		return precondition(r, listETag(l))
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// todosPage is a page of todos. Next is the cursor of the next page, empty on
+// the last one.
+type todosPage struct {
+	Todos []model.Todo `json:"todos"`
+	Next  string       `json:"next,omitempty"`
+}
+
+// encodeCursor returns the cursor of the page following the todo id, at
+// position pos.
+func encodeCursor(pos int, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(pos) + ":" + id))
+}
+
+func decodeCursor(cursor string) (pos int, id string, ok bool) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", false
+	}
+	p, id, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return 0, "", false
+	}
+	pos, err = strconv.Atoi(p)
+	return pos, id, err == nil && pos >= 0 && id != ""
+}
+
+// pageStart returns the position of the first todo of the page following the
+// todo id, last seen at pos. The todo is looked for by id, so that no todo is
+// skipped or seen twice when todos before it were added or removed. When it
+// was removed itself, the page starts where it was.
+func pageStart(l *List, pos int, id string) int {
+	if i := indexOf(l, id); i >= 0 {
+		return i + 1
+	}
+	return min(pos, len(l.Todos))
+}
+
+func (a *api) getTodos(w http.ResponseWriter, r *http.Request) {
+	l, err := a.store.Get(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	offset := 0
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		pos, id, ok := decodeCursor(c)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		offset = pageStart(l, pos, id)
+	}
+	limit := defaultPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(n, maxPageSize)
+	}
+	if offset == 0 && notModified(w, r, listETag(l)) {
+		return
+	}
+	w.Header().Set("ETag", listETag(l))
+
+	page := todosPage{Todos: []model.Todo{}}
+	if offset < len(l.Todos) {
+		end := min(offset+limit, len(l.Todos))
+		page.Todos = l.Todos[offset:end]
+		if end < len(l.Todos) {
+			page.Next = encodeCursor(end-1, l.Todos[end-1].ID)
+		}
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (a *api) replaceTodos(w http.ResponseWriter, r *http.Request) {
+	var req todosPage
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Todos == nil {
+		req.Todos = []model.Todo{}
+	}
+	l, err := a.store.UpdateBy(r.PathValue("id"), userOf(r), func(l *List) error {
+		if err := precondition(r, listETag(l)); err != nil {
+			return err
+		}
+		l.Todos = req.Todos
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", listETag(l))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func indexOf(l *List, id string) int {
+	for i, t := range l.Todos {
+		if t.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (a *api) createTodo(w http.ResponseWriter, r *http.Request) {
+	var t model.Todo
+	if !readJSON(w, r, &t) {
+		return
+	}
+	if t.ID == "" {
+		t.ID = newID()
+	}
+	l, err := a.store.UpdateBy(r.PathValue("id"), userOf(r), func(l *List) error {
+		if err := precondition(r, listETag(l)); err != nil {
+			return err
+		}
+		if indexOf(l, t.ID) >= 0 {
+			return errExists
+		}
+		l.Todos = append(l.Todos, t)
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", todoETag(t))
+	w.Header().Set("Location", "/api/lists/"+l.ID+"/todos/"+t.ID)
+	writeJSON(w, http.StatusCreated, t)
+}
+
+func (a *api) getTodo(w http.ResponseWriter, r *http.Request) {
+	l, err := a.store.Get(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	i := indexOf(l, r.PathValue("todo"))
+	if i < 0 {
+		writeStoreError(w, errNotFound)
+		return
+	}
+	if notModified(w, r, todoETag(l.Todos[i])) {
+		return
+	}
+	writeJSON(w, http.StatusOK, l.Todos[i])
+}
+
+// updateTodo applies f to a todo, checking the If-Match header of the request
+// against the todo first.
+func (a *api) updateTodo(w http.ResponseWriter, r *http.Request, f func(t *model.Todo) error) {
+	id := r.PathValue("todo")
+	var updated model.Todo
+	_, err := a.store.UpdateBy(r.PathValue("id"), userOf(r), func(l *List) error {
+		i := indexOf(l, id)
+		if i < 0 {
+			return errNotFound
+		}
+		if err := precondition(r, todoETag(l.Todos[i])); err != nil {
+			return err
+		}
+		if err := f(&l.Todos[i]); err != nil {
+			return err
+		}
+		l.Todos[i].ID = id
+		updated = l.Todos[i]
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", todoETag(updated))
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (a *api) replaceTodo(w http.ResponseWriter, r *http.Request) {
+	var t model.Todo
+	if !readJSON(w, r, &t) {
+		return
+	}
+	a.updateTodo(w, r, func(old *model.Todo) error {
+		*old = t
+		return nil
+	})
+}
+
+// patchTodo changes the fields present in the JSON body of the request and
+// keeps the others.
+func (a *api) patchTodo(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !json.Valid(body) {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	a.updateTodo(w, r, func(t *model.Todo) error {
+		// Decoding into the slices of t would write into the arrays that the
+		// list before the change shares.
+		patched := t.Clone()
+		if err := json.Unmarshal(body, &patched); err != nil {
+			return &invalidError{err}
+		}
+		*t = patched
+		return nil
+	})
+}
+
+func (a *api) deleteTodo(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("todo")
+	_, err := a.store.UpdateBy(r.PathValue("id"), userOf(r), func(l *List) error {
+		i := indexOf(l, id)
+		if i < 0 {
+			return errNotFound
+		}
+		if err := precondition(r, todoETag(l.Todos[i])); err != nil {
+			return err
+		}
+		l.Todos = append(l.Todos[:i], l.Todos[i+1:]...)
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyOps applies the ops queued by an offline client and answers with every
+// todo of the resulting list. Ops already applied, as told by their OpID, are
+// skipped, so a batch that is sent again after a lost response does not apply
+// twice.
+func (a *api) applyOps(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Ops []model.Op `json:"ops"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	l, err := a.store.UpdateBy(r.PathValue("id"), userOf(r), func(l *List) error {
+		l.applyOps(req.Ops)
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", listETag(l))
+	writeJSON(w, http.StatusOK, todosPage{Todos: l.Todos})
+}
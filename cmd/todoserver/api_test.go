@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// testServer serves the API with a store in a temporary directory.
+type testServer struct {
+	*httptest.Server
+	api *api
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := OpenUsers(filepath.Join(dir, "accounts", "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := openShareSigner(filepath.Join(dir, "accounts", "share.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &api{
+		store:    store,
+		presence: newPresence(),
+		auth:     &auth{users: users, sessions: newSessions(), signup: true},
+		shares:   shares,
+	}
+	mux := http.NewServeMux()
+	a.register(mux)
+	s := &testServer{Server: httptest.NewServer(mux), api: a}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// signIn starts a session for user and returns its cookie and CSRF token.
+func (s *testServer) signIn(user string) (cookie *http.Cookie, csrf string) {
+	token, sess := s.api.auth.sessions.create(user)
+	return &http.Cookie{Name: sessionCookie, Value: token}, sess.CSRF
+}
+
+// newTestList creates the list id, owned by owner, with the other members
+// given as user, role pairs.
+func (s *testServer) newTestList(t *testing.T, id string, owner string, members ...string) {
+	t.Helper()
+	if _, err := s.api.store.Create(id, "Todos", owner); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.api.store.Update(id, func(l *List) error {
+		for i := 0; i+1 < len(members); i += 2 {
+			l.Members[members[i]] = members[i+1]
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// request sends a request to the server, as the user of cookie if not nil.
+func (s *testServer) request(t *testing.T, method, path, body string, cookie *http.Cookie, csrf string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+		req.Header.Set(csrfHeader, csrf)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+var patchedTodo = model.Todo{
+	ID:         "a",
+	Title:      "Buy milk",
+	Tags:       []string{"shop", "home"},
+	Checklist:  []model.ChecklistItem{{Text: "Milk"}, {Text: "Eggs"}},
+	Extensions: model.Extensions{"t:1", "t:2"},
+}
+
+// newPatchList creates the list l, owned by alice, holding patchedTodo.
+func (s *testServer) newPatchList(t *testing.T) {
+	t.Helper()
+	s.newTestList(t, "l", "alice")
+	_, err := s.api.store.Update("l", func(l *List) error {
+		l.Todos = []model.Todo{patchedTodo.Clone()}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPatchTodo(t *testing.T) {
+	s := newTestServer(t)
+	s.newPatchList(t)
+	before, err := s.api.store.Get("l")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookie, csrf := s.signIn("alice")
+	resp := s.request(t, "PATCH", "/api/lists/l/todos/a", `{"tags":["work","q4"],"checklist":[{"text":"Bread","done":true}],"extensions":["t:3"]}`, cookie, csrf)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH: status %d", resp.StatusCode)
+	}
+	var got model.Todo
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := model.Todo{
+		ID:         "a",
+		Title:      "Buy milk",
+		Tags:       []string{"work", "q4"},
+		Checklist:  []model.ChecklistItem{{Text: "Bread", Done: true}},
+		Extensions: model.Extensions{"t:3"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PATCH = %+v, want %+v", got, want)
+	}
+
+	// The list before the change is left as it was, so the change is
+	// recorded.
+	if !reflect.DeepEqual(before.Todos, []model.Todo{patchedTodo}) {
+		t.Errorf("list before PATCH changed to %+v", before.Todos)
+	}
+	changes, err := s.api.store.Activity("l", "a", maxPageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := make(map[string]bool)
+	for _, c := range changes {
+		fields[c.Field] = true
+	}
+	for _, f := range []string{"tags", "checklist", "extensions"} {
+		if !fields[f] {
+			t.Errorf("no change of %s recorded in %+v", f, changes)
+		}
+	}
+}
+
+func TestPatchTodoInvalid(t *testing.T) {
+	s := newTestServer(t)
+	s.newPatchList(t)
+
+	cookie, csrf := s.signIn("alice")
+	for _, body := range []string{
+		`{"tags":["work","q4"],"title":5}`,
+		`{"tags":["work","q4"],"checklist":[{"text":"Bread"}],"title":""}`,
+	} {
+		resp := s.request(t, "PATCH", "/api/lists/l/todos/a", body, cookie, csrf)
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("PATCH %s: status %d, want %d", body, resp.StatusCode, http.StatusUnprocessableEntity)
+		}
+		l, err := s.api.store.Get("l")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(l.Todos, []model.Todo{patchedTodo}) {
+			t.Errorf("PATCH %s: todos changed to %+v", body, l.Todos)
+		}
+	}
+}
+
+func TestApplyOpsOnce(t *testing.T) {
+	s := newTestServer(t)
+	s.newPatchList(t)
+
+	cookie, csrf := s.signIn("alice")
+	batch := `{"ops":[{"kind":"retitle","id":"a","opid":"op1","todo":{"id":"a","title":"Buy bread"},"fields":["title"]}]}`
+	if resp := s.request(t, "POST", "/api/lists/l/ops", batch, cookie, csrf); resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST ops: status %d", resp.StatusCode)
+	}
+	if resp := s.request(t, "PATCH", "/api/lists/l/todos/a", `{"title":"Buy eggs"}`, cookie, csrf); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH: status %d", resp.StatusCode)
+	}
+
+	// The batch sent again, after a lost response, does not undo the change
+	// made in between.
+	if resp := s.request(t, "POST", "/api/lists/l/ops", batch, cookie, csrf); resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST ops again: status %d", resp.StatusCode)
+	}
+	l, err := s.api.store.Get("l")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := l.Todos[0].Title; got != "Buy eggs" {
+		t.Errorf("title = %q after the ops were sent again, want %q", got, "Buy eggs")
+	}
+}
+
+// getPage gets a page of two todos of the list l and returns their ids and the
+// cursor of the next page.
+func (s *testServer) getPage(t *testing.T, cursor string, cookie *http.Cookie, csrf string) (ids []string, next string) {
+	t.Helper()
+	path := "/api/lists/l/todos?limit=2"
+	if cursor != "" {
+		path += "&cursor=" + cursor
+	}
+	resp := s.request(t, "GET", path, "", cookie, csrf)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status %d", path, resp.StatusCode)
+	}
+	var page todosPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	for _, td := range page.Todos {
+		ids = append(ids, td.ID)
+	}
+	return ids, page.Next
+}
+
+func TestGetTodosCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(todos []model.Todo) []model.Todo
+		want   string
+	}{
+		{"unchanged", func(todos []model.Todo) []model.Todo { return todos }, "c d"},
+		{"seen todo removed", func(todos []model.Todo) []model.Todo { return todos[1:] }, "c d"},
+		{"last seen todo removed", func(todos []model.Todo) []model.Todo {
+			return append(todos[:1:1], todos[2:]...)
+		}, "c d"},
+		{"todo added first", func(todos []model.Todo) []model.Todo {
+			return append([]model.Todo{{ID: "z", Title: "z"}}, todos...)
+		}, "c d"},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t)
+		s.newTestList(t, "l", "alice")
+		_, err := s.api.store.Update("l", func(l *List) error {
+			for _, id := range []string{"a", "b", "c", "d", "e"} {
+				l.Todos = append(l.Todos, model.Todo{ID: id, Title: id})
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookie, csrf := s.signIn("alice")
+
+		ids, next := s.getPage(t, "", cookie, csrf)
+		if strings.Join(ids, " ") != "a b" {
+			t.Fatalf("%s: first page = %v", tt.name, ids)
+		}
+		if _, err := s.api.store.Update("l", func(l *List) error {
+			l.Todos = tt.change(l.Todos)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if ids, _ := s.getPage(t, next, cookie, csrf); strings.Join(ids, " ") != tt.want {
+			t.Errorf("%s: second page = %v, want %s", tt.name, ids, tt.want)
+		}
+	}
+}
+
+func TestUpdateUnchanged(t *testing.T) {
+	s, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := s.Create("l", "List", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := s.Update("l", func(l *List) error {
+		l.Title = "List"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Revision != before.Revision {
+		t.Errorf("revision %d after an update changing nothing, want %d", after.Revision, before.Revision)
+	}
+}
+
+func TestDeleteList(t *testing.T) {
+	s := newTestServer(t)
+	s.newTestList(t, "l", "alice", "bob", roleEditor)
+
+	cookie, csrf := s.signIn("bob")
+	if resp := s.request(t, "DELETE", "/api/lists/l", "", cookie, csrf); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("DELETE by an editor: status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	refused := errors.New("refused")
+	if err := s.api.store.Delete("l", func(*List) error { return refused }); err != refused {
+		t.Errorf("Delete refused by its check: %v", err)
+	}
+	if _, err := s.api.store.Get("l"); err != nil {
+		t.Fatalf("list deleted: %v", err)
+	}
+
+	cookie, csrf = s.signIn("alice")
+	if resp := s.request(t, "DELETE", "/api/lists/l", "", cookie, csrf); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE by the owner: status %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	if _, err := s.api.store.Get("l"); err != errNotFound {
+		t.Errorf("list not deleted: %v", err)
+	}
+}
+
+func TestShareAccess(t *testing.T) {
+	s := newTestServer(t)
+	s.newTestList(t, "l", "alice", "bob", roleEditor)
+	l, err := s.api.store.Update("l", func(l *List) error {
+		l.Shares = map[string]Share{"s": {Created: time.Now().UTC(), By: "alice"}}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := "?" + shareParam + "=" + s.api.shareInfo(l, "s").Token
+
+	for path, want := range map[string]int{
+		"/api/lists/l":            http.StatusOK,
+		"/api/lists/l/todos":      http.StatusOK,
+		"/api/lists/l/members":    http.StatusUnauthorized,
+		"/api/lists/l/activity":   http.StatusUnauthorized,
+		"/api/lists/l/shares":     http.StatusUnauthorized,
+		"/api/lists/other/todos":  http.StatusUnauthorized,
+		"/api/lists/l/todos/none": http.StatusNotFound,
+	} {
+		if resp := s.request(t, "GET", path+q, "", nil, ""); resp.StatusCode != want {
+			t.Errorf("GET %s with a share link: status %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+
+	cookie, csrf := s.signIn("alice")
+	if resp := s.request(t, "GET", "/api/lists/l/members"+q, "", cookie, csrf); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET members as the owner with a share link: status %d", resp.StatusCode)
+	}
+	for _, body := range []string{`{"expires_in":-1}`, `{"expires_in":1}`, `{"expires_in":9223372036854775807}`} {
+		if resp := s.request(t, "POST", "/api/lists/l/shares", body, cookie, csrf); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("POST shares %s: status %d, want %d", body, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+	if resp := s.request(t, "POST", "/api/lists/l/shares", `{"expires_in":604800}`, cookie, csrf); resp.StatusCode != http.StatusCreated {
+		t.Errorf("POST shares for 7 days: status %d", resp.StatusCode)
+	}
+}
+
+func TestListWithoutMembers(t *testing.T) {
+	s := newTestServer(t)
+	s.newTestList(t, "l", "")
+
+	bob, bobCSRF := s.signIn("bob")
+	if resp := s.request(t, "GET", "/api/lists/l", "", bob, bobCSRF); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET a list without members: status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	if lists := s.api.store.Lists("bob"); len(lists) != 0 {
+		t.Errorf("lists of bob = %+v", lists)
+	}
+
+	if err := s.api.store.Adopt("alice"); err != nil {
+		t.Fatal(err)
+	}
+	alice, aliceCSRF := s.signIn("alice")
+	if resp := s.request(t, "DELETE", "/api/lists/l", "", bob, bobCSRF); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("DELETE an adopted list by another user: status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	if resp := s.request(t, "GET", "/api/lists/l", "", alice, aliceCSRF); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET an adopted list by its owner: status %d", resp.StatusCode)
+	}
+}
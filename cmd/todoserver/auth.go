@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Accounts and sessions.
+//
+// Passwords are hashed with bcrypt. Signing in gives the client a random
+// session token, kept in an HttpOnly cookie, and the CSRF token of the
+// session, which the client sends back in the X-CSRF-Token header of every
+// request that changes something. Pages of other origins can make the browser
+// send the cookie but cannot read the CSRF token.
+
+// Roles of the members of a list, from the least to the most privileged:
+// viewers read the list, editors also change its todos, and owners also
+// rename it, delete it and manage its members.
+const (
+	roleViewer = "viewer"
+	roleEditor = "editor"
+	roleOwner  = "owner"
+)
+
+var roleRanks = map[string]int{roleViewer: 1, roleEditor: 2, roleOwner: 3}
+
+// hasRole reports whether role grants at least the rights of want.
+func hasRole(role string, want string) bool {
+	return roleRanks[role] >= roleRanks[want] && roleRanks[role] > 0
+}
+
+const (
+	sessionCookie     = "todo_session"
+	sessionTTL        = 30 * 24 * time.Hour
+	csrfHeader        = "X-CSRF-Token"
+	minPasswordLength = 8
+)
+
+var (
+	validName         = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,32}$`)
+	errBadCredentials = errors.New("invalid name or password")
+	errForbidden      = errors.New("forbidden")
+)
+
+// User is an account as kept on disk.
+type User struct {
+	Name    string    `json:"name"`
+	Hash    []byte    `json:"hash"`
+	Created time.Time `json:"created"`
+}
+
+// Users keeps the accounts in memory and writes them all to a single JSON
+// file.
+type Users struct {
+	path string
+
+	mu    sync.Mutex
+	users map[string]*User
+}
+
+// OpenUsers loads the accounts stored at path, if any.
+func OpenUsers(path string) (*Users, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	u := &Users{path: path, users: make(map[string]*User)}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var users []*User
+	if err := json.Unmarshal(b, &users); err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	for _, user := range users {
+		u.users[user.Name] = user
+	}
+	return u, nil
+}
+
+// write saves every account. It is called with mu held.
+func (u *Users) write() error {
+	users := make([]*User, 0, len(u.users))
+	for _, user := range u.users {
+		users = append(users, user)
+	}
+	return writeJSONFile(u.path, users)
+}
+
+// Create adds an account and reports whether it is the first one.
+func (u *Users) Create(name string, password string) (first bool, err error) {
+	if !validName.MatchString(name) {
+		return false, &invalidError{errors.New("names are 1 to 32 letters, digits, '.', '_' or '-'")}
+	}
+	if len(password) < minPasswordLength {
+		return false, &invalidError{errors.New("passwords have at least 8 characters")}
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return false, &invalidError{err}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.users[name]; ok {
+		return false, errExists
+	}
+	u.users[name] = &User{Name: name, Hash: hash, Created: time.Now().UTC()}
+	if err := u.write(); err != nil {
+		delete(u.users, name)
+		return false, err
+	}
+	return len(u.users) == 1, nil
+}
+
+// dummyHash is compared against the passwords given for unknown accounts, so
+// that they take as long to refuse as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// Check reports whether password is the one of the account name.
+func (u *Users) Check(name string, password string) bool {
+	u.mu.Lock()
+	user, ok := u.users[name]
+	u.mu.Unlock()
+	hash := dummyHash
+	if ok {
+		hash = user.Hash
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil && ok
+}
+
+// Exists reports whether there is an account called name.
+func (u *Users) Exists(name string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	_, ok := u.users[name]
+	return ok
+}
+
+// session is a signed in client.
+type session struct {
+	User    string
+	CSRF    string
+	Expires time.Time
+}
+
+// sessions are kept in memory only: restarting the server signs everyone out.
+type sessions struct {
+	mu      sync.Mutex
+	byToken map[string]*session
+}
+
+func newSessions() *sessions {
+	return &sessions{byToken: make(map[string]*session)}
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (s *sessions) create(user string) (token string, sess *session) {
+	token = randomToken()
+	sess = &session{User: user, CSRF: randomToken(), Expires: time.Now().Add(sessionTTL)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for t, old := range s.byToken {
+		if now.After(old.Expires) {
+			delete(s.byToken, t)
+		}
+	}
+	s.byToken[token] = sess
+	return token, sess
+}
+
+func (s *sessions) get(token string) (*session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.byToken[token]
+	if !ok || time.Now().After(sess.Expires) {
+		delete(s.byToken, token)
+		return nil, false
+	}
+	return sess, true
+}
+
+func (s *sessions) delete(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.byToken, token)
+}
+
+// auth signs users in and out:
+//
+//	POST /api/signup     create an account and sign in: {"name", "password"}
+//	POST /api/login      sign in: {"name", "password"}
+//	POST /api/logout     sign out
+//	GET  /api/session    the user signed in and the CSRF token of the session
+//
+// Signing in answers with the session, {"user", "csrf"}, like GET /api/session.
+type auth struct {
+	users    *Users
+	sessions *sessions
+	// secure sets the Secure attribute of the session cookie, so that it is
+	// only sent over HTTPS, or to localhost.
+	secure bool
+	// signup lets anyone create an account.
+	signup bool
+}
+
+type sessionInfo struct {
+	User string `json:"user"`
+	CSRF string `json:"csrf"`
+}
+
+type sessionKey struct{}
+
+// sessionOf returns the session of a request that went through signedIn.
+func sessionOf(r *http.Request) *session {
+	sess, _ := r.Context().Value(sessionKey{}).(*session)
+	return sess
+}
+
+// userOf returns the user who signed the request in.
+func userOf(r *http.Request) string {
+	if sess := sessionOf(r); sess != nil {
+		return sess.User
+	}
+	return ""
+}
+
+// safeMethod reports whether a request only reads.
+func safeMethod(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+}
+
+// sameOriginRequest reports whether a request comes from a page of the server
+// itself, as far as the browser tells: requests without an Origin header, such
+// as those of scripts, are accepted.
+func sameOriginRequest(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || sameOrigin(origin, r.Host)
+}
+
+// signedIn requires the request to come from a signed in user, with the CSRF
+// token of the session unless it only reads.
+func (a *api) signedIn(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(sessionCookie)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "not signed in")
+			return
+		}
+		sess, ok := a.auth.sessions.get(c.Value)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "session expired")
+			return
+		}
+		if !safeMethod(r) {
+			token := r.Header.Get(csrfHeader)
+			if !sameOriginRequest(r) || subtle.ConstantTimeCompare([]byte(token), []byte(sess.CSRF)) != 1 {
+				writeError(w, http.StatusForbidden, "missing or invalid CSRF token")
+				return
+			}
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, sess)))
+	}
+}
+
+// roleIn returns the role a request acts with on l: the role of the user
+// signed in, or viewer for a valid share link of l.
+func roleIn(r *http.Request, l *List) string {
+	if c := shareOf(r); c != nil {
+		if l.shareValid(*c, time.Now()) {
+			return roleViewer
+		}
+		return ""
+	}
+	return l.Role(userOf(r))
+}
+
+// roleOf returns the role a request acts with on the list id.
+func (a *api) roleOf(r *http.Request, id string) (role string, err error) {
+	err = a.store.View(id, func(l *List) {
+		role = roleIn(r, l)
+	})
+	return role, err
+}
+
+// roleError reports a request acting with a role below the one required.
+type roleError struct {
+	role string
+}
+
+func (e *roleError) Error() string { return "the " + e.role + "s of this list cannot do this" }
+func (e *roleError) Unwrap() error { return errForbidden }
+
+// checkRole returns an error unless the request acts with at least role on l.
+// Lists the request has no access to are reported as not found.
+func checkRole(r *http.Request, l *List, role string) error {
+	have := roleIn(r, l)
+	if have == "" {
+		return errNotFound
+	}
+	if !hasRole(have, role) {
+		return &roleError{have}
+	}
+	return nil
+}
+
+// allow requires the request to be signed in by a user with at least role on
+// the list of the request, as told by checkRole.
+func (a *api) allow(role string, h http.HandlerFunc) http.HandlerFunc {
+	return a.signedIn(a.check(role, h))
+}
+
+// allowShared is allow for the endpoints that share links open too.
+func (a *api) allowShared(role string, h http.HandlerFunc) http.HandlerFunc {
+	check := a.check(role, h)
+	return a.shared(check, a.signedIn(check))
+}
+
+// check calls h if the request acts with at least role on the list of the
+// request.
+func (a *api) check(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		if verr := a.store.View(r.PathValue("id"), func(l *List) {
+			err = checkRole(r, l, role)
+		}); verr != nil {
+			err = verr
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// startSession signs user in and answers with the session.
+func (a *api) startSession(w http.ResponseWriter, status int, user string) {
+	token, sess := a.auth.sessions.create(user)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  sess.Expires,
+		HttpOnly: true,
+		Secure:   a.auth.secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	writeJSON(w, status, sessionInfo{User: user, CSRF: sess.CSRF})
+}
+
+type credentials struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+func (a *api) signup(w http.ResponseWriter, r *http.Request) {
+	if !a.auth.signup {
+		writeError(w, http.StatusForbidden, "sign up is disabled")
+		return
+	}
+	if !sameOriginRequest(r) {
+		writeError(w, http.StatusForbidden, "cross-origin request refused")
+		return
+	}
+	var req credentials
+	if !readJSON(w, r, &req) {
+		return
+	}
+	first, err := a.auth.users.Create(req.Name, req.Password)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	// The first account owns the lists made before there were accounts.
+	if first {
+		if err := a.store.Adopt(req.Name); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	a.startSession(w, http.StatusCreated, req.Name)
+}
+
+func (a *api) login(w http.ResponseWriter, r *http.Request) {
+	if !sameOriginRequest(r) {
+		writeError(w, http.StatusForbidden, "cross-origin request refused")
+		return
+	}
+	var req credentials
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if !a.auth.users.Check(req.Name, req.Password) {
+		writeError(w, http.StatusUnauthorized, errBadCredentials.Error())
+		return
+	}
+	a.startSession(w, http.StatusOK, req.Name)
+}
+
+func (a *api) logout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		a.auth.sessions.delete(c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   a.auth.secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) getSession(w http.ResponseWriter, r *http.Request) {
+	sess := sessionOf(r)
+	writeJSON(w, http.StatusOK, sessionInfo{User: sess.User, CSRF: sess.CSRF})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// sseEvent is the data of an event of the events endpoint.
+type sseEvent struct {
+	Revision int64        `json:"revision"`
+	ID       string       `json:"id,omitempty"`
+	Todo     *model.Todo  `json:"todo,omitempty"`
+	Fields   []string     `json:"fields,omitempty"`
+	After    *string      `json:"after,omitempty"`
+	Todos    []model.Todo `json:"todos"`
+}
+
+// sseNames are the event names of the op kinds.
+var sseNames = map[string]string{
+	model.OpCreate:  "created",
+	model.OpToggle:  "updated",
+	model.OpRetitle: "updated",
+	model.OpUpdate:  "updated",
+	model.OpDelete:  "deleted",
+	model.OpReorder: "moved",
+}
+
+const sseKeepAlive = 30 * time.Second
+
+// events streams the changes of a list as Server-Sent Events:
+//
+//	GET /api/lists/{id}/events
+//
+// The stream starts with a "snapshot" event holding every todo, unless the
+// client resumes with a Last-Event-ID header or a since query parameter naming
+// a revision whose next changes are still known. Each change is then sent as
+// "created", "updated", "deleted" and "moved" events, one per todo, carrying
+// the revision, or as a single "revision" event when no todo changed. Only the
+// last event of a revision has an id, the revision, so that a client resuming
+// after an interruption gets every event of the revisions it did not complete.
+func (a *api) events(w http.ResponseWriter, r *http.Request) {
+	since, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	if s := r.URL.Query().Get("since"); s != "" {
+		since, _ = strconv.ParseInt(s, 10, 64)
+	}
+	id := r.PathValue("id")
+	l, backlog, complete, changes, cancel, err := a.store.Subscribe(id, since)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	defer cancel()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(name string, id string, data sseEvent) error {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if id != "" {
+			fmt.Fprintf(w, "id: %s\n", id)
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, b)
+		return nil
+	}
+	sendChange := func(c Change) error {
+		if len(c.Ops) == 0 {
+			// Changes of the list itself, such as its title, still advance
+			// the revision.
+			if err := send("revision", strconv.FormatInt(c.Revision, 10), sseEvent{Revision: c.Revision}); err != nil {
+				return err
+			}
+		}
+		for i, op := range c.Ops {
+			id := ""
+			if i == len(c.Ops)-1 {
+				id = strconv.FormatInt(c.Revision, 10)
+			}
+			e := sseEvent{Revision: c.Revision, ID: op.ID, Todo: op.Todo, Fields: op.Fields}
+			if op.Kind == model.OpCreate || op.Kind == model.OpReorder {
+				after := op.After
+				e.After = &after
+			}
+			if err := send(sseNames[op.Kind], id, e); err != nil {
+				return err
+			}
+		}
+		return rc.Flush()
+	}
+
+	if complete {
+		for _, c := range backlog {
+			if err := sendChange(c); err != nil {
+				return
+			}
+		}
+	} else {
+		send("snapshot", strconv.FormatInt(l.Revision, 10), sseEvent{Revision: l.Revision, Todos: l.Todos})
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(sseKeepAlive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case c, ok := <-changes:
+			if !ok {
+				// The client reconnects with the id of the last revision it
+				// completed.
+				return
+			}
+			if role, _ := a.roleOf(r, id); role == "" {
+				// Removed from the members of the list, or the share
+				// link was revoked.
+				return
+			}
+			if err := sendChange(c); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
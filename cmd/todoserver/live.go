@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// Viewer is a client connected to the live endpoint of a list.
+type Viewer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// presence tracks the viewers of each list.
+type presence struct {
+	mu    sync.Mutex
+	lists map[string]map[string]chan []Viewer
+	names map[string]map[string]Viewer
+}
+
+func newPresence() *presence {
+	return &presence{
+		lists: make(map[string]map[string]chan []Viewer),
+		names: make(map[string]map[string]Viewer),
+	}
+}
+
+// join adds a viewer to a list and returns the channel on which the viewers
+// of the list are sent whenever they change.
+func (p *presence) join(list string, v Viewer) <-chan []Viewer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lists[list] == nil {
+		p.lists[list] = make(map[string]chan []Viewer)
+		p.names[list] = make(map[string]Viewer)
+	}
+	ch := make(chan []Viewer, 1)
+	p.lists[list][v.ID] = ch
+	p.names[list][v.ID] = v
+	p.broadcast(list)
+	return ch
+}
+
+func (p *presence) leave(list string, v Viewer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.lists[list], v.ID)
+	delete(p.names[list], v.ID)
+	if len(p.lists[list]) == 0 {
+		delete(p.lists, list)
+		delete(p.names, list)
+		return
+	}
+	p.broadcast(list)
+}
+
+// broadcast sends the viewers of a list to each of them, replacing any
+// update not received yet. It is called with mu held.
+func (p *presence) broadcast(list string) {
+	viewers := make([]Viewer, 0, len(p.names[list]))
+	for _, v := range p.names[list] {
+		viewers = append(viewers, v)
+	}
+	sort.Slice(viewers, func(i, j int) bool { return viewers[i].Name < viewers[j].Name })
+	for _, ch := range p.lists[list] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- viewers
+	}
+}
+
+// liveMessage is a message of the live endpoint.
+//
+// The server sends:
+//   - "snapshot": every todo of the list at a revision
+//   - "change": the ops of the next revision
+//   - "presence": the viewers of the list, You being the connection itself
+//   - "error": a message refused, with the reason in Error
+//
+// The client sends "ops" messages, applied to the list like a POST to
+// /api/lists/{id}/ops.
+type liveMessage struct {
+	Type     string       `json:"type"`
+	Revision int64        `json:"revision,omitempty"`
+	Todos    []model.Todo `json:"todos"`
+	Ops      []model.Op   `json:"ops,omitempty"`
+	Viewers  []Viewer     `json:"viewers,omitempty"`
+	You      string       `json:"you,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+const livePingInterval = 30 * time.Second
+
+// live streams the changes of a list over a WebSocket:
+//
+//	GET /api/lists/{id}/live?since=revision
+//
+// A client that saw the list at revision since gets the changes made after it,
+// or a snapshot when they are no longer known or since is missing. Viewers are
+// shown under the name of their account, and only editors and owners can send
+// ops: not the holders of a share link.
+func (a *api) live(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	l, backlog, complete, changes, cancel, err := a.store.Subscribe(id, since)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	defer cancel()
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	send := func(m liveMessage) error {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		return conn.WriteText(b)
+	}
+
+	if complete {
+		for _, c := range backlog {
+			if err := send(liveMessage{Type: "change", Revision: c.Revision, Ops: c.Ops}); err != nil {
+				return
+			}
+		}
+	} else if err := send(liveMessage{Type: "snapshot", Revision: l.Revision, Todos: l.Todos}); err != nil {
+		return
+	}
+
+	user := userOf(r)
+	v := Viewer{ID: newID(), Name: user}
+	viewers := a.presence.join(id, v)
+	defer a.presence.leave(id, v)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			b, err := conn.ReadMessage()
+			if err != nil {
+				if !errors.Is(err, errWSClosed) {
+					log.Print("live: ", err)
+				}
+				return
+			}
+			var m liveMessage
+			if err := json.Unmarshal(b, &m); err != nil || m.Type != "ops" {
+				send(liveMessage{Type: "error", Error: "invalid message"})
+				continue
+			}
+			// The upgrade of a share link is a GET like any other, let in
+			// by shared: the link stays read-only all the same.
+			if shareOf(r) != nil {
+				send(liveMessage{Type: "error", Error: "share links are read-only"})
+				continue
+			}
+			// The role is checked for every message, as it may have
+			// changed since the connection was opened.
+			role, err := a.roleOf(r, id)
+			if err == nil && role == "" {
+				err = errNotFound
+			}
+			if err != nil {
+				send(liveMessage{Type: "error", Error: err.Error()})
+				continue
+			}
+			if !hasRole(role, roleEditor) {
+				send(liveMessage{Type: "error", Error: "the " + role + "s of this list cannot do this"})
+				continue
+			}
+			_, err = a.store.UpdateBy(id, userOf(r), func(l *List) error {
+				l.applyOps(m.Ops)
+				return nil
+			})
+			if err != nil {
+				send(liveMessage{Type: "error", Error: err.Error()})
+			}
+		}
+	}()
+
+	ping := time.NewTicker(livePingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case c, ok := <-changes:
+			if !ok {
+				// Dropped for not keeping up: the client reconnects and
+				// catches up from its last revision.
+				return
+			}
+			if role, _ := a.roleOf(r, id); role == "" {
+				// Removed from the members of the list, or the share
+				// link was revoked.
+				return
+			}
+			if err := send(liveMessage{Type: "change", Revision: c.Revision, Ops: c.Ops}); err != nil {
+				return
+			}
+		case vs := <-viewers:
+			if err := send(liveMessage{Type: "presence", Viewers: vs, You: v.ID}); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.Ping(); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// liveClient is the client side of a live connection, enough for tests.
+type liveClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dialLive opens the live endpoint of the list id with the headers of h.
+func (s *testServer) dialLive(t *testing.T, id string, query string, h http.Header) *liveClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	req, err := http.NewRequest("GET", s.URL+"/api/lists/"+id+"/live"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("live: status %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	return &liveClient{t: t, conn: conn, br: br}
+}
+
+// send sends m in a single masked text frame.
+func (c *liveClient) send(m liveMessage) {
+	c.t.Helper()
+	payload, err := json.Marshal(m)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | wsText}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	default:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// next returns the next message of type typ, skipping the others.
+func (c *liveClient) next(typ string) liveMessage {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var h [2]byte
+		if _, err := io.ReadFull(c.br, h[:]); err != nil {
+			c.t.Fatalf("waiting for a %q message: %v", typ, err)
+		}
+		n := uint64(h[1] & 0x7F)
+		switch n {
+		case 126:
+			var b [2]byte
+			io.ReadFull(c.br, b[:])
+			n = uint64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			io.ReadFull(c.br, b[:])
+			n = binary.BigEndian.Uint64(b[:])
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			c.t.Fatalf("waiting for a %q message: %v", typ, err)
+		}
+		if h[0]&0x0F != wsText {
+			continue
+		}
+		var m liveMessage
+		if err := json.Unmarshal(payload, &m); err != nil {
+			c.t.Fatal(err)
+		}
+		if m.Type == typ {
+			return m
+		}
+	}
+}
+
+var createOp = liveMessage{Type: "ops", Ops: []model.Op{{
+	Kind: model.OpCreate,
+	ID:   "t1",
+	Todo: &model.Todo{ID: "t1", Title: "Buy milk"},
+}}}
+
+func TestLiveViewerOps(t *testing.T) {
+	s := newTestServer(t)
+	s.newTestList(t, "l", "alice", "bob", roleViewer)
+
+	cookie, _ := s.signIn("bob")
+	c := s.dialLive(t, "l", "", http.Header{"Cookie": {cookie.String()}})
+	c.next("snapshot")
+	c.send(createOp)
+	if m := c.next("error"); m.Error != "the viewers of this list cannot do this" {
+		t.Errorf("error = %q", m.Error)
+	}
+	l, err := s.api.store.Get("l")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Todos) != 0 {
+		t.Errorf("ops of a viewer applied: %v", l.Todos)
+	}
+}
+
+func TestLiveEditorOps(t *testing.T) {
+	s := newTestServer(t)
+	s.newTestList(t, "l", "alice", "bob", roleEditor)
+
+	cookie, _ := s.signIn("bob")
+	c := s.dialLive(t, "l", "", http.Header{"Cookie": {cookie.String()}})
+	c.next("snapshot")
+	c.send(createOp)
+	if m := c.next("change"); len(m.Ops) != 1 || m.Ops[0].ID != "t1" {
+		t.Errorf("change = %+v", m)
+	}
+	l, err := s.api.store.Get("l")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Todos) != 1 || l.Todos[0].Title != "Buy milk" {
+		t.Errorf("todos = %v", l.Todos)
+	}
+}
+
+func TestLiveDemotedOps(t *testing.T) {
+	s := newTestServer(t)
+	s.newTestList(t, "l", "alice", "bob", roleEditor)
+
+	cookie, _ := s.signIn("bob")
+	c := s.dialLive(t, "l", "", http.Header{"Cookie": {cookie.String()}})
+	c.next("snapshot")
+	_, err := s.api.store.Update("l", func(l *List) error {
+		l.Members["bob"] = roleViewer
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.next("change")
+	c.send(createOp)
+	c.next("error")
+	l, err := s.api.store.Get("l")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Todos) != 0 {
+		t.Errorf("ops of a demoted editor applied: %v", l.Todos)
+	}
+}
+
+func TestLiveShareOps(t *testing.T) {
+	for _, members := range [][]string{nil, {"bob", roleEditor}} {
+		s := newTestServer(t)
+		owner := ""
+		if members != nil {
+			owner = "alice"
+		}
+		s.newTestList(t, "l", owner, members...)
+		l, err := s.api.store.Update("l", func(l *List) error {
+			l.Shares = map[string]Share{"s": {Created: time.Now().UTC(), By: owner}}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		token := s.api.shareInfo(l, "s").Token
+
+		// Signed in or not, a share link only reads.
+		cookie, _ := s.signIn("bob")
+		for _, h := range []http.Header{{}, {"Cookie": {cookie.String()}}} {
+			c := s.dialLive(t, "l", "?"+shareParam+"="+token, h)
+			c.next("snapshot")
+			c.send(createOp)
+			if m := c.next("error"); m.Error != "share links are read-only" {
+				t.Errorf("members %v, headers %v: error = %q", members, h, m.Error)
+			}
+		}
+		l, err = s.api.store.Get("l")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(l.Todos) != 0 {
+			t.Errorf("members %v: ops of a share link applied: %v", members, l.Todos)
+		}
+	}
+}
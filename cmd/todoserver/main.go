@@ -0,0 +1,101 @@
+// Command todoserver serves a shared todo list: the REST API used by the http
+// store of the client, and the built client itself.
+//
+// Usage:
+//
+//	todoserver [-addr :8080] [-data ./data] [-client ./dev/build/app] [-signup=true] [-secure-cookies=true]
+//
+// Lists are kept as JSON files in the data directory, their activity logs in
+// data/activity, and accounts in data/accounts/users.json along with the key
+// signing share links. A list named "default", the one the client uses unless
+// configured otherwise, is created on first start and owned by the first
+// account signing up.
+//
+// Pages of the client that open a list of the server are rendered with its
+// todos, for the members signed in and the holders of a share link.
+//
+// Session cookies are only sent over HTTPS, or to localhost, unless
+// -secure-cookies=false.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// clientHandler serves the files of the built client. Paths that are not
+// files, such as the routes of the client, get its index.html, rendered with
+// the list it opens (see render.go).
+func (a *api) clientHandler(dir string) http.Handler {
+	cfg := readClientConfig(dir)
+	// Added by cmd/pwa, and unknown to the mime package.
+	mime.AddExtensionType(".webmanifest", "application/manifest+json")
+	files := http.FileServer(http.Dir(dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The URL of a page may hold a share token (see share.go).
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		p := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		if fi, err := os.Stat(p); err != nil || fi.IsDir() {
+			a.servePage(w, r, dir, cfg)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	data := flag.String("data", "data", "directory where lists are stored")
+	client := flag.String("client", filepath.Join("dev", "build", "app"), "directory of the built client, not served if empty")
+	signup := flag.Bool("signup", true, "let anyone create an account")
+	secure := flag.Bool("secure-cookies", true, "only send session cookies over HTTPS or to localhost")
+	flag.Parse()
+
+	store, err := OpenStore(*data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := store.Get("default"); err == errNotFound {
+		if _, err := store.Create("default", "Todos", ""); err != nil {
+			log.Fatal(err)
+		}
+	}
+	users, err := OpenUsers(filepath.Join(*data, "accounts", "users.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	shares, err := openShareSigner(filepath.Join(*data, "accounts", "share.key"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	a := &api{
+		store:    store,
+		presence: newPresence(),
+		auth:     &auth{users: users, sessions: newSessions(), secure: *secure, signup: *signup},
+		shares:   shares,
+	}
+	a.register(mux)
+	if *client != "" {
+		mux.Handle("/", a.clientHandler(*client))
+	}
+
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// The members of a list are managed by its owners:
+//
+//	GET    /api/lists/{id}/members           every member: {"members": {user: role}}
+//	PUT    /api/lists/{id}/members/{user}    add a member or change its role: {"role"}
+//	DELETE /api/lists/{id}/members/{user}    remove a member
+//
+// A list always keeps at least one owner.
+
+var errLastOwner = errors.New("a list keeps at least one owner")
+
+// checkOwners fails when a change leaves a list without owner.
+func checkOwners(l *List) error {
+	for _, role := range l.Members {
+		if role == roleOwner {
+			return nil
+		}
+	}
+	return &invalidError{errLastOwner}
+}
+
+func (a *api) getMembers(w http.ResponseWriter, r *http.Request) {
+	l, err := a.store.Get(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	members := l.Members
+	if members == nil {
+		members = map[string]string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"members": members})
+}
+
+func (a *api) setMember(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	user := r.PathValue("user")
+	if roleRanks[req.Role] == 0 {
+		writeStoreError(w, &invalidError{errors.New("roles are viewer, editor or owner")})
+		return
+	}
+	if !a.auth.users.Exists(user) {
+		writeStoreError(w, &invalidError{errors.New("unknown user " + user)})
+		return
+	}
+	l, err := a.store.Update(r.PathValue("id"), func(l *List) error {
+		if l.Members == nil {
+			l.Members = make(map[string]string)
+		}
+		l.Members[user] = req.Role
+		return checkOwners(l)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"members": l.Members})
+}
+
+func (a *api) removeMember(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	_, err := a.store.Update(r.PathValue("id"), func(l *List) error {
+		if _, ok := l.Members[user]; !ok {
+			return errNotFound
+		}
+		delete(l.Members, user)
+		return checkOwners(l)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
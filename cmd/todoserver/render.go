@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// Pages of the client are rendered on the server when the client opens a list
+// of the server, that is when it uses the http backend: the todos of the list
+// are rendered in index.html as the client would render them, for the route
+// requested, so that they show before the client has loaded and can be read
+// without JavaScript.
+//
+// The rendered elements are marked with a data-ssr attribute: the client
+// removes them once it has built its own elements in their place. The list is
+// embedded along with its revision, in the ssrstate script element, so that
+// the client starts from it without loading it again (see src/rendered.go).
+//
+// Pages are only rendered for requests that may read the list: those of a
+// member signed in, or holding a share link of the list. Other requests get
+// index.html as it is, and the client asks the user to sign in.
+
+// clientConfig is the part of zui.config.json telling which list the client
+// opens.
+type clientConfig struct {
+	Store struct {
+		Backend  string `json:"backend"`
+		Endpoint string `json:"endpoint"`
+	} `json:"store"`
+}
+
+// readClientConfig reads the zui.config.json of the built client in dir, if
+// any.
+func readClientConfig(dir string) clientConfig {
+	var cfg clientConfig
+	b, err := os.ReadFile(filepath.Join(dir, "zui.config.json"))
+	if err != nil {
+		return cfg
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		log.Print("zui.config.json: ", err)
+	}
+	return cfg
+}
+
+// pageList returns the id of the list of the server opened by a page of the
+// client, read like the client does from the store and endpoint query
+// parameters, then from cfg.
+func pageList(r *http.Request, cfg clientConfig) (id string, ok bool) {
+	backend, endpoint := cfg.Store.Backend, cfg.Store.Endpoint
+	q := r.URL.Query()
+	if b := q.Get("store"); b != "" {
+		backend = b
+	}
+	if e := q.Get("endpoint"); e != "" {
+		endpoint = e
+	}
+	if backend != "http" {
+		return "", false
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host != "" && u.Host != r.Host {
+		return "", false
+	}
+	id, ok = strings.CutPrefix(u.Path, "/api/lists/")
+	return id, ok && id != "" && !strings.Contains(id, "/")
+}
+
+// pageFilters are the routes of the client, which filter the todos shown.
+var pageFilters = []string{"all", "active", "completed", "activity"}
+
+// pageFilter returns the filter selected by the route of a page.
+func pageFilter(r *http.Request) string {
+	route := strings.Trim(r.URL.Path, "/")
+	for _, f := range pageFilters {
+		if route == f {
+			return f
+		}
+	}
+	return "all"
+}
+
+// pageRole returns the role with which a page may show l: that of the user
+// signed in, or viewer for a valid share link of l.
+func (a *api) pageRole(r *http.Request, id string, l *List) string {
+	if token := r.URL.Query().Get(shareParam); token != "" {
+		c, err := a.shares.parse(token)
+		if err != nil || c.List != id || !l.shareValid(c, time.Now()) {
+			return ""
+		}
+		return roleViewer
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+	sess, ok := a.auth.sessions.get(c.Value)
+	if !ok {
+		return ""
+	}
+	return l.Role(sess.User)
+}
+
+// ssrState is embedded in rendered pages for the client to start from.
+type ssrState struct {
+	List  string       `json:"list"`
+	ETag  string       `json:"etag"`
+	Todos []model.Todo `json:"todos"`
+}
+
+// pageData is what the page template renders.
+type pageData struct {
+	Todos      []model.Todo // the todos shown by the filter
+	Filter     string
+	Filters    []string
+	Count      int // todos left
+	Completed  int
+	Empty      bool
+	ReadOnly   bool
+	AllChecked bool
+	State      ssrState
+}
+
+func (p pageData) Plural() bool {
+	return p.Count != 1
+}
+
+var pageTemplate = template.Must(template.New("page").Funcs(template.FuncMap{
+	"state": func(s ssrState) (template.JS, error) {
+		b, err := json.Marshal(s)
+		return template.JS(b), err
+	},
+}).Parse(`<section id="todoapp" class="todoapp" data-ssr>
+<header id="header" class="header" data-ssr>
+<h1 id="apptitle" data-ssr>Todo</h1>
+<input id="new-todo" class="new-todo" type="text" placeholder="What needs to be done?" autofocus data-ssr>
+</header>
+<section id="main" class="main{{if eq .Filter "activity"}} activity-view{{end}}" style="display:{{if and .Empty (ne .Filter "activity")}}none{{else}}block{{end}}" data-ssr>
+<input id="toggle-all" class="toggle-all" type="checkbox"{{if .AllChecked}} checked{{end}} data-ssr>
+<label id="toggle-all-label" for="toggle-all" data-ssr></label>
+<ul id="todo-list" class="todo-list" data-ssr>
+{{- range .Todos}}
+<li id="{{.ID}}" class="{{if .Completed}}completed{{end}}{{if $.ReadOnly}} readonly{{end}}" data-ssr>
+<div id="{{.ID}}-view" class="view" data-ssr>
+<input id="{{.ID}}-completed" class="toggle" type="checkbox"{{if .Completed}} checked{{end}}{{if $.ReadOnly}} disabled{{end}} data-ssr>
+<label id="{{.ID}}-label" data-ssr>{{.Title}}</label>
+<span id="{{.ID}}-meta" class="meta" data-ssr>{{.Meta}}</span>
+<button id="{{.ID}}-details-btn" type="button" class="details-toggle" data-ssr></button>
+<button id="{{.ID}}-btn" type="button" class="destroy" data-ssr></button>
+</div>
+</li>
+{{- end}}
+</ul>
+</section>
+<footer id="footer" class="footer" style="display:{{if .Empty}}none{{else}}block{{end}}" data-ssr>
+<span id="todo-count" class="todo-count" data-ssr><strong>{{.Count}}</strong> {{if .Plural}}items{{else}}item{{end}} left</span>
+<ul id="filters" class="filters" data-ssr>
+{{- range .Filters}}
+<li id="{{.}}-filter" data-ssr><a id="{{.}}-filter-anchor" href="/{{.}}"{{if eq . $.Filter}} class="selected"{{end}} data-ssr>{{.}}</a></li>
+{{- end}}
+</ul>
+<button id="clear-complete" type="button" class="clear-completed" style="display:{{if or (eq .Completed 0) .ReadOnly}}none{{else}}block{{end}}" data-ssr>Clear completed</button>
+</footer>
+</section>
+<script id="ssrstate" type="application/json">{{state .State}}</script>
+`))
+
+// renderPage renders the todos of a list as shown by the client on the route
+// selecting filter.
+func renderPage(list string, etag string, todos []model.Todo, filter string, readonly bool) ([]byte, error) {
+	p := pageData{
+		Filter:   filter,
+		Filters:  pageFilters,
+		Empty:    len(todos) == 0,
+		ReadOnly: readonly,
+		State:    ssrState{List: list, ETag: etag, Todos: todos},
+	}
+	p.AllChecked = !p.Empty
+	for _, t := range todos {
+		if t.Completed {
+			p.Completed++
+		} else {
+			p.Count++
+			p.AllChecked = false
+		}
+		switch {
+		case filter == "activity":
+		case filter == "active" && t.Completed, filter == "completed" && !t.Completed:
+		default:
+			p.Todos = append(p.Todos, t)
+		}
+	}
+	if p.State.Todos == nil {
+		p.State.Todos = []model.Todo{}
+	}
+	var b bytes.Buffer
+	err := pageTemplate.Execute(&b, p)
+	return b.Bytes(), err
+}
+
+var (
+	headTag = regexp.MustCompile(`(?i)</head>`)
+	bodyTag = regexp.MustCompile(`(?i)<body([^>]*)>`)
+	// classAttr matches the class attribute of a tag, its value quoted or
+	// not.
+	classAttr = regexp.MustCompile(`(?i)\sclass\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// stylesheet is the stylesheet of the client, which it adds itself once
+// started.
+const stylesheet = `<link id="todocss" rel="stylesheet" href="./assets/styles/todomvc.css">`
+
+// renderInto inserts a rendered page into index.html: the stylesheet in its
+// head and the page at the start of its body, which is marked read-only for
+// viewers like the client does.
+func renderInto(index []byte, page []byte, readonly bool) []byte {
+	head := headTag.FindIndex(index)
+	body := bodyTag.FindSubmatchIndex(index)
+	if head == nil || body == nil || head[0] > body[0] {
+		return index
+	}
+	attrs := string(index[body[2]:body[3]])
+	if readonly {
+		if c := classAttr.FindStringSubmatchIndex(attrs); c != nil {
+			class := "readonly"
+			for i := 2; i < len(c); i += 2 {
+				if c[i] >= 0 && c[i] < c[i+1] {
+					class += " " + attrs[c[i]:c[i+1]]
+				}
+			}
+			attrs = attrs[:c[0]] + ` class="` + class + `"` + attrs[c[1]:]
+		} else {
+			attrs += ` class="readonly"`
+		}
+	}
+
+	var b bytes.Buffer
+	b.Write(index[:head[0]])
+	b.WriteString(stylesheet + "\n")
+	b.Write(index[head[0]:body[0]])
+	b.WriteString("<body" + attrs + ">\n")
+	b.Write(page)
+	b.Write(index[body[1]:])
+	return b.Bytes()
+}
+
+// servePage serves index.html, rendered with the list the page opens when the
+// request may read it.
+func (a *api) servePage(w http.ResponseWriter, r *http.Request, dir string, cfg clientConfig) {
+	index := filepath.Join(dir, "index.html")
+	id, ok := pageList(r, cfg)
+	if !ok {
+		http.ServeFile(w, r, index)
+		return
+	}
+	var (
+		todos []model.Todo
+		etag  string
+		role  string
+	)
+	err := a.store.View(id, func(l *List) {
+		role = a.pageRole(r, id, l)
+		todos = append([]model.Todo(nil), l.Todos...)
+		etag = listETag(l)
+	})
+	if err != nil || role == "" {
+		http.ServeFile(w, r, index)
+		return
+	}
+	html, err := os.ReadFile(index)
+	if err != nil {
+		http.ServeFile(w, r, index)
+		return
+	}
+	readonly := !hasRole(role, roleEditor)
+	page, err := renderPage(id, etag, todos, pageFilter(r), readonly)
+	if err != nil {
+		log.Print("unable to render the page: ", err)
+		http.ServeFile(w, r, index)
+		return
+	}
+	// Rendered pages depend on the user and on the list.
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Vary", "Cookie")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(renderInto(html, page, readonly))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderIntoReadOnly(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`<body>`, `<body class="readonly">`},
+		{`<body id="app">`, `<body id="app" class="readonly">`},
+		{`<body class="dark">`, `<body class="readonly dark">`},
+		{`<body CLASS='dark wide' id="app">`, `<body class="readonly dark wide" id="app">`},
+		{`<body class=dark>`, `<body class="readonly dark">`},
+		{`<body class="">`, `<body class="readonly">`},
+		{`<body data-class="x">`, `<body data-class="x" class="readonly">`},
+	}
+	for _, tt := range tests {
+		index := "<html><head></head>" + tt.body + "</body></html>"
+		got := string(renderInto([]byte(index), []byte("page"), true))
+		if !strings.Contains(got, tt.want+"\npage") {
+			t.Errorf("renderInto(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+
+	index := `<html><head></head><body class="dark"></body></html>`
+	if got := string(renderInto([]byte(index), nil, false)); !strings.Contains(got, `<body class="dark">`) {
+		t.Errorf("renderInto for an editor = %q", got)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Share links open a list read-only, without an account. They are managed by
+// the owners of the list:
+//
+//	GET    /api/lists/{id}/shares            every link: {"shares": [...]}
+//	POST   /api/lists/{id}/shares            create a link: {"expires_in": seconds}, 0 for none, or 1, 7 or 30 days
+//	DELETE /api/lists/{id}/shares/{share}    revoke a link
+//
+// A link carries a token signed by the server, naming the list, the share and
+// its expiry. The token is sent back in the X-Share-Token header, or the share
+// query parameter of the live and events endpoints, and grants the viewer role
+// as long as the share is not revoked or expired, for GET requests of the list
+// and its todos, events and live changes only: the members and the activity of
+// the list stay private. Pages opened with the token in their URL are served
+// with no referrer, so that it does not leak to the sites they link to.
+
+// Share is a link to a list, as kept in the list.
+type Share struct {
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+	By      string     `json:"by"`
+}
+
+// shareClaims are the contents of a share token.
+type shareClaims struct {
+	List    string
+	Share   string
+	Expires int64 // Unix time, 0 if the link does not expire
+}
+
+const (
+	shareHeader = "X-Share-Token"
+	shareParam  = "share"
+)
+
+var errInvalidShare = errors.New("invalid share link")
+
+// shareExpiries are the lifetimes, in seconds, that a link may be created
+// with, 0 standing for none.
+var shareExpiries = map[int64]bool{
+	0:              true,
+	24 * 3600:      true,
+	7 * 24 * 3600:  true,
+	30 * 24 * 3600: true,
+}
+
+// shareSigner signs and verifies share tokens with a key kept in the data
+// directory, so that links survive restarts.
+type shareSigner struct {
+	key []byte
+}
+
+// openShareSigner reads the key at path, creating it on first start.
+func openShareSigner(path string) (*shareSigner, error) {
+	key, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, key, 0o600); err != nil {
+			return nil, err
+		}
+		return &shareSigner{key: key}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < 32 {
+		return nil, errors.New(path + ": key too short")
+	}
+	return &shareSigner{key: key}, nil
+}
+
+func (s *shareSigner) mac(payload string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+func (s *shareSigner) sign(c shareClaims) string {
+	payload := c.List + "." + c.Share + "." + strconv.FormatInt(c.Expires, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(s.mac(payload))
+}
+
+func (s *shareSigner) parse(token string) (shareClaims, error) {
+	p, m, ok := strings.Cut(token, ".")
+	if !ok {
+		return shareClaims{}, errInvalidShare
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return shareClaims{}, errInvalidShare
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(m)
+	if err != nil || !hmac.Equal(mac, s.mac(string(payload))) {
+		return shareClaims{}, errInvalidShare
+	}
+	parts := strings.Split(string(payload), ".")
+	if len(parts) != 3 {
+		return shareClaims{}, errInvalidShare
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return shareClaims{}, errInvalidShare
+	}
+	return shareClaims{List: parts[0], Share: parts[1], Expires: expires}, nil
+}
+
+// shareValid reports whether c names a share of the list that is neither
+// revoked nor expired at now.
+func (l *List) shareValid(c shareClaims, now time.Time) bool {
+	s, ok := l.Shares[c.Share]
+	if !ok || c.List != l.ID {
+		return false
+	}
+	if s.Expires == nil {
+		return c.Expires == 0
+	}
+	return c.Expires == s.Expires.Unix() && now.Before(*s.Expires)
+}
+
+type shareKey struct{}
+
+// shareOf returns the claims of the share token of a request, if it was let
+// in by one.
+func shareOf(r *http.Request) *shareClaims {
+	c, _ := r.Context().Value(shareKey{}).(*shareClaims)
+	return c
+}
+
+// shareToken returns the share token sent with a request.
+func shareToken(r *http.Request) string {
+	if t := r.Header.Get(shareHeader); t != "" {
+		return t
+	}
+	return r.URL.Query().Get(shareParam)
+}
+
+// shared lets in the GET requests made with a share token of the list of the
+// request, as viewers, and passes the others on to next. Handlers reading
+// messages from their GET requests, like the live endpoint, must refuse those
+// that would change the list when shareOf is set.
+func (a *api) shared(h http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := shareToken(r)
+		if token == "" {
+			next(w, r)
+			return
+		}
+		c, err := a.shares.parse(token)
+		if err != nil || c.List != r.PathValue("id") {
+			writeError(w, http.StatusUnauthorized, errInvalidShare.Error())
+			return
+		}
+		if !safeMethod(r) {
+			writeError(w, http.StatusForbidden, "share links are read-only")
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), shareKey{}, &c)))
+	}
+}
+
+// shareInfo describes a share to the owners of the list.
+type shareInfo struct {
+	ID      string     `json:"id"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+	By      string     `json:"by"`
+	Token   string     `json:"token"`
+	URL     string     `json:"url"`
+}
+
+func (a *api) shareInfo(l *List, id string) shareInfo {
+	s := l.Shares[id]
+	c := shareClaims{List: l.ID, Share: id}
+	if s.Expires != nil {
+		c.Expires = s.Expires.Unix()
+	}
+	token := a.shares.sign(c)
+	q := url.Values{
+		"store":    {"http"},
+		"endpoint": {"/api/lists/" + l.ID},
+		shareParam: {token},
+	}
+	return shareInfo{ID: id, Created: s.Created, Expires: s.Expires, By: s.By, Token: token, URL: "/?" + q.Encode()}
+}
+
+func (a *api) getShares(w http.ResponseWriter, r *http.Request) {
+	l, err := a.store.Get(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	shares := make([]shareInfo, 0, len(l.Shares))
+	for id := range l.Shares {
+		shares = append(shares, a.shareInfo(l, id))
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].Created.Before(shares[j].Created) })
+	writeJSON(w, http.StatusOK, map[string]any{"shares": shares})
+}
+
+func (a *api) createShare(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ExpiresIn int64 `json:"expires_in"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if !shareExpiries[req.ExpiresIn] {
+		writeError(w, http.StatusBadRequest, "invalid expires_in")
+		return
+	}
+	id := newID()
+	now := time.Now().UTC().Truncate(time.Second)
+	s := Share{Created: now, By: userOf(r)}
+	if req.ExpiresIn > 0 {
+		expires := now.Add(time.Duration(req.ExpiresIn) * time.Second)
+		s.Expires = &expires
+	}
+	l, err := a.store.Update(r.PathValue("id"), func(l *List) error {
+		if l.Shares == nil {
+			l.Shares = make(map[string]Share)
+		}
+		l.Shares[id] = s
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, a.shareInfo(l, id))
+}
+
+// deleteShare revokes a link. Viewers connected through it are disconnected
+// by the next change of the list, which the revocation itself is.
+func (a *api) deleteShare(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("share")
+	_, err := a.store.Update(r.PathValue("id"), func(l *List) error {
+		if _, ok := l.Shares[id]; !ok {
+			return errNotFound
+		}
+		delete(l.Shares, id)
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// List is a todo list as kept on disk. Revision is incremented by every
+// change and serves as the ETag of the list. Members maps the users who have
+// access to the list to their role, and Shares the read-only links to the list
+// to their settings, by id.
+type List struct {
+	ID       string            `json:"id"`
+	Title    string            `json:"title"`
+	Revision int64             `json:"revision"`
+	Updated  time.Time         `json:"updated"`
+	Members  map[string]string `json:"members,omitempty"`
+	Shares   map[string]Share  `json:"shares,omitempty"`
+	Todos    []model.Todo      `json:"todos"`
+
+	// Applied holds the OpIDs of the latest ops applied, oldest first.
+	Applied []string `json:"applied,omitempty"`
+}
+
+// ListInfo describes a list without its todos. Role is the role of the user
+// asking.
+type ListInfo struct {
+	ID       string    `json:"id"`
+	Title    string    `json:"title"`
+	Revision int64     `json:"revision"`
+	Updated  time.Time `json:"updated"`
+	Count    int       `json:"count"`
+	Role     string    `json:"role,omitempty"`
+}
+
+func (l *List) Info() ListInfo {
+	return ListInfo{ID: l.ID, Title: l.Title, Revision: l.Revision, Updated: l.Updated, Count: len(l.Todos)}
+}
+
+// Role returns the role of user on the list, or "" when the user has no
+// access to it. Lists without members, such as the ones created before
+// accounts, are open to no one until Adopt gives them an owner.
+func (l *List) Role(user string) string {
+	return l.Members[user]
+}
+
+func (l *List) copy() *List {
+	c := *l
+	c.Todos = append(l.Todos[:0:0], l.Todos...)
+	c.Applied = append(l.Applied[:0:0], l.Applied...)
+	if l.Members != nil {
+		c.Members = make(map[string]string, len(l.Members))
+		for u, r := range l.Members {
+			c.Members[u] = r
+		}
+	}
+	if l.Shares != nil {
+		c.Shares = make(map[string]Share, len(l.Shares))
+		for id, sh := range l.Shares {
+			c.Shares[id] = sh
+		}
+	}
+	return &c
+}
+
+// appliedSize is the number of OpIDs kept by a list: an op sent again after
+// that many others is applied again.
+const appliedSize = 1000
+
+// applyOps applies the ops sent by a client to the todos of the list, but for
+// those already applied, as told by their OpID.
+func (l *List) applyOps(ops []model.Op) {
+	applied := make(map[string]bool, len(l.Applied))
+	for _, id := range l.Applied {
+		applied[id] = true
+	}
+	var fresh []model.Op
+	for _, op := range ops {
+		if op.OpID != "" {
+			if applied[op.OpID] {
+				continue
+			}
+			applied[op.OpID] = true
+			l.Applied = append(l.Applied, op.OpID)
+		}
+		fresh = append(fresh, op)
+	}
+	if n := len(l.Applied) - appliedSize; n > 0 {
+		l.Applied = append([]string(nil), l.Applied[n:]...)
+	}
+	l.Todos = model.Apply(l.Todos, fresh)
+}
+
+var (
+	errNotFound = errors.New("not found")
+	errExists   = errors.New("already exists")
+)
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Change is a revision of a list, described by the ops that produced it from
+// the previous revision.
+type Change struct {
+	Revision int64      `json:"revision"`
+	Time     time.Time  `json:"time"`
+	Ops      []model.Op `json:"ops"`
+}
+
+// changeLogSize is the number of changes kept per list for subscribers that
+// catch up after a disconnection.
+const changeLogSize = 1000
+
+// Store keeps every list in memory and writes each one to its own JSON file
+// in dir. Writes go through a temporary file so that a crash never leaves a
+// truncated list behind.
+//
+// The latest changes of each list are kept in memory and sent to subscribers.
+// The history of the todos of each list is appended to its activity log, a
+// file of JSON lines in dir/activity.
+type Store struct {
+	dir string
+
+	mu      sync.Mutex
+	lists   map[string]*List
+	changes map[string][]Change
+	subs    map[string]map[chan Change]bool
+}
+
+// OpenStore loads the lists found in dir, creating dir if needed.
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:     dir,
+		lists:   make(map[string]*List),
+		changes: make(map[string][]Change),
+		subs:    make(map[string]map[chan Change]bool),
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var l List
+		if err := json.Unmarshal(b, &l); err != nil {
+			return nil, errors.New(f + ": " + err.Error())
+		}
+		if l.ID != strings.TrimSuffix(filepath.Base(f), ".json") {
+			return nil, errors.New(f + ": list id does not match the file name")
+		}
+		s.lists[l.ID] = &l
+	}
+	return s, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// writeJSONFile writes v to path as indented JSON, through a temporary file
+// renamed over path once complete.
+func writeJSONFile(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *Store) write(l *List) error {
+	return writeJSONFile(s.path(l.ID), l)
+}
+
+// Lists returns the description of every list user has access to, by id.
+func (s *Store) Lists(user string) []ListInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make([]ListInfo, 0, len(s.lists))
+	for _, l := range s.lists {
+		role := l.Role(user)
+		if role == "" {
+			continue
+		}
+		info := l.Info()
+		info.Role = role
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// View calls f with a list, which f must not change or keep.
+func (s *Store) View(id string, f func(l *List)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.lists[id]
+	if !ok {
+		return errNotFound
+	}
+	f(l)
+	return nil
+}
+
+// Get returns a copy of a list.
+func (s *Store) Get(id string) (*List, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.lists[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return l.copy(), nil
+}
+
+// Create adds an empty list, owned by owner unless owner is empty.
+func (s *Store) Create(id string, title string, owner string) (*List, error) {
+	if !validID.MatchString(id) {
+		return nil, errors.New("invalid list id")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.lists[id]; ok {
+		return nil, errExists
+	}
+	l := &List{ID: id, Title: title, Revision: 1, Updated: time.Now().UTC(), Todos: []model.Todo{}}
+	if owner != "" {
+		l.Members = map[string]string{owner: roleOwner}
+	}
+	if err := s.write(l); err != nil {
+		return nil, err
+	}
+	s.lists[id] = l
+	return l.copy(), nil
+}
+
+// Delete removes the list id, unless check, called with the list under the
+// lock of the store, returns an error.
+func (s *Store) Delete(id string, check func(l *List) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.lists[id]
+	if !ok {
+		return errNotFound
+	}
+	if err := check(l.copy()); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(s.activityPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(s.lists, id)
+	delete(s.changes, id)
+	for ch := range s.subs[id] {
+		close(ch)
+	}
+	delete(s.subs, id)
+	return nil
+}
+
+// Update applies f to a copy of a list and saves the result as the next
+// revision. Nothing is saved if f returns an error or leaves the list as it
+// was, in which case the list is returned at its current revision.
+func (s *Store) Update(id string, f func(l *List) error) (*List, error) {
+	return s.UpdateBy(id, "", f)
+}
+
+// UpdateBy is Update for changes made by user, who is named in the activity
+// log of the list.
+func (s *Store) UpdateBy(id string, user string, f func(l *List) error) (*List, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.lists[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	next := l.copy()
+	if err := f(next); err != nil {
+		return nil, err
+	}
+	if reflect.DeepEqual(next, l) {
+		return next, nil
+	}
+	if err := model.ValidateList(next.Todos); err != nil {
+		return nil, &invalidError{err}
+	}
+	next.ID = l.ID
+	next.Revision = l.Revision + 1
+	next.Updated = time.Now().UTC()
+	if err := s.write(next); err != nil {
+		return nil, err
+	}
+	s.lists[id] = next
+	s.publish(id, Change{Revision: next.Revision, Time: next.Updated, Ops: model.Diff(l.Todos, next.Todos)})
+	if err := s.record(id, model.History(l.Todos, next.Todos, user, next.Updated)); err != nil {
+		log.Print("unable to record the activity of list ", id, ": ", err)
+	}
+	return next.copy(), nil
+}
+
+func (s *Store) activityPath(id string) string {
+	return filepath.Join(s.dir, "activity", id+".jsonl")
+}
+
+// record appends changes to the activity log of a list. It is called with mu
+// held.
+func (s *Store) record(id string, changes []model.Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	path := s.activityPath(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, c := range changes {
+		if err := enc.Encode(c); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// Activity returns the latest changes of the activity log of a list, newest
+// first and at most limit of them, restricted to the changes of one todo unless
+// todo is empty. The log is read from its end, and without holding mu: only its
+// size is taken with mu held, changes being appended whole with mu held.
+func (s *Store) Activity(id string, todo string, limit int) ([]model.Change, error) {
+	s.mu.Lock()
+	if _, ok := s.lists[id]; !ok {
+		s.mu.Unlock()
+		return nil, errNotFound
+	}
+	f, err := os.Open(s.activityPath(id))
+	var size int64
+	if err == nil {
+		var fi os.FileInfo
+		if fi, err = f.Stat(); err == nil {
+			size = fi.Size()
+		} else {
+			f.Close()
+		}
+	}
+	s.mu.Unlock()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var changes []model.Change
+	err = readLinesBackward(f, size, func(line []byte) (bool, error) {
+		var c model.Change
+		if err := json.Unmarshal(line, &c); err != nil {
+			return false, err
+		}
+		if todo == "" || c.Todo == todo {
+			changes = append(changes, c)
+		}
+		return len(changes) < limit, nil
+	})
+	return changes, err
+}
+
+// activityChunk is the size of the reads of activity logs.
+const activityChunk = 64 << 10
+
+// readLinesBackward calls f with the lines of the first size bytes of r, last
+// line first, until f returns false or an error.
+func readLinesBackward(r io.ReaderAt, size int64, f func(line []byte) (bool, error)) error {
+	var rest []byte // the start of the line whose end was read last
+	for end := size; end > 0; {
+		start := max(end-activityChunk, 0)
+		buf := make([]byte, end-start, end-start+int64(len(rest)))
+		if _, err := r.ReadAt(buf, start); err != nil {
+			return err
+		}
+		buf = append(buf, rest...)
+		for {
+			i := bytes.LastIndexByte(buf, '\n')
+			if i < 0 {
+				break
+			}
+			if line := buf[i+1:]; len(line) > 0 {
+				if more, err := f(line); err != nil || !more {
+					return err
+				}
+			}
+			buf = buf[:i]
+		}
+		rest, end = buf, start
+	}
+	if len(rest) > 0 {
+		_, err := f(rest)
+		return err
+	}
+	return nil
+}
+
+// Adopt makes user the owner of the lists that have no members.
+func (s *Store) Adopt(user string) error {
+	s.mu.Lock()
+	var ids []string
+	for id, l := range s.lists {
+		if len(l.Members) == 0 {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.Unlock()
+	for _, id := range ids {
+		_, err := s.Update(id, func(l *List) error {
+			if len(l.Members) == 0 {
+				l.Members = map[string]string{user: roleOwner}
+			}
+			return nil
+		})
+		if err != nil && err != errNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// publish records a change and sends it to the subscribers of the list.
+// Subscribers that do not keep up are dropped: their channel is closed and
+// they catch up by subscribing again. It is called with mu held.
+func (s *Store) publish(id string, c Change) {
+	log := append(s.changes[id], c)
+	if len(log) > changeLogSize {
+		log = append([]Change(nil), log[len(log)-changeLogSize:]...)
+	}
+	s.changes[id] = log
+	for ch := range s.subs[id] {
+		select {
+		case ch <- c:
+		default:
+			close(ch)
+			delete(s.subs[id], ch)
+		}
+	}
+}
+
+// Subscribe returns the changes of a list made after revision since, then
+// the channel on which the next ones are sent. When the changes since that
+// revision are no longer known, complete is false and the caller should start
+// over from the current list, also returned. cancel must be called once done.
+func (s *Store) Subscribe(id string, since int64) (l *List, backlog []Change, complete bool, ch <-chan Change, cancel func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.lists[id]
+	if !ok {
+		return nil, nil, false, nil, nil, errNotFound
+	}
+
+	log := s.changes[id]
+	switch {
+	case since == current.Revision:
+		complete = true
+	case since > 0 && since < current.Revision && len(log) > 0 && log[0].Revision <= since+1:
+		for _, c := range log {
+			if c.Revision > since {
+				backlog = append(backlog, c)
+			}
+		}
+		complete = true
+	}
+
+	c := make(chan Change, 64)
+	if s.subs[id] == nil {
+		s.subs[id] = make(map[chan Change]bool)
+	}
+	s.subs[id][c] = true
+	cancel = func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.subs[id][c] {
+			delete(s.subs[id], c)
+			close(c)
+		}
+	}
+	return current.copy(), backlog, complete, c, cancel, nil
+}
+
+// invalidError reports a change that would leave a list invalid.
+type invalidError struct {
+	err error
+}
+
+func (e *invalidError) Error() string { return e.err.Error() }
+func (e *invalidError) Unwrap() error { return e.err }
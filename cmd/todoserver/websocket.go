@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file implements the server side of the WebSocket protocol (RFC 6455),
+// limited to what the live endpoint needs: text messages, ping, pong and
+// close.
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+
+	wsMaxMessage = 1 << 20
+	wsGUID       = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var errWSClosed = errors.New("websocket closed")
+
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	wmu sync.Mutex
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket performs the opening handshake.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		http.Error(w, "WebSocket upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("not a websocket handshake")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(origin, r.Host) {
+		http.Error(w, "cross-origin WebSocket refused", http.StatusForbidden)
+		return nil, errors.New("cross-origin websocket")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("response cannot be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+// sameOrigin reports whether an Origin header names host.
+func sameOrigin(origin string, host string) bool {
+	_, rest, ok := strings.Cut(origin, "://")
+	return ok && strings.EqualFold(rest, host)
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// WriteText sends a text message.
+func (c *wsConn) WriteText(b []byte) error {
+	return c.writeFrame(wsText, b)
+}
+
+// Ping sends a ping, to which the client answers with a pong.
+func (c *wsConn) Ping() error {
+	return c.writeFrame(wsPing, nil)
+}
+
+// ReadMessage returns the next data message, answering pings and closes on
+// the way. It returns errWSClosed once the client has closed the connection.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	for {
+		var h [2]byte
+		if _, err := io.ReadFull(c.br, h[:]); err != nil {
+			return nil, err
+		}
+		fin := h[0]&0x80 != 0
+		opcode := h[0] & 0x0F
+		if h[1]&0x80 == 0 {
+			return nil, errors.New("unmasked client frame")
+		}
+		n := uint64(h[1] & 0x7F)
+		switch n {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(c.br, b[:]); err != nil {
+				return nil, err
+			}
+			n = uint64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(c.br, b[:]); err != nil {
+				return nil, err
+			}
+			n = binary.BigEndian.Uint64(b[:])
+		}
+		if n > wsMaxMessage || uint64(len(message))+n > wsMaxMessage {
+			c.writeFrame(wsClose, []byte{0x03, 0xF1}) // 1009: message too big
+			return nil, errors.New("websocket message too big")
+		}
+		var mask [4]byte
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return nil, err
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return nil, err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return nil, errWSClosed
+		case wsText, wsBinary, wsContinuation:
+			message = append(message, payload...)
+		default:
+			return nil, errors.New("unknown websocket opcode")
+		}
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
@@ -5,10 +5,11 @@ go 1.22.0
 require (
 	github.com/atdiar/particleui v0.0.0-20241019181046-13f3ced0d510
 	github.com/atdiar/particleui/drivers/js v0.0.0-20241019181046-13f3ced0d510
+	github.com/atdiar/particleui/drivers/js/compat v0.0.0-20241019181046-13f3ced0d510
+	golang.org/x/crypto v0.28.0
 )
 
 require (
-	github.com/atdiar/particleui/drivers/js/compat v0.0.0-20241019181046-13f3ced0d510 // indirect
 	github.com/fsnotify/fsnotify v1.8.0 // indirect
 	golang.org/x/net v0.30.0 // indirect
 	golang.org/x/sys v0.27.0 // indirect
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	ui "github.com/atdiar/particleui"
+	doc "github.com/atdiar/particleui/drivers/js"
+	js "github.com/atdiar/particleui/drivers/js/compat"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// ActivityLog is where the history of the todos is kept: an append-only log of
+// their changes (see model.History).
+type ActivityLog interface {
+	// Record appends the changes made by the app.
+	Record(changes []model.Change) error
+
+	// Load returns the latest changes of the todo with the given id, or of
+	// every todo when id is empty, newest first. It may block on I/O and
+	// must not be called from within a JavaScript callback.
+	Load(id string) ([]model.Change, error)
+}
+
+// activity is set by App, once the store is open.
+var activity ActivityLog = &memoryActivity{}
+
+// activityKey is the localStorage key of the activity log of the stores kept in
+// the browser.
+const activityKey = "todomvc2/activity"
+
+// maxLocalActivity is the number of changes kept in localStorage, the oldest
+// being dropped first, so that the log does not outgrow the storage quota.
+const maxLocalActivity = 1000
+
+// activityLogFor returns the activity log going with a store.
+func activityLogFor(store TodoStore) ActivityLog {
+	switch s := store.(type) {
+	case *SyncStore:
+		if session.Share != "" {
+			// Share links do not open the activity of the list.
+			return &memoryActivity{}
+		}
+		return serverActivity{endpoint: s.remote.endpoint}
+	case *MemoryStore:
+		return &memoryActivity{}
+	}
+	return localActivity{key: activityKey}
+}
+
+// latest returns at most n changes of the todo with the given id, or of every
+// todo, newest first, from a log kept oldest first.
+func latest(changes []model.Change, id string, n int) []model.Change {
+	var l []model.Change
+	for i := len(changes) - 1; i >= 0 && len(l) < n; i-- {
+		if id == "" || changes[i].Todo == id {
+			l = append(l, changes[i])
+		}
+	}
+	return l
+}
+
+// serverActivity reads the activity log of a list from the server, which
+// records the changes itself as it applies them, naming the user who made
+// them.
+type serverActivity struct {
+	endpoint string
+}
+
+func (s serverActivity) Record(changes []model.Change) error {
+	return nil
+}
+
+func (s serverActivity) Load(id string) ([]model.Change, error) {
+	u := s.endpoint + "/activity"
+	if id != "" {
+		u += "?todo=" + url.QueryEscape(id)
+	}
+	resp, err := fetch("GET", u, "", map[string]string{"Accept": "application/json"})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != 200 {
+		return nil, fmt.Errorf("GET %s: status %d", u, resp.Status)
+	}
+	var body struct {
+		Activity []model.Change `json:"activity"`
+	}
+	err = json.Unmarshal([]byte(resp.Body), &body)
+	return body.Activity, err
+}
+
+// localActivity keeps the activity log in localStorage, as a JSON array,
+// oldest first, shared by the tabs of the origin.
+type localActivity struct {
+	key string
+}
+
+func (s localActivity) read() ([]model.Change, error) {
+	v := js.Global().Get("localStorage").Call("getItem", s.key)
+	if v.IsNull() {
+		return nil, nil
+	}
+	var changes []model.Change
+	err := json.Unmarshal([]byte(v.String()), &changes)
+	return changes, err
+}
+
+func (s localActivity) Record(changes []model.Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	stored, err := s.read()
+	if err != nil {
+		return err
+	}
+	stored = append(stored, changes...)
+	if len(stored) > maxLocalActivity {
+		stored = stored[len(stored)-maxLocalActivity:]
+	}
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	js.Global().Get("localStorage").Call("setItem", s.key, string(b))
+	return nil
+}
+
+func (s localActivity) Load(id string) ([]model.Change, error) {
+	changes, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	return latest(changes, id, maxLocalActivity), nil
+}
+
+// memoryActivity keeps the activity log in memory, for the memory store.
+type memoryActivity struct {
+	mu      sync.Mutex
+	changes []model.Change
+}
+
+func (m *memoryActivity) Record(changes []model.Change) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.changes = append(m.changes, changes...)
+	return nil
+}
+
+func (m *memoryActivity) Load(id string) ([]model.Change, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return latest(m.changes, id, len(m.changes)), nil
+}
+
+// ActivityList shows changes of the activity log, newest first: when, by whom
+// and what.
+type ActivityList struct {
+	*ui.Element
+}
+
+func ActivityListFromRef(ref *ui.Element) ActivityList {
+	return ActivityList{ref}
+}
+
+func NewActivityList(d *doc.Document, id string, options ...string) ActivityList {
+	return ActivityList{newactivitylist(d, id, options...)}
+}
+
+// Load shows the latest changes of the todo with the given id, or of every
+// todo when id is empty. Like fetch, it must not be called from within a
+// JavaScript callback.
+func (a ActivityList) Load(id string) {
+	changes, err := activity.Load(id)
+	if err != nil {
+		a.AsElement().SetUI("activityerror", ui.String("Unable to load the history: "+err.Error()))
+		return
+	}
+	entries := ui.NewList()
+	for _, c := range changes {
+		who := c.By
+		if who == "" {
+			who = "you"
+		}
+		when := c.Time
+		if t, err := time.Parse(time.RFC3339, c.Time); err == nil {
+			when = t.Local().Format("Jan 2 15:04")
+		}
+		o := ui.NewObject()
+		o.Set("when", ui.String(when))
+		o.Set("who", ui.String(who))
+		o.Set("what", ui.String(c.Describe()))
+		entries = entries.Append(o.Commit())
+	}
+	a.AsElement().SetUI("activity", entries.Commit())
+}
+
+func newactivitylist(document *doc.Document, id string, options ...string) *ui.Element {
+	l := document.Ul.WithID(id, options...).AsElement()
+	doc.AddClass(l, "activity")
+
+	var rows []*ui.Element
+	show := func(rs ...*ui.Element) {
+		for _, row := range rows {
+			ui.Delete(row)
+		}
+		rows = rs
+		l.SetChildren(rows...)
+	}
+	message := func(text string) *ui.Element {
+		row := document.Li()
+		span := document.Span()
+		span.SetText(text)
+		row.AsElement().SetChildren(span.AsElement())
+		return row.AsElement()
+	}
+
+	l.Watch("ui", "activity", l, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		entries := evt.NewValue().(ui.List).UnsafelyUnwrap()
+		if len(entries) == 0 {
+			show(message("No changes yet."))
+			return false
+		}
+		rs := make([]*ui.Element, 0, len(entries))
+		for _, v := range entries {
+			e := v.(ui.Object)
+			row := document.Li()
+
+			meta := document.Span()
+			doc.AddClass(meta.AsElement(), "activity-meta")
+			meta.SetText(string(e.MustGetString("when")) + " · " + string(e.MustGetString("who")))
+
+			what := document.Span()
+			what.SetText(string(e.MustGetString("what")))
+
+			row.AsElement().SetChildren(meta.AsElement(), what.AsElement())
+			rs = append(rs, row.AsElement())
+		}
+		show(rs...)
+		return false
+	}))
+
+	l.Watch("ui", "activityerror", l, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		show(message(string(evt.NewValue().(ui.String))))
+		return false
+	}))
+
+	return l
+}
+
+// newTodoHistory returns the history of the todo of li, as shown in its details.
+// It is loaded when the details are expanded, and again on every change of the
+// todo while they are.
+func newTodoHistory(document *doc.Document, id string, li *ui.Element) *ui.Element {
+	h := NewActivityList(document, id)
+	doc.AddClass(h.AsElement(), "history")
+
+	load := func() {
+		if v, ok := li.GetUI("expanded"); !ok || !bool(v.(ui.Bool)) {
+			return
+		}
+		t, ok := li.GetUI("todo")
+		if !ok {
+			return
+		}
+		go h.Load(string(t.(Todo).MustGetString("id")))
+	}
+
+	h.AsElement().Watch("ui", "expanded", li, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		load()
+		return false
+	}))
+	h.AsElement().Watch("ui", "todo", li, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		load()
+		return false
+	}))
+
+	h.AsElement().ShareLifetimeOf(li)
+	return h.AsElement()
+}
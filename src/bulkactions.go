@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	ui "github.com/atdiar/particleui"
+	doc "github.com/atdiar/particleui/drivers/js"
+)
+
+// BulkActionBar holds the actions that apply to every selected todo.
+// It triggers a "bulk" event whose value is an Object with an "action" and a
+// "value" property, and an "undo" event.
+type BulkActionBar struct {
+	*ui.Element
+}
+
+func (b BulkActionBar) SetCount(count int) BulkActionBar {
+	b.AsElement().SetDataSetUI("count", ui.Number(count))
+	return b
+}
+
+func BulkActionBarFromRef(ref *ui.Element) BulkActionBar {
+	return BulkActionBar{ref}
+}
+
+func NewBulkActionBar(d *doc.Document, id string, options ...string) BulkActionBar {
+	return BulkActionBar{newbulkactionbar(d, id, options...)}
+}
+
+func bulkaction(action string, value ui.Value) ui.Object {
+	o := ui.NewObject()
+	o.Set("action", ui.String(action))
+	o.Set("value", value)
+	return o.Commit()
+}
+
+func newbulkactionbar(document *doc.Document, id string, options ...string) *ui.Element {
+	bar := document.Div.WithID(id, options...).AsElement()
+	doc.AddClass(bar, "bulk-actions")
+	doc.SetInlineCSS(bar, "display:none")
+
+	count := document.Span.WithID(id + "-count")
+	doc.AddClass(count.AsElement(), "bulk-count")
+
+	button := func(name string, text string, action string) *ui.Element {
+		b := document.Button.WithID(id+"-"+name, "button")
+		b.SetText(text)
+		b.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+			bar.TriggerEvent("bulk", bulkaction(action, ui.String(name)))
+			return false
+		}))
+		return b.AsElement()
+	}
+
+	// withinput returns a text input and a button that triggers the action with
+	// the input value.
+	withinput := func(name string, kind string, placeholder string, text string, action string) []*ui.Element {
+		i := document.Input.WithID(id+"-"+name+"-input", kind)
+		doc.SetAttribute(i.AsElement(), "placeholder", placeholder)
+		b := document.Button.WithID(id+"-"+name, "button")
+		b.SetText(text)
+		b.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+			v := strings.TrimSpace(string(i.Value()))
+			if v == "" && action != "due" {
+				return false
+			}
+			bar.TriggerEvent("bulk", bulkaction(action, ui.String(v)))
+			i.Clear()
+			return false
+		}))
+		return []*ui.Element{i.AsElement(), b.AsElement()}
+	}
+
+	undo := document.Button.WithID(id+"-undo", "button")
+	undo.SetText("Undo")
+	undo.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		bar.TriggerEvent("undo")
+		return false
+	}))
+
+	children := []*ui.Element{
+		count.AsElement(),
+		button("complete", "Complete", "complete"),
+		button("reopen", "Reopen", "reopen"),
+		button("delete", "Delete", "delete"),
+	}
+	children = append(children, withinput("tag", "text", "tag", "Tag", "tag")...)
+	children = append(children,
+		button("high", "!high", "priority"),
+		button("medium", "!medium", "priority"),
+		button("low", "!low", "priority"),
+	)
+	children = append(children, withinput("due", "date", "due date", "Set due", "due")...)
+	children = append(children, withinput("move", "text", "list", "Move", "move")...)
+	children = append(children, undo.AsElement())
+
+	bar.SetChildren(children...)
+
+	bar.Watch("ui", "count", bar, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		n, ok := evt.NewValue().(ui.Number)
+		if !ok {
+			return true
+		}
+		nn := int(n)
+		if nn == 0 {
+			doc.SetInlineCSS(bar, "display:none")
+			return false
+		}
+		doc.SetInlineCSS(bar, "display:block")
+		count.SetText(strconv.Itoa(nn) + " selected")
+		return false
+	}))
+
+	return bar
+}
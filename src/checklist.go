@@ -0,0 +1,144 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	ui "github.com/atdiar/particleui"
+	doc "github.com/atdiar/particleui/drivers/js"
+)
+
+// A todo checklist is a List of Objects, each with a "text" String and a
+// "done" Bool. It is stored in the "checklist" property of the todo.
+
+func todoChecklist(t Todo) []ui.Value {
+	v, ok := t.Get("checklist")
+	if !ok {
+		return nil
+	}
+	return v.(ui.List).UnsafelyUnwrap()
+}
+
+func newChecklistItem(text string) ui.Object {
+	o := ui.NewObject()
+	o.Set("text", ui.String(text))
+	o.Set("done", ui.Bool(false))
+	return o.Commit()
+}
+
+// checklistWith returns a copy of the items with f applied to it.
+func checklistWith(items []ui.Value, f func([]ui.Value) []ui.Value) ui.List {
+	c := make([]ui.Value, len(items))
+	copy(c, items)
+	return ui.NewListFrom(f(c))
+}
+
+// newChecklistEditor returns the element used to edit the checklist of the todo
+// displayed by li. Edits are reported to li with a "newchecklist" event.
+func newChecklistEditor(document *doc.Document, id string, li *ui.Element) *ui.Element {
+	var items []ui.Value
+	var rows []*ui.Element
+
+	list := document.Ul.WithID(id + "-items")
+	doc.AddClass(list.AsElement(), "checklist-items")
+
+	add := document.Input.WithID(id+"-add", "text")
+	doc.AddClass(add.AsElement(), "checklist-add")
+	doc.SetAttribute(add.AsElement(), "placeholder", "Add an item")
+
+	editor := document.Div.WithID(id)
+	doc.AddClass(editor.AsElement(), "checklist")
+	editor.AsElement().SetChildren(list.AsElement(), add.AsElement())
+
+	update := func(f func([]ui.Value) []ui.Value) {
+		li.TriggerEvent("newchecklist", checklistWith(items, f))
+	}
+
+	button := func(text string, class string, f func([]ui.Value) []ui.Value) *ui.Element {
+		b := document.Button()
+		b.SetText(text)
+		doc.AddClass(b.AsElement(), class)
+		b.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+			update(f)
+			return false
+		}))
+		return b.AsElement()
+	}
+
+	render := func() {
+		for _, row := range rows {
+			ui.Delete(row)
+		}
+		rows = make([]*ui.Element, 0, len(items))
+		for i, v := range items {
+			i := i
+			item := v.(ui.Object)
+
+			row := document.Li()
+			if item.MustGetBool("done") {
+				doc.AddClass(row.AsElement(), "done")
+			}
+
+			check := document.Input.WithID(id+"-item-"+strconv.Itoa(i), "checkbox")
+			check.SetUI("checked", item.MustGetBool("done"))
+			check.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+				update(func(l []ui.Value) []ui.Value {
+					o := l[i].(ui.Object)
+					l[i] = o.MakeCopy().Set("done", !o.MustGetBool("done")).Commit()
+					return l
+				})
+				return false
+			}))
+
+			text := document.Span()
+			text.SetText(string(item.MustGetString("text")))
+
+			row.AsElement().SetChildren(
+				check.AsElement(),
+				text.AsElement(),
+				button("↑", "checklist-up", func(l []ui.Value) []ui.Value {
+					if i > 0 {
+						l[i-1], l[i] = l[i], l[i-1]
+					}
+					return l
+				}),
+				button("↓", "checklist-down", func(l []ui.Value) []ui.Value {
+					if i < len(l)-1 {
+						l[i+1], l[i] = l[i], l[i+1]
+					}
+					return l
+				}),
+				button("×", "checklist-remove", func(l []ui.Value) []ui.Value {
+					return append(l[:i], l[i+1:]...)
+				}),
+			)
+			rows = append(rows, row.AsElement())
+		}
+		list.AsElement().SetChildren(rows...)
+	}
+
+	editor.AsElement().Watch("ui", "todo", li, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		items = todoChecklist(evt.NewValue().(Todo))
+		render()
+		return false
+	}).RunASAP())
+
+	add.AsElement().AddEventListener("keyup", ui.NewEventHandler(func(evt ui.Event) bool {
+		if evt.(doc.KeyboardEvent).Key() != "Enter" {
+			return false
+		}
+		evt.PreventDefault()
+		text := strings.TrimSpace(string(add.Value()))
+		if text == "" {
+			return false
+		}
+		update(func(l []ui.Value) []ui.Value {
+			return append(l, newChecklistItem(text))
+		})
+		add.Clear()
+		return false
+	}))
+
+	editor.AsElement().ShareLifetimeOf(li)
+	return editor.AsElement()
+}
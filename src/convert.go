@@ -0,0 +1,125 @@
+package main
+
+import (
+	ui "github.com/atdiar/particleui"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+func optionalString(t Todo, key string) string {
+	v, ok := t.Get(key)
+	if !ok {
+		return ""
+	}
+	return string(v.(ui.String))
+}
+
+// todoToModel returns the serialisable form of a todo.
+func todoToModel(t Todo) model.Todo {
+	m := model.Todo{
+		ID:         string(t.MustGetString("id")),
+		Title:      string(t.MustGetString("title")),
+		Completed:  bool(t.MustGetBool("completed")),
+		Tags:       TodoTags(t),
+		Priority:   optionalString(t, "priority"),
+		Due:        optionalString(t, "due"),
+		List:       optionalString(t, "list"),
+		Recurrence: optionalString(t, "recurrence"),
+		Notes:      optionalString(t, "notes"),
+
+		Created:     optionalString(t, "created"),
+		CompletedAt: optionalString(t, "completedat"),
+		Updated:     optionalString(t, "updated"),
+	}
+	if v, ok := t.Get("extensions"); ok {
+		for _, e := range v.(ui.List).UnsafelyUnwrap() {
+			m.Extensions = append(m.Extensions, string(e.(ui.String)))
+		}
+	}
+	if v, ok := t.Get("ical"); ok {
+		for _, l := range v.(ui.List).UnsafelyUnwrap() {
+			m.ICal = append(m.ICal, string(l.(ui.String)))
+		}
+	}
+	for _, v := range todoChecklist(t) {
+		item := v.(ui.Object)
+		m.Checklist = append(m.Checklist, model.ChecklistItem{
+			Text: string(item.MustGetString("text")),
+			Done: bool(item.MustGetBool("done")),
+		})
+	}
+	return m
+}
+
+// todoFromModel returns the todo described by m. Optional properties are only
+// set when present.
+func todoFromModel(m model.Todo) Todo {
+	o := ui.NewObject()
+	o.Set("id", ui.String(m.ID))
+	o.Set("title", ui.String(m.Title))
+	o.Set("completed", ui.Bool(m.Completed))
+
+	if len(m.Tags) > 0 {
+		tags := ui.NewList()
+		for _, tag := range m.Tags {
+			tags = tags.Append(ui.String(tag))
+		}
+		o.Set("tags", tags.Commit())
+	}
+	for k, v := range map[string]string{
+		"priority":   m.Priority,
+		"due":        m.Due,
+		"list":       m.List,
+		"recurrence": m.Recurrence,
+		"notes":      m.Notes,
+
+		"created":     m.Created,
+		"completedat": m.CompletedAt,
+		"updated":     m.Updated,
+	} {
+		if v != "" {
+			o.Set(k, ui.String(v))
+		}
+	}
+	if len(m.Extensions) > 0 {
+		ext := ui.NewList()
+		for _, e := range m.Extensions {
+			ext = ext.Append(ui.String(e))
+		}
+		o.Set("extensions", ext.Commit())
+	}
+	if len(m.ICal) > 0 {
+		lines := ui.NewList()
+		for _, l := range m.ICal {
+			lines = lines.Append(ui.String(l))
+		}
+		o.Set("ical", lines.Commit())
+	}
+	if len(m.Checklist) > 0 {
+		items := ui.NewList()
+		for _, item := range m.Checklist {
+			i := ui.NewObject()
+			i.Set("text", ui.String(item.Text))
+			i.Set("done", ui.Bool(item.Done))
+			items = items.Append(i.Commit())
+		}
+		o.Set("checklist", items.Commit())
+	}
+	return o.Commit()
+}
+
+func listToModel(l ui.List) []model.Todo {
+	todos := make([]model.Todo, 0, len(l.UnsafelyUnwrap()))
+	for _, v := range l.UnsafelyUnwrap() {
+		todos = append(todos, todoToModel(v.(Todo)))
+	}
+	return todos
+}
+
+func listFromModel(todos []model.Todo) ui.List {
+	l := ui.NewList()
+	for _, t := range todos {
+		l = l.Append(todoFromModel(t))
+	}
+	return l.Commit()
+}
@@ -0,0 +1,475 @@
+// Package crdt implements a conflict-free replicated document holding a list of
+// todos, so that replicas edited concurrently, such as browser tabs sharing a
+// storage, converge to the same list whatever the order in which they merge.
+//
+// The document combines:
+//   - an observed-remove set for membership: adding a todo tags it, and
+//     removing it removes the tags observed so far, so a concurrent re-add wins.
+//     No tombstones are kept: each replica knows the highest stamp it has seen
+//     from every replica, so a tag that a replica lacks although it has seen it
+//     was removed
+//   - a last-writer-wins register per field of each todo, ordered by Lamport
+//     stamps with the replica name as tie-breaker. The fields of removed
+//     todos are dropped by Update
+//   - a fractional position per todo, itself a register, for the list order:
+//     moving a todo gives it a key between the keys of its new neighbours.
+//     Keys are spread out again once they grow too long
+//
+// The package only depends on the model package and is usable outside the
+// browser.
+package crdt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// Stamp orders the writes of all replicas.
+type Stamp struct {
+	Counter uint64
+	Replica string
+}
+
+func (s Stamp) Less(o Stamp) bool {
+	if s.Counter != o.Counter {
+		return s.Counter < o.Counter
+	}
+	return s.Replica < o.Replica
+}
+
+func (s Stamp) String() string {
+	return strconv.FormatUint(s.Counter, 36) + "." + s.Replica
+}
+
+func parseStamp(s string) (Stamp, error) {
+	c, r, ok := strings.Cut(s, ".")
+	if !ok {
+		return Stamp{}, errors.New("invalid stamp " + s)
+	}
+	n, err := strconv.ParseUint(c, 36, 64)
+	if err != nil {
+		return Stamp{}, err
+	}
+	return Stamp{n, r}, nil
+}
+
+func (s Stamp) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Stamp) UnmarshalText(b []byte) error {
+	st, err := parseStamp(string(b))
+	*s = st
+	return err
+}
+
+// register is a last-writer-wins register holding a JSON value.
+type register struct {
+	Value json.RawMessage `json:"v"`
+	Stamp Stamp           `json:"s"`
+}
+
+// positionField is the register holding the position key of a todo.
+const positionField = "@pos"
+
+// Doc is a replica of the todo list. Replica names must be unique among the
+// replicas that merge with each other.
+type Doc struct {
+	replica string
+	clock   uint64
+
+	adds   map[Stamp]string  // add tags of the todos in the list → todo id
+	seen   map[string]uint64 // highest counter seen from each replica
+	fields map[string]map[string]register
+}
+
+func New(replica string) *Doc {
+	return &Doc{
+		replica: replica,
+		adds:    make(map[Stamp]string),
+		seen:    make(map[string]uint64),
+		fields:  make(map[string]map[string]register),
+	}
+}
+
+// Replica returns the name of the replica.
+func (d *Doc) Replica() string {
+	return d.replica
+}
+
+func (d *Doc) tick() Stamp {
+	d.clock++
+	d.seen[d.replica] = d.clock
+	return Stamp{d.clock, d.replica}
+}
+
+// covers reports whether seen includes the stamp s.
+func covers(seen map[string]uint64, s Stamp) bool {
+	return seen[s.Replica] >= s.Counter
+}
+
+// observe moves the clock past a stamp seen from another replica.
+func (d *Doc) observe(s Stamp) {
+	if s.Counter > d.clock {
+		d.clock = s.Counter
+	}
+}
+
+// live returns the ids of the todos in the list.
+func (d *Doc) live() map[string]bool {
+	ids := make(map[string]bool)
+	for _, id := range d.adds {
+		ids[id] = true
+	}
+	return ids
+}
+
+func (d *Doc) set(id, field string, value json.RawMessage) {
+	f, ok := d.fields[id]
+	if !ok {
+		f = make(map[string]register)
+		d.fields[id] = f
+	}
+	f[field] = register{Value: value, Stamp: d.tick()}
+}
+
+func (d *Doc) position(id string) string {
+	var key string
+	if r, ok := d.fields[id][positionField]; ok {
+		json.Unmarshal(r.Value, &key)
+	}
+	return key
+}
+
+// order returns the ids of the todos of the list, in order.
+func (d *Doc) order() []string {
+	ids := make([]string, 0)
+	for id := range d.live() {
+		ids = append(ids, id)
+	}
+	positions := make(map[string]string, len(ids))
+	for _, id := range ids {
+		positions[id] = d.position(id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		pi, pj := positions[ids[i]], positions[ids[j]]
+		if pi != pj {
+			return pi < pj
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// Todos returns the todos of the list, in order.
+func (d *Doc) Todos() []model.Todo {
+	ids := d.order()
+	todos := make([]model.Todo, 0, len(ids))
+	for _, id := range ids {
+		fields := make(map[string]json.RawMessage)
+		for name, r := range d.fields[id] {
+			if name != positionField {
+				fields[name] = r.Value
+			}
+		}
+		b, _ := json.Marshal(fields)
+		var t model.Todo
+		json.Unmarshal(b, &t)
+		t.ID = id
+		todos = append(todos, t)
+	}
+	return todos
+}
+
+// fieldsOf returns the JSON encoding of each field of a todo.
+func fieldsOf(t model.Todo) map[string]json.RawMessage {
+	b, _ := json.Marshal(t)
+	var fields map[string]json.RawMessage
+	json.Unmarshal(b, &fields)
+	delete(fields, "id")
+	return fields
+}
+
+var null = json.RawMessage("null")
+
+// Update records the changes that turn the current list into todos: added and
+// removed todos, changed fields and moved todos. Unchanged fields and todos
+// that keep their relative order are left alone, so that they do not override
+// concurrent changes of other replicas.
+//
+// The fields of the todos that are not in the list are dropped. A todo that is
+// removed while another replica adds it back keeps the fields of that replica.
+func (d *Doc) Update(todos []model.Todo) {
+	current := d.order()
+	live := d.live()
+	next := make(map[string]bool, len(todos))
+	for _, t := range todos {
+		next[t.ID] = true
+	}
+
+	for tag, id := range d.adds {
+		if !next[id] {
+			delete(d.adds, tag)
+		}
+	}
+	for id := range d.fields {
+		if !next[id] {
+			delete(d.fields, id)
+		}
+	}
+
+	for _, t := range todos {
+		if !live[t.ID] {
+			d.adds[d.tick()] = t.ID
+		}
+		fields := fieldsOf(t)
+		for name, r := range d.fields[t.ID] {
+			if _, ok := fields[name]; !ok && name != positionField && !bytes.Equal(r.Value, null) {
+				fields[name] = null
+			}
+		}
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if r, ok := d.fields[t.ID][name]; ok && bytes.Equal(r.Value, fields[name]) {
+				continue
+			}
+			d.set(t.ID, name, fields[name])
+		}
+	}
+
+	d.reorder(current, todos)
+	d.rebalance(todos)
+}
+
+// reorder gives new positions to the todos of next that are new or moved
+// relative to current.
+func (d *Doc) reorder(current []string, next []model.Todo) {
+	index := make(map[string]int, len(current))
+	for i, id := range current {
+		index[id] = i
+	}
+	var kept []int
+	var seq []int
+	for i, t := range next {
+		if p, ok := index[t.ID]; ok {
+			kept = append(kept, i)
+			seq = append(seq, p)
+		}
+	}
+	stable := make([]bool, len(next))
+	for _, p := range longestIncreasing(seq) {
+		stable[kept[p]] = true
+	}
+
+	prev := ""
+	for i, t := range next {
+		if stable[i] && d.position(t.ID) > prev {
+			prev = d.position(t.ID)
+			continue
+		}
+		upper := ""
+		for j := i + 1; j < len(next); j++ {
+			if stable[j] {
+				upper = d.position(next[j].ID)
+				break
+			}
+		}
+		if upper != "" && upper <= prev {
+			upper = ""
+		}
+		key := between(prev, upper)
+		b, _ := json.Marshal(key)
+		d.set(t.ID, positionField, b)
+		prev = key
+	}
+}
+
+// maxPositionLength is the length of position keys past which the positions
+// of every todo are spread out again.
+const maxPositionLength = 12
+
+// rebalance gives evenly spread, short positions to the todos, in order, once
+// one of their keys is longer than maxPositionLength. Concurrent moves of other
+// replicas with earlier stamps are overridden.
+func (d *Doc) rebalance(todos []model.Todo) {
+	long := false
+	for _, t := range todos {
+		if len(d.position(t.ID)) > maxPositionLength {
+			long = true
+			break
+		}
+	}
+	if !long {
+		return
+	}
+	for i, key := range spread(len(todos)) {
+		if d.position(todos[i].ID) == key {
+			continue
+		}
+		b, _ := json.Marshal(key)
+		d.set(todos[i].ID, positionField, b)
+	}
+}
+
+// Merge folds another replica into d. Merging is commutative, associative and
+// idempotent.
+//
+// A tag known to one replica only was added after the other replica saw the
+// list, and is kept, unless that replica has seen it, in which case it was
+// removed.
+func (d *Doc) Merge(o *Doc) {
+	for tag := range d.adds {
+		if _, ok := o.adds[tag]; !ok && covers(o.seen, tag) {
+			delete(d.adds, tag)
+		}
+	}
+	for tag, id := range o.adds {
+		if _, ok := d.adds[tag]; !ok && !covers(d.seen, tag) {
+			d.adds[tag] = id
+		}
+		d.observe(tag)
+	}
+	for replica, n := range o.seen {
+		if n > d.seen[replica] {
+			d.seen[replica] = n
+		}
+	}
+	for id, fields := range o.fields {
+		for name, r := range fields {
+			d.observe(r.Stamp)
+			mine, ok := d.fields[id][name]
+			if ok && !mine.Stamp.Less(r.Stamp) {
+				continue
+			}
+			if d.fields[id] == nil {
+				d.fields[id] = make(map[string]register)
+			}
+			d.fields[id][name] = r
+		}
+	}
+	if o.clock > d.clock {
+		d.clock = o.clock
+	}
+}
+
+// encoded is the serialised form of a document. Stamps are written as
+// "counter.replica", with the counter in base 36.
+//
+// Documents written by earlier versions have no Seen, but the removed tags in
+// Removed.
+type encoded struct {
+	Clock   uint64                         `json:"c"`
+	Adds    map[string][]Stamp             `json:"a"`
+	Seen    map[string]uint64              `json:"v"`
+	Removed []Stamp                        `json:"d,omitempty"`
+	Fields  map[string]map[string]register `json:"f"`
+}
+
+// Marshal serialises the state of the document, without its replica name.
+func (d *Doc) Marshal() ([]byte, error) {
+	e := encoded{
+		Clock:  d.clock,
+		Adds:   make(map[string][]Stamp),
+		Seen:   d.seen,
+		Fields: d.fields,
+	}
+	for tag, id := range d.adds {
+		e.Adds[id] = append(e.Adds[id], tag)
+	}
+	for _, tags := range e.Adds {
+		sort.Slice(tags, func(i, j int) bool { return tags[i].Less(tags[j]) })
+	}
+	return json.Marshal(e)
+}
+
+// Unmarshal returns the document serialised by Marshal, as replica.
+func Unmarshal(data []byte, replica string) (*Doc, error) {
+	var e encoded
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	d := New(replica)
+	d.clock = e.Clock
+	for id, tags := range e.Adds {
+		for _, tag := range tags {
+			d.adds[tag] = id
+		}
+	}
+	for id, fields := range e.Fields {
+		d.fields[id] = fields
+	}
+	for r, n := range e.Seen {
+		d.seen[r] = n
+	}
+	if e.Seen == nil {
+		// Earlier versions kept every tag ever added: they have seen every
+		// stamp they hold.
+		see := func(s Stamp) {
+			if s.Counter > d.seen[s.Replica] {
+				d.seen[s.Replica] = s.Counter
+			}
+		}
+		for tag := range d.adds {
+			see(tag)
+		}
+		for _, tag := range e.Removed {
+			see(tag)
+		}
+		for _, fields := range d.fields {
+			for _, r := range fields {
+				see(r.Stamp)
+			}
+		}
+	}
+	for _, tag := range e.Removed {
+		delete(d.adds, tag)
+	}
+	return d, nil
+}
+
+// longestIncreasing returns the positions, in seq, of a longest increasing
+// subsequence of seq.
+func longestIncreasing(seq []int) []int {
+	var tails []int
+	prev := make([]int, len(seq))
+	for i, v := range seq {
+		lo, hi := 0, len(tails)
+		for lo < hi {
+			m := (lo + hi) / 2
+			if seq[tails[m]] < v {
+				lo = m + 1
+			} else {
+				hi = m
+			}
+		}
+		prev[i] = -1
+		if lo > 0 {
+			prev[i] = tails[lo-1]
+		}
+		if lo == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[lo] = i
+		}
+	}
+	if len(tails) == 0 {
+		return nil
+	}
+	positions := make([]int, len(tails))
+	k := tails[len(tails)-1]
+	for i := len(tails) - 1; i >= 0; i-- {
+		positions[i] = k
+		k = prev[k]
+	}
+	return positions
+}
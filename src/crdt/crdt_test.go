@@ -0,0 +1,280 @@
+package crdt
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+func TestBetween(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	keys := []string{between("", "")}
+	for i := 0; i < 2000; i++ {
+		// Insert at a random place of the sorted keys.
+		n := r.Intn(len(keys) + 1)
+		a, b := "", ""
+		if n > 0 {
+			a = keys[n-1]
+		}
+		if n < len(keys) {
+			b = keys[n]
+		}
+		key := between(a, b)
+		if key <= a || (b != "" && key >= b) {
+			t.Fatalf("between(%q, %q) = %q, not in between", a, b, key)
+		}
+		if strings.HasSuffix(key, "0") {
+			t.Fatalf("between(%q, %q) = %q ends with a zero", a, b, key)
+		}
+		keys = append(keys[:n], append([]string{key}, keys[n:]...)...)
+	}
+}
+
+func TestSpread(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 35, 36, 100, 1295, 1296, 5000} {
+		keys := spread(n)
+		if len(keys) != n {
+			t.Fatalf("spread(%d) returned %d keys", n, len(keys))
+		}
+		for i, key := range keys {
+			if key == "" || strings.HasSuffix(key, "0") {
+				t.Fatalf("spread(%d)[%d] = %q", n, i, key)
+			}
+			if i > 0 && keys[i-1] >= key {
+				t.Fatalf("spread(%d): %q before %q", n, keys[i-1], key)
+			}
+		}
+	}
+}
+
+func newTodo(i int) model.Todo {
+	return model.Todo{ID: "t" + strconv.Itoa(i), Title: "Todo " + strconv.Itoa(i)}
+}
+
+func maxPosition(d *Doc) int {
+	n := 0
+	for id := range d.live() {
+		if l := len(d.position(id)); l > n {
+			n = l
+		}
+	}
+	return n
+}
+
+func TestPositionLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		insert func(todos []model.Todo, t model.Todo) []model.Todo
+	}{
+		{"append", func(todos []model.Todo, t model.Todo) []model.Todo {
+			return append(todos, t)
+		}},
+		{"prepend", func(todos []model.Todo, t model.Todo) []model.Todo {
+			return append([]model.Todo{t}, todos...)
+		}},
+		{"after the first", func(todos []model.Todo, t model.Todo) []model.Todo {
+			if len(todos) == 0 {
+				return []model.Todo{t}
+			}
+			return append(todos[:1], append([]model.Todo{t}, todos[1:]...)...)
+		}},
+		{"before the last", func(todos []model.Todo, t model.Todo) []model.Todo {
+			if len(todos) == 0 {
+				return []model.Todo{t}
+			}
+			n := len(todos) - 1
+			return append(todos[:n:n], append([]model.Todo{t}, todos[n:]...)...)
+		}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			d := New("a")
+			var todos []model.Todo
+			for i := 0; i < 1000; i++ {
+				todos = tt.insert(todos, newTodo(i))
+				d.Update(todos)
+				if n := maxPosition(d); n > maxPositionLength {
+					t.Fatalf("key of %d digits after %d todos", n, i+1)
+				}
+			}
+			if got := d.Todos(); !reflect.DeepEqual(got, todos) {
+				t.Errorf("the order of the todos differs")
+			}
+		})
+	}
+}
+
+func TestRemovedLeaveNothing(t *testing.T) {
+	d := New("a")
+	for i := 0; i < 100; i++ {
+		d.Update([]model.Todo{newTodo(i)})
+	}
+	d.Update(nil)
+	if len(d.adds) != 0 || len(d.fields) != 0 {
+		t.Errorf("%d tags and %d todos left after removing every todo", len(d.adds), len(d.fields))
+	}
+
+	// A replica that has not seen the removal does not bring the todos back.
+	e := New("b")
+	e.Update([]model.Todo{newTodo(1)})
+	f := clone(t, e, "c")
+	d.Merge(e)
+	f.Update(nil)
+	d.Merge(f)
+	e.Merge(f)
+	if got := d.Todos(); len(got) != 0 {
+		t.Errorf("removed todos came back: %v", got)
+	}
+	if got := e.Todos(); len(got) != 0 {
+		t.Errorf("removed todos came back: %v", got)
+	}
+}
+
+func TestConcurrentChanges(t *testing.T) {
+	a := New("a")
+	a.Update([]model.Todo{newTodo(1), newTodo(2)})
+	b := clone(t, a, "b")
+
+	// Removing a todo wins over an edit, but not over adding it back.
+	a.Update([]model.Todo{newTodo(2)})
+	edited := newTodo(1)
+	edited.Title = "Edited"
+	readded := newTodo(2)
+	readded.Title = "Added back"
+	b.Update([]model.Todo{edited})
+	b.Update([]model.Todo{edited, readded})
+
+	a.Merge(b)
+	b.Merge(a)
+	want := []model.Todo{readded}
+	for _, d := range []*Doc{a, b} {
+		if got := d.Todos(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Todos = %+v, want %+v", d.replica, got, want)
+		}
+	}
+}
+
+// clone returns a copy of d as replica.
+func clone(t *testing.T, d *Doc, replica string) *Doc {
+	t.Helper()
+	b, err := d.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Unmarshal(b, replica)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func marshal(t *testing.T, d *Doc) []byte {
+	t.Helper()
+	b, err := d.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// edit makes a random change to the list of d.
+func edit(r *rand.Rand, d *Doc, next *int) {
+	todos := d.Todos()
+	switch n := len(todos); {
+	case n == 0 || r.Intn(4) == 0:
+		*next++
+		i := r.Intn(n + 1)
+		todos = append(todos[:i:i], append([]model.Todo{newTodo(*next)}, todos[i:]...)...)
+	case r.Intn(3) == 0:
+		i := r.Intn(n)
+		todos = append(todos[:i:i], todos[i+1:]...)
+	case r.Intn(2) == 0:
+		i, j := r.Intn(n), r.Intn(n)
+		todos[i], todos[j] = todos[j], todos[i]
+	default:
+		i := r.Intn(n)
+		todos[i].Completed = !todos[i].Completed
+		todos[i].Title += "!"
+	}
+	d.Update(todos)
+}
+
+func TestConvergence(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		replicas := []*Doc{New("a"), New("b"), New("c")}
+		next := 0
+		for step := 0; step < 200; step++ {
+			d := replicas[r.Intn(len(replicas))]
+			if r.Intn(4) == 0 {
+				d.Merge(clone(t, replicas[r.Intn(len(replicas))], "x"))
+			} else {
+				edit(r, d, &next)
+			}
+		}
+
+		// Whatever the order of the merges, the replicas converge.
+		var want []byte
+		for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}, {1, 2, 0}} {
+			d := clone(t, replicas[order[0]], "x")
+			d.Merge(replicas[order[1]])
+			d.Merge(replicas[order[2]])
+			got := marshal(t, d)
+			if want == nil {
+				want = got
+			} else if !bytes.Equal(got, want) {
+				t.Fatalf("seed %d: merging in the order %v gives\n%s\nwant\n%s", seed, order, got, want)
+			}
+		}
+
+		// Merging is commutative, associative and idempotent.
+		a, b, c := replicas[0], replicas[1], replicas[2]
+		ab, ba := clone(t, a, "x"), clone(t, b, "x")
+		ab.Merge(b)
+		ba.Merge(a)
+		if !bytes.Equal(marshal(t, ab), marshal(t, ba)) {
+			t.Fatalf("seed %d: merging is not commutative", seed)
+		}
+		bc := clone(t, b, "x")
+		bc.Merge(c)
+		ab.Merge(c)
+		aBC := clone(t, a, "x")
+		aBC.Merge(bc)
+		if !bytes.Equal(marshal(t, ab), marshal(t, aBC)) {
+			t.Fatalf("seed %d: merging is not associative", seed)
+		}
+		again := clone(t, ab, "x")
+		again.Merge(ab)
+		if !bytes.Equal(marshal(t, again), marshal(t, ab)) {
+			t.Fatalf("seed %d: merging is not idempotent", seed)
+		}
+	}
+}
+
+func TestUnmarshalEarlierVersion(t *testing.T) {
+	data := `{"c":3,"a":{"t1":["1.a"],"t2":["2.a"]},"d":["2.a"],"f":{"t1":{"title":{"v":"A","s":"1.a"}},"t2":{"title":{"v":"B","s":"3.a"}}}}`
+	d, err := Unmarshal([]byte(data), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []model.Todo{{ID: "t1", Title: "A"}}
+	if got := d.Todos(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Todos = %+v, want %+v", got, want)
+	}
+	// The removed todo does not come back from a replica that still has it.
+	e := New("a")
+	e.adds[Stamp{1, "a"}] = "t1"
+	e.adds[Stamp{2, "a"}] = "t2"
+	e.seen["a"] = 2
+	d.Merge(e)
+	if got := d.Todos(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Todos after Merge = %+v, want %+v", got, want)
+	}
+}
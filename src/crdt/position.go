@@ -0,0 +1,85 @@
+package crdt
+
+import "strings"
+
+const digits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+func digit(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'z':
+		return int(c-'a') + 10
+	}
+	return 0
+}
+
+// between returns a position key that sorts after a and before b. Keys are
+// base 36 fractions that never end with a zero, so that there is always room
+// between two of them. An empty a is the start of the list and an empty b its
+// end.
+//
+// Keys appended after a, or prepended before b, take the smallest step away
+// from it, rather than the middle of the gap, so that repeated appends or
+// prepends only grow keys by one digit every 35 of them.
+func between(a, b string) string {
+	const base = len(digits)
+	var key []byte
+	bounded := b != ""
+	for i := 0; ; i++ {
+		lo := 0
+		if i < len(a) {
+			lo = digit(a[i])
+		}
+		hi := base
+		if bounded {
+			hi = 0
+			if i < len(b) {
+				hi = digit(b[i])
+			}
+		}
+		switch {
+		case hi-lo > 1:
+			d := (lo + hi) / 2
+			switch {
+			case !bounded:
+				d = lo + 1
+			case i >= len(a):
+				d = hi - 1
+			}
+			return string(append(key, digits[d]))
+		case hi-lo == 1:
+			// Any key starting with lo sorts before b.
+			key = append(key, digits[lo])
+			if i >= len(a) {
+				return string(append(key, digits[base-1]))
+			}
+			bounded = false
+		default:
+			key = append(key, digits[lo])
+		}
+	}
+}
+
+// spread returns n increasing position keys, evenly spaced and as short as
+// possible.
+func spread(n int) []string {
+	const base = len(digits)
+	width, size := 1, base
+	for size <= n {
+		width++
+		size *= base
+	}
+	step := size / (n + 1)
+	keys := make([]string, n)
+	for i := range keys {
+		v := (i + 1) * step
+		key := make([]byte, width)
+		for j := width - 1; j >= 0; j-- {
+			key[j] = digits[v%base]
+			v /= base
+		}
+		keys[i] = strings.TrimRight(string(key), "0")
+	}
+	return keys
+}
@@ -0,0 +1,277 @@
+// Package csvio reads and writes todos as RFC 4180 CSV files.
+//
+// Cells that spreadsheets would take for a formula, starting with =, +, -, @,
+// a tab or a carriage return, are written with a leading ', which is removed
+// on import. Tags are separated by commas, the commas, semicolons and
+// backslashes of a tag being escaped with a backslash.
+package csvio
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// Columns lists the todo fields that can be written to and read from a CSV
+// file, in their default order.
+var Columns = []string{"title", "completed", "created", "due", "tags", "priority", "notes", "list", "id"}
+
+// DefaultColumns are the columns exported unless others are chosen.
+var DefaultColumns = []string{"title", "completed", "created", "due", "tags", "priority", "notes"}
+
+func field(t model.Todo, column string) string {
+	switch column {
+	case "title":
+		return t.Title
+	case "completed":
+		if t.Completed {
+			return "true"
+		}
+		return "false"
+	case "created":
+		return t.Created
+	case "due":
+		return t.Due
+	case "tags":
+		tags := make([]string, len(t.Tags))
+		for i, tag := range t.Tags {
+			tags[i] = tagEscaper.Replace(tag)
+		}
+		return strings.Join(tags, ", ")
+	case "priority":
+		return t.Priority
+	case "notes":
+		return t.Notes
+	case "list":
+		return t.List
+	case "id":
+		return t.ID
+	}
+	return ""
+}
+
+var tagEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `;`, `\;`)
+
+// splitTags splits a tags cell on unescaped commas and semicolons.
+func splitTags(v string) []string {
+	var tags []string
+	var b strings.Builder
+	add := func() {
+		tag := strings.TrimPrefix(strings.TrimSpace(b.String()), "#")
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+		b.Reset()
+	}
+	for i := 0; i < len(v); i++ {
+		switch {
+		case v[i] == '\\' && i+1 < len(v):
+			i++
+			b.WriteByte(v[i])
+		case v[i] == ',' || v[i] == ';':
+			add()
+		default:
+			b.WriteByte(v[i])
+		}
+	}
+	add()
+	return tags
+}
+
+// formula reports whether a spreadsheet would evaluate a cell.
+func formula(cell string) bool {
+	return cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0]))
+}
+
+// escapeFormula prefixes the cells a spreadsheet would evaluate with a '.
+func escapeFormula(cell string) string {
+	if formula(cell) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// unescapeFormula removes the ' escaping a formula.
+func unescapeFormula(cell string) string {
+	if strings.HasPrefix(cell, "'") && formula(cell[1:]) {
+		return cell[1:]
+	}
+	return cell
+}
+
+// Write writes a header row with the given columns, then a row per todo.
+// Lines end with CRLF, as in RFC 4180.
+func Write(w io.Writer, todos []model.Todo, columns []string) error {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	row := make([]string, len(columns))
+	for _, t := range todos {
+		for i, c := range columns {
+			row[i] = escapeFormula(field(t, c))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Read returns the header and the rows of a CSV file, without the byte order
+// mark spreadsheets may start it with.
+func Read(r io.Reader) (header []string, rows [][]string, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 || len(records[0]) == 0 {
+		return nil, nil, fmt.Errorf("empty CSV file")
+	}
+	records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff")
+	return records[0], records[1:], nil
+}
+
+var aliases = map[string]string{
+	"title": "title", "name": "title", "task": "title", "summary": "title", "todo": "title",
+	"completed": "completed", "done": "completed", "status": "completed",
+	"created": "created", "created at": "created", "creation date": "created",
+	"due": "due", "due date": "due", "deadline": "due",
+	"tags": "tags", "tag": "tags", "labels": "tags", "categories": "tags",
+	"priority": "priority", "importance": "priority",
+	"notes": "notes", "note": "notes", "description": "notes",
+	"list": "list", "project": "list",
+	"id": "id", "uid": "id",
+}
+
+// GuessMapping returns, for each column of the header, the todo field it most
+// likely holds, or the empty string when it should be ignored.
+func GuessMapping(header []string) []string {
+	mapping := make([]string, len(header))
+	used := make(map[string]bool)
+	for i, h := range header {
+		f := aliases[strings.ToLower(strings.TrimSpace(h))]
+		if f != "" && !used[f] {
+			mapping[i] = f
+			used[f] = true
+		}
+	}
+	return mapping
+}
+
+// NextField returns the field that follows mapping[i] in Columns, skipping the
+// fields other columns are mapped to. After the last field comes the empty
+// string, which ignores the column.
+func NextField(mapping []string, i int) string {
+	fields := append([]string{""}, Columns...)
+	n := 0
+	for j, f := range fields {
+		if f == mapping[i] {
+			n = j
+		}
+	}
+	for k := 1; k < len(fields); k++ {
+		f := fields[(n+k)%len(fields)]
+		if f == "" || !mappedElsewhere(mapping, i, f) {
+			return f
+		}
+	}
+	return ""
+}
+
+func mappedElsewhere(mapping []string, i int, f string) bool {
+	for j, m := range mapping {
+		if j != i && m == f {
+			return true
+		}
+	}
+	return false
+}
+
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "yes", "y", "x", "1", "done", "completed":
+		return true, nil
+	case "false", "no", "n", "", "0", "todo", "open":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is not a completion state", s)
+}
+
+func parsePriority(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return "", nil
+	case "high", "h", "1", "a":
+		return "high", nil
+	case "medium", "med", "m", "2", "b":
+		return "medium", nil
+	case "low", "l", "3", "c":
+		return "low", nil
+	}
+	return "", fmt.Errorf("%q is not a priority", s)
+}
+
+// Import converts rows to todos, mapping each column to the todo field at the
+// same index of mapping. Columns mapped to the empty string are ignored, and a
+// field may only be mapped to one column. Rows without a title are skipped.
+func Import(rows [][]string, mapping []string) ([]model.Todo, error) {
+	mapped := make(map[string]bool)
+	for _, f := range mapping {
+		if f != "" && mapped[f] {
+			return nil, fmt.Errorf("%s is mapped to several columns", f)
+		}
+		mapped[f] = true
+	}
+
+	var todos []model.Todo
+	for n, row := range rows {
+		var t model.Todo
+		for i, v := range row {
+			if i >= len(mapping) {
+				break
+			}
+			v = unescapeFormula(strings.TrimSpace(v))
+			switch mapping[i] {
+			case "title":
+				t.Title = v
+			case "completed":
+				b, err := parseBool(v)
+				if err != nil {
+					return nil, fmt.Errorf("row %d: %w", n+2, err)
+				}
+				t.Completed = b
+			case "created":
+				t.Created = v
+			case "due":
+				t.Due = v
+			case "tags":
+				t.Tags = splitTags(v)
+			case "priority":
+				p, err := parsePriority(v)
+				if err != nil {
+					return nil, fmt.Errorf("row %d: %w", n+2, err)
+				}
+				t.Priority = p
+			case "notes":
+				t.Notes = v
+			case "list":
+				t.List = v
+			case "id":
+				t.ID = v
+			}
+		}
+		if t.Title == "" {
+			continue
+		}
+		todos = append(todos, t)
+	}
+	return todos, nil
+}
@@ -0,0 +1,105 @@
+package csvio
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+func TestRoundTrip(t *testing.T) {
+	todos := []model.Todo{
+		{Title: "=HYPERLINK(\"http://example.com\")", Tags: []string{"a, b", "c;d", `e\f`, "g"}},
+		{Title: "+1 for the idea", Notes: "-- signed", Priority: "high"},
+		{Title: "@mention", Notes: "\tindented", Completed: true},
+		{Title: "'quoted' title", Due: "2026-01-02"},
+	}
+	var b strings.Builder
+	if err := Write(&b, todos, DefaultColumns); err != nil {
+		t.Fatal(err)
+	}
+	header, rows, err := Read(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Import(rows, GuessMapping(header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, todos) {
+		t.Errorf("Import(Write(todos)) =\n%+v\nwant\n%+v", got, todos)
+	}
+}
+
+func TestWriteEscapesFormulas(t *testing.T) {
+	todos := []model.Todo{
+		{Title: "=1+2"}, {Title: "+1"}, {Title: "-1"}, {Title: "@SUM(A1)"},
+		{Title: "\t=1"}, {Title: "plain = text"},
+	}
+	var b strings.Builder
+	if err := Write(&b, todos, []string{"title"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "title\r\n'=1+2\r\n'+1\r\n'-1\r\n'@SUM(A1)\r\n'\t=1\r\nplain = text\r\n"
+	if b.String() != want {
+		t.Errorf("Write = %q, want %q", b.String(), want)
+	}
+}
+
+func TestReadByteOrderMark(t *testing.T) {
+	header, _, err := Read(strings.NewReader("\ufeffTitle,Done\r\nMilk,yes\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"title", "completed"}; !reflect.DeepEqual(GuessMapping(header), want) {
+		t.Errorf("GuessMapping(%q) = %q, want %q", header, GuessMapping(header), want)
+	}
+}
+
+func TestSplitTags(t *testing.T) {
+	tests := []struct {
+		cell string
+		want []string
+	}{
+		{"", nil},
+		{"a, b; #c", []string{"a", "b", "c"}},
+		{`a\, b, c\;d`, []string{"a, b", "c;d"}},
+		{`a\\, b`, []string{`a\`, "b"}},
+		{`trailing\`, []string{`trailing\`}},
+	}
+	for _, tt := range tests {
+		if got := splitTags(tt.cell); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitTags(%q) = %q, want %q", tt.cell, got, tt.want)
+		}
+	}
+}
+
+func TestImportRejectsDuplicateMapping(t *testing.T) {
+	rows := [][]string{{"Milk", "Bread"}}
+	if _, err := Import(rows, []string{"title", "title"}); err == nil {
+		t.Error("Import mapped title to two columns")
+	}
+	if _, err := Import(rows, []string{"title", ""}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNextField(t *testing.T) {
+	tests := []struct {
+		mapping []string
+		i       int
+		want    string
+	}{
+		{[]string{"", ""}, 0, "title"},
+		{[]string{"", "title"}, 0, "completed"},
+		{[]string{"title", "completed"}, 0, "created"},
+		{[]string{"id", ""}, 0, ""},
+		{[]string{"notes", "list", "id"}, 0, ""},
+	}
+	for _, tt := range tests {
+		if got := NextField(tt.mapping, tt.i); got != tt.want {
+			t.Errorf("NextField(%q, %d) = %q, want %q", tt.mapping, tt.i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Package eventlog keeps the changes of a todo list as a log of domain events,
+// from which the list is derived by a pure reducer. Snapshots of the list are
+// taken periodically, so that old events can be compacted away while the list
+// can still be rebuilt at any retained point.
+package eventlog
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// Event types. Newtodo, toggle, newtitle, delete, clear and toggleall are the
+// domain events of the app. Every other change of the list, such as a bulk
+// action, an import or a change made by another writer, is an ops event.
+const (
+	NewTodo   = "newtodo"
+	Toggle    = "toggle"
+	NewTitle  = "newtitle"
+	Delete    = "delete"
+	Clear     = "clear"
+	ToggleAll = "toggleall"
+	Ops       = "ops"
+)
+
+// Event is an entry of the log. Seq numbers the events of a log from 1.
+type Event struct {
+	Seq  int64  `json:"seq"`
+	Time string `json:"time"` // RFC 3339
+	Type string `json:"type"`
+
+	// ID is the todo of a toggle, newtitle or delete, Title the new title of
+	// a newtitle and Completed the state set by a toggleall.
+	ID        string `json:"id,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Completed bool   `json:"completed,omitempty"`
+
+	// Todo is the todo added by a newtodo, Ops the changes of an ops event
+	// and Cause what made them: CauseEdit or CauseRemote.
+	Todo  *model.Todo `json:"todo,omitempty"`
+	Ops   []model.Op  `json:"ops,omitempty"`
+	Cause string      `json:"cause,omitempty"`
+}
+
+// Causes of ops events.
+const (
+	CauseEdit   = "edit"   // a change made in the app
+	CauseRemote = "remote" // a change made by another writer
+)
+
+// Describe returns a short description of an event.
+func (e Event) Describe() string {
+	switch e.Type {
+	case NewTodo:
+		if e.Todo != nil {
+			return "newtodo " + strconv.Quote(e.Todo.Title)
+		}
+	case Toggle, Delete:
+		return e.Type + " " + e.ID
+	case NewTitle:
+		return "newtitle " + e.ID + " " + strconv.Quote(e.Title)
+	case ToggleAll:
+		return "toggleall " + strconv.FormatBool(e.Completed)
+	case Ops:
+		return "ops (" + e.Cause + "): " + strconv.Itoa(len(e.Ops)) + " changes"
+	}
+	return e.Type
+}
+
+// setCompleted sets the completion state of a todo, recording when it was
+// completed.
+func setCompleted(t *model.Todo, completed bool, at string) {
+	if t.Completed == completed {
+		return
+	}
+	t.Completed = completed
+	t.CompletedAt = ""
+	if completed {
+		t.CompletedAt = at
+	}
+}
+
+// Reduce returns the list that results from applying e to todos, which is
+// left unchanged. Events about todos that no longer exist change nothing.
+func Reduce(todos []model.Todo, e Event) []model.Todo {
+	next := make([]model.Todo, 0, len(todos)+1)
+	switch e.Type {
+	case NewTodo:
+		next = append(next, todos...)
+		if e.Todo != nil {
+			next = append(next, *e.Todo)
+		}
+	case Toggle:
+		for _, t := range todos {
+			if t.ID == e.ID {
+				setCompleted(&t, !t.Completed, e.Time)
+			}
+			next = append(next, t)
+		}
+	case NewTitle:
+		for _, t := range todos {
+			if t.ID == e.ID {
+				// Todos left without title are deleted.
+				if e.Title == "" {
+					continue
+				}
+				t.Title = e.Title
+			}
+			next = append(next, t)
+		}
+	case Delete:
+		for _, t := range todos {
+			if t.ID != e.ID {
+				next = append(next, t)
+			}
+		}
+	case Clear:
+		for _, t := range todos {
+			if !t.Completed {
+				next = append(next, t)
+			}
+		}
+	case ToggleAll:
+		for _, t := range todos {
+			setCompleted(&t, e.Completed, e.Time)
+			next = append(next, t)
+		}
+	case Ops:
+		next = model.Apply(todos, e.Ops)
+	default:
+		next = append(next, todos...)
+	}
+	return next
+}
+
+// Replay returns the list that results from applying events to todos in turn.
+func Replay(todos []model.Todo, events ...Event) []model.Todo {
+	for _, e := range events {
+		todos = Reduce(todos, e)
+	}
+	return todos
+}
+
+// Snapshot is the list as it was after the event Seq.
+type Snapshot struct {
+	Seq   int64        `json:"seq"`
+	Todos []model.Todo `json:"todos"`
+}
+
+const (
+	// SnapshotEvery is the number of events between two snapshots.
+	SnapshotEvery = 100
+
+	// KeepEvents is the number of latest events that compaction keeps at
+	// least.
+	KeepEvents = 500
+)
+
+// Log is a log of events. Snapshots, oldest first, are never empty: the first
+// one is the base from which Events, the events that follow it, are replayed.
+type Log struct {
+	Snapshots []Snapshot `json:"snapshots"`
+	Events    []Event    `json:"events"`
+}
+
+// New returns a log starting from todos.
+func New(todos []model.Todo) *Log {
+	return &Log{Snapshots: []Snapshot{{Todos: todos}}}
+}
+
+// Last returns the sequence number of the last event of the log.
+func (l *Log) Last() int64 {
+	if n := len(l.Events); n > 0 {
+		return l.Events[n-1].Seq
+	}
+	return l.Snapshots[len(l.Snapshots)-1].Seq
+}
+
+// First returns the sequence number of the oldest point the list can be
+// rebuilt at.
+func (l *Log) First() int64 {
+	return l.Snapshots[0].Seq
+}
+
+// Append adds e to the log, numbering it, and returns it. A snapshot is taken
+// every SnapshotEvery events, after which the log is compacted.
+func (l *Log) Append(e Event) Event {
+	e.Seq = l.Last() + 1
+	l.Events = append(l.Events, e)
+	if e.Seq%SnapshotEvery == 0 {
+		l.Snapshots = append(l.Snapshots, Snapshot{Seq: e.Seq, Todos: l.State()})
+		l.Compact(KeepEvents)
+	}
+	return e
+}
+
+// At returns the list as it was after the event seq, which must be between
+// First and Last.
+func (l *Log) At(seq int64) ([]model.Todo, bool) {
+	if seq < l.First() || seq > l.Last() {
+		return nil, false
+	}
+	base := l.Snapshots[0]
+	for _, s := range l.Snapshots {
+		if s.Seq <= seq {
+			base = s
+		}
+	}
+	todos := append([]model.Todo(nil), base.Todos...)
+	for _, e := range l.Events {
+		if e.Seq > base.Seq && e.Seq <= seq {
+			todos = Reduce(todos, e)
+		}
+	}
+	return todos, true
+}
+
+// State returns the list after the last event.
+func (l *Log) State() []model.Todo {
+	todos, _ := l.At(l.Last())
+	return todos
+}
+
+// Compact drops the events and snapshots that are not needed to rebuild the
+// list at any of its keep latest events.
+func (l *Log) Compact(keep int) {
+	limit := l.Last() - int64(keep)
+	base := 0
+	for i, s := range l.Snapshots {
+		if s.Seq <= limit {
+			base = i
+		}
+	}
+	l.Snapshots = append([]Snapshot(nil), l.Snapshots[base:]...)
+	first := l.Snapshots[0].Seq
+	i := 0
+	for i < len(l.Events) && l.Events[i].Seq <= first {
+		i++
+	}
+	l.Events = append([]Event(nil), l.Events[i:]...)
+}
+
+// Sync appends an ops event turning the state of the log into todos, unless
+// they only differ by the times of their last change. It reports whether an
+// event was appended.
+func (l *Log) Sync(todos []model.Todo, cause string, now time.Time) (Event, bool) {
+	var ops []model.Op
+	for _, op := range model.Diff(l.State(), todos) {
+		if len(op.Fields) == 1 && op.Fields[0] == "updated" {
+			continue
+		}
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return Event{}, false
+	}
+	return l.Append(Event{Time: now.UTC().Format(time.RFC3339), Type: Ops, Ops: ops, Cause: cause}), true
+}
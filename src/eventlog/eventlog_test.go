@@ -0,0 +1,194 @@
+package eventlog
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+const at = "2026-10-21T10:00:00Z"
+
+func todo(id string, title string, completed bool) model.Todo {
+	t := model.Todo{ID: id, Title: title, Completed: completed}
+	if completed {
+		t.CompletedAt = "2026-10-20T09:00:00Z"
+	}
+	return t
+}
+
+func TestReduce(t *testing.T) {
+	a, b, c := todo("a", "A", false), todo("b", "B", true), todo("c", "C", false)
+	list := []model.Todo{a, b}
+
+	completedA := a
+	completedA.Completed, completedA.CompletedAt = true, at
+	reopenedB := b
+	reopenedB.Completed, reopenedB.CompletedAt = false, ""
+	renamedA := a
+	renamedA.Title = "A2"
+	completedAt := func(t model.Todo) model.Todo {
+		if !t.Completed {
+			t.Completed, t.CompletedAt = true, at
+		}
+		return t
+	}
+
+	tests := []struct {
+		name  string
+		event Event
+		want  []model.Todo
+	}{
+		{"newtodo", Event{Type: NewTodo, Todo: &c}, []model.Todo{a, b, c}},
+		{"newtodo without todo", Event{Type: NewTodo}, list},
+		{"toggle", Event{Type: Toggle, ID: "a"}, []model.Todo{completedA, b}},
+		{"toggle completed", Event{Type: Toggle, ID: "b"}, []model.Todo{a, reopenedB}},
+		{"toggle unknown", Event{Type: Toggle, ID: "x"}, list},
+		{"newtitle", Event{Type: NewTitle, ID: "a", Title: "A2"}, []model.Todo{renamedA, b}},
+		{"newtitle empty", Event{Type: NewTitle, ID: "a"}, []model.Todo{b}},
+		{"delete", Event{Type: Delete, ID: "b"}, []model.Todo{a}},
+		{"delete unknown", Event{Type: Delete, ID: "x"}, list},
+		{"clear", Event{Type: Clear}, []model.Todo{a}},
+		{"toggleall", Event{Type: ToggleAll, Completed: true}, []model.Todo{completedAt(a), b}},
+		{"toggleall off", Event{Type: ToggleAll}, []model.Todo{a, reopenedB}},
+		{"ops", Event{Type: Ops, Ops: []model.Op{{Kind: model.OpDelete, ID: "a"}}}, []model.Todo{b}},
+		{"unknown", Event{Type: "rename"}, list},
+	}
+	for _, tt := range tests {
+		before := append([]model.Todo(nil), list...)
+		tt.event.Time = at
+		got := Reduce(list, tt.event)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Reduce = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(list, before) {
+			t.Errorf("%s: Reduce changed its input to %+v", tt.name, list)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	a, b := todo("a", "A", false), todo("b", "B", false)
+	events := []Event{
+		{Time: at, Type: NewTodo, Todo: &a},
+		{Time: at, Type: NewTodo, Todo: &b},
+		{Time: at, Type: Toggle, ID: "a"},
+		{Time: at, Type: NewTitle, ID: "b", Title: "B2"},
+		{Time: at, Type: Clear},
+	}
+	want := []model.Todo{{ID: "b", Title: "B2"}}
+	if got := Replay(nil, events...); !reflect.DeepEqual(got, want) {
+		t.Errorf("Replay = %+v, want %+v", got, want)
+	}
+	if got := Replay(want); !reflect.DeepEqual(got, want) {
+		t.Errorf("Replay without events = %+v, want %+v", got, want)
+	}
+}
+
+// newLog returns a log of n newtodo events, and the list after each of them.
+func newLog(n int) (*Log, [][]model.Todo) {
+	l := New(nil)
+	states := [][]model.Todo{nil}
+	for i := 1; i <= n; i++ {
+		t := todo(strconv.Itoa(i), "Todo "+strconv.Itoa(i), false)
+		l.Append(Event{Time: at, Type: NewTodo, Todo: &t})
+		states = append(states, append(append([]model.Todo(nil), states[i-1]...), t))
+	}
+	return l, states
+}
+
+func TestAppend(t *testing.T) {
+	l, states := newLog(2*SnapshotEvery + 10)
+	if got := l.Last(); got != 2*SnapshotEvery+10 {
+		t.Errorf("Last = %d", got)
+	}
+	var seqs []int64
+	for _, s := range l.Snapshots {
+		seqs = append(seqs, s.Seq)
+	}
+	if want := []int64{0, SnapshotEvery, 2 * SnapshotEvery}; !reflect.DeepEqual(seqs, want) {
+		t.Errorf("snapshots at %v, want %v", seqs, want)
+	}
+	for _, s := range l.Snapshots {
+		if !reflect.DeepEqual(s.Todos, states[s.Seq]) {
+			t.Errorf("snapshot %d = %d todos, want %d", s.Seq, len(s.Todos), len(states[s.Seq]))
+		}
+	}
+	for seq := l.First(); seq <= l.Last(); seq++ {
+		got, ok := l.At(seq)
+		if !ok || !reflect.DeepEqual(got, states[seq]) {
+			t.Fatalf("At(%d) = %d todos, %v, want %d", seq, len(got), ok, len(states[seq]))
+		}
+	}
+	if _, ok := l.At(l.Last() + 1); ok {
+		t.Errorf("At after the last event succeeded")
+	}
+	if !reflect.DeepEqual(l.State(), states[len(states)-1]) {
+		t.Errorf("State differs from the last list")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	n := KeepEvents + 3*SnapshotEvery + 10
+	l, states := newLog(n)
+
+	// Append compacts on every snapshot: the oldest point left is the last
+	// snapshot at least KeepEvents events old.
+	first := int64((n-KeepEvents)/SnapshotEvery) * SnapshotEvery
+	if got := l.First(); got != first {
+		t.Errorf("First = %d, want %d", got, first)
+	}
+	if got := l.Last() - l.First(); got < KeepEvents {
+		t.Errorf("%d events kept, want at least %d", got, KeepEvents)
+	}
+	if len(l.Events) == 0 || l.Events[0].Seq != first+1 {
+		t.Errorf("events start after %d, want %d", l.First(), first)
+	}
+
+	l.Compact(5)
+	limit := l.Last() - 5
+	if got, want := l.First(), limit-limit%SnapshotEvery; got != want {
+		t.Errorf("First after Compact(5) = %d, want %d", got, want)
+	}
+	for seq := l.Last() - 5; seq <= l.Last(); seq++ {
+		got, ok := l.At(seq)
+		if !ok || !reflect.DeepEqual(got, states[seq]) {
+			t.Errorf("At(%d) after Compact(5) = %d todos, %v", seq, len(got), ok)
+		}
+	}
+	for _, e := range l.Events {
+		if e.Seq <= l.First() {
+			t.Errorf("event %d kept before the first snapshot %d", e.Seq, l.First())
+		}
+	}
+
+	// Compacting everything away but the last point leaves the state as it
+	// is.
+	l.Compact(0)
+	if !reflect.DeepEqual(l.State(), states[n]) {
+		t.Errorf("State after Compact(0) differs from the last list")
+	}
+}
+
+func TestSync(t *testing.T) {
+	a := todo("a", "A", false)
+	l := New([]model.Todo{a})
+	now := time.Date(2026, 10, 21, 10, 0, 0, 0, time.UTC)
+
+	touched := a
+	touched.Updated = now.Format(time.RFC3339)
+	if _, ok := l.Sync([]model.Todo{touched}, CauseEdit, now); ok {
+		t.Errorf("Sync recorded a change of the update time only")
+	}
+
+	b := todo("b", "B", false)
+	e, ok := l.Sync([]model.Todo{a, b}, CauseRemote, now)
+	if !ok || e.Type != Ops || e.Cause != CauseRemote || e.Seq != 1 {
+		t.Fatalf("Sync = %+v, %v", e, ok)
+	}
+	if !reflect.DeepEqual(l.State(), []model.Todo{a, b}) {
+		t.Errorf("State after Sync = %+v", l.State())
+	}
+}
@@ -0,0 +1,403 @@
+// Package ical reads and writes todos as RFC 5545 iCalendar VTODO components.
+//
+// VTODO properties map to todos as follows:
+//   - UID, SUMMARY, DESCRIPTION and RRULE map to ID, Title, Notes and
+//     Recurrence
+//   - STATUS:COMPLETED, or a COMPLETED date-time, marks the todo as completed
+//   - DUE, COMPLETED and CREATED map to Due, CompletedAt and Created
+//   - PRIORITY 1 to 4 maps to high, 5 to medium and 6 to 9 to low
+//   - CATEGORIES map to Tags
+//
+// Other properties and nested components, such as VALARM, are kept verbatim in
+// ICal and written back on export. So are the properties above that have
+// parameters, such as the TZID of DUE, or a STATUS other than COMPLETED and
+// NEEDS-ACTION: their parameters, or their status, replace the ones written on
+// export.
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// ProdID identifies the app in exported calendars.
+const ProdID = "-//todomvc2//todos//EN"
+
+// contentLine is an unfolded iCalendar content line. params holds the
+// parameters as written, with their leading ';'.
+type contentLine struct {
+	raw    string
+	name   string
+	params string
+	value  string
+}
+
+// parseContentLine splits a content line into its name and value. Quoted
+// parameter values may contain ':' and ';'.
+func parseContentLine(raw string) (contentLine, error) {
+	l := contentLine{raw: raw}
+	var quoted bool
+	colon := -1
+	for i, r := range raw {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return l, fmt.Errorf("invalid content line %q", raw)
+	}
+	l.value = raw[colon+1:]
+
+	name, _, _ := strings.Cut(raw[:colon], ";")
+	l.name = strings.ToUpper(name)
+	l.params = raw[len(name):colon]
+	return l, nil
+}
+
+// unfold joins the folded lines of an iCalendar stream.
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		l := strings.TrimRight(s.Text(), "\r")
+		if len(l) > 0 && (l[0] == ' ' || l[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines, s.Err()
+}
+
+var textUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, `;`, `\,`, `,`, `\n`, "\n", `\N`, "\n")
+var textEscaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\n", `\n`)
+
+// splitText splits a multi-valued TEXT property on unescaped commas.
+func splitText(v string) []string {
+	var values []string
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		switch {
+		case v[i] == '\\' && i+1 < len(v):
+			b.WriteByte(v[i])
+			b.WriteByte(v[i+1])
+			i++
+		case v[i] == ',':
+			values = append(values, textUnescaper.Replace(b.String()))
+			b.Reset()
+		default:
+			b.WriteByte(v[i])
+		}
+	}
+	return append(values, textUnescaper.Replace(b.String()))
+}
+
+// parseDateTime converts an iCalendar DATE or DATE-TIME value to ISO 8601.
+// UTC date-times keep their zone, floating and zoned ones are kept as local
+// date-times.
+func parseDateTime(v string) (string, error) {
+	switch {
+	case len(v) == 8:
+		t, err := time.Parse("20060102", v)
+		if err != nil {
+			return "", err
+		}
+		return t.Format("2006-01-02"), nil
+	case strings.HasSuffix(v, "Z"):
+		t, err := time.Parse("20060102T150405Z", v)
+		if err != nil {
+			return "", err
+		}
+		return t.Format(time.RFC3339), nil
+	default:
+		t, err := time.Parse("20060102T150405", v)
+		if err != nil {
+			return "", err
+		}
+		return t.Format("2006-01-02T15:04"), nil
+	}
+}
+
+// formatDateTime converts an ISO 8601 date or date-time to an iCalendar
+// property, with a VALUE=DATE parameter for dates.
+func formatDateTime(name string, v string) (string, bool) {
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return name + ";VALUE=DATE:" + t.Format("20060102"), true
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return name + ":" + t.UTC().Format("20060102T150405Z"), true
+	}
+	if t, err := time.Parse("2006-01-02T15:04", v); err == nil {
+		return name + ":" + t.Format("20060102T150405"), true
+	}
+	return "", false
+}
+
+func priority(v string) string {
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	switch {
+	case err != nil || n <= 0 || n > 9:
+		return ""
+	case n <= 4:
+		return "high"
+	case n == 5:
+		return "medium"
+	}
+	return "low"
+}
+
+var priorityValues = map[string]string{"high": "1", "medium": "5", "low": "9"}
+
+// mapped are the properties of a VTODO mapped to todo fields.
+var mapped = map[string]bool{
+	"UID": true, "SUMMARY": true, "DESCRIPTION": true, "STATUS": true,
+	"COMPLETED": true, "DUE": true, "CREATED": true, "PRIORITY": true,
+	"CATEGORIES": true, "RRULE": true,
+}
+
+// withoutParams removes the parameters of the given names from params.
+func withoutParams(params string, names ...string) string {
+	var kept []string
+	for _, p := range strings.Split(params, ";")[1:] {
+		name, _, _ := strings.Cut(p, "=")
+		drop := false
+		for _, n := range names {
+			drop = drop || strings.EqualFold(name, n)
+		}
+		if !drop {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == 0 {
+		return ""
+	}
+	return ";" + strings.Join(kept, ";")
+}
+
+// Parse reads the VTODO components of an iCalendar stream. Other components
+// are ignored.
+func Parse(r io.Reader) ([]model.Todo, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var todos []model.Todo
+	var t *model.Todo
+	var nested []string // stack of the components nested in the current VTODO
+
+	for _, raw := range lines {
+		l, err := parseContentLine(raw)
+		if err != nil {
+			return nil, err
+		}
+		value := strings.ToUpper(l.value)
+
+		if t == nil {
+			if l.name == "BEGIN" && value == "VTODO" {
+				t = &model.Todo{}
+			}
+			continue
+		}
+
+		switch {
+		case l.name == "BEGIN":
+			nested = append(nested, value)
+			t.ICal = append(t.ICal, raw)
+			continue
+		case l.name == "END" && len(nested) > 0:
+			nested = nested[:len(nested)-1]
+			t.ICal = append(t.ICal, raw)
+			continue
+		case l.name == "END" && value == "VTODO":
+			todos = append(todos, *t)
+			t = nil
+			continue
+		case len(nested) > 0:
+			t.ICal = append(t.ICal, raw)
+			continue
+		}
+
+		if l.params != "" && mapped[l.name] && l.name != "STATUS" {
+			t.ICal = append(t.ICal, raw)
+		}
+		switch l.name {
+		case "UID":
+			t.ID = l.value
+		case "SUMMARY":
+			t.Title = textUnescaper.Replace(l.value)
+		case "DESCRIPTION":
+			t.Notes = textUnescaper.Replace(l.value)
+		case "STATUS":
+			switch value {
+			case "COMPLETED":
+				t.Completed = true
+			case "NEEDS-ACTION":
+			default:
+				t.ICal = append(t.ICal, raw)
+			}
+		case "COMPLETED", "DUE", "CREATED":
+			d, err := parseDateTime(l.value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", l.name, err)
+			}
+			switch l.name {
+			case "COMPLETED":
+				t.CompletedAt = d
+				t.Completed = true
+			case "DUE":
+				t.Due = d
+			case "CREATED":
+				t.Created = d
+			}
+		case "PRIORITY":
+			t.Priority = priority(l.value)
+		case "CATEGORIES":
+			for _, c := range splitText(l.value) {
+				if c != "" {
+					t.Tags = append(t.Tags, c)
+				}
+			}
+		case "RRULE":
+			t.Recurrence = l.value
+		case "DTSTAMP", "LAST-MODIFIED", "SEQUENCE":
+			// Regenerated on export.
+		default:
+			t.ICal = append(t.ICal, raw)
+		}
+	}
+	return todos, nil
+}
+
+// fold writes a content line, folded into lines of at most 75 octets without
+// splitting UTF-8 sequences.
+func fold(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		n := limit
+		for n > 0 && line[n]&0xC0 == 0x80 {
+			n--
+		}
+		b.WriteString(line[:n])
+		b.WriteString("\r\n ")
+		line = line[n:]
+		// Continuation lines start with a space.
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// Format returns an iCalendar stream holding a VTODO component per todo.
+// now is used as the DTSTAMP of every component.
+func Format(todos []model.Todo, now time.Time) string {
+	var b strings.Builder
+	fold(&b, "BEGIN:VCALENDAR")
+	fold(&b, "VERSION:2.0")
+	fold(&b, "PRODID:"+ProdID)
+
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, t := range todos {
+		// The parameters and status kept from import, and the other lines.
+		params := make(map[string]string)
+		var status string
+		var other []string
+		depth := 0
+		for _, raw := range t.ICal {
+			l, err := parseContentLine(raw)
+			switch {
+			case err != nil || depth > 0 || !mapped[l.name]:
+				other = append(other, raw)
+				switch {
+				case err != nil:
+				case l.name == "BEGIN":
+					depth++
+				case l.name == "END":
+					depth--
+				}
+				continue
+			case l.name == "STATUS" && status == "":
+				status = raw
+			case l.name == "STATUS":
+			default:
+				if _, ok := params[l.name]; !ok {
+					params[l.name] = l.params
+				}
+			}
+		}
+		prop := func(name string) string {
+			return name + params[name] + ":"
+		}
+
+		fold(&b, "BEGIN:VTODO")
+		fold(&b, prop("UID")+t.ID)
+		fold(&b, "DTSTAMP:"+stamp)
+		fold(&b, prop("SUMMARY")+textEscaper.Replace(t.Title))
+		switch {
+		case t.Completed:
+			fold(&b, "STATUS:COMPLETED")
+		case status != "":
+			fold(&b, status)
+		default:
+			fold(&b, "STATUS:NEEDS-ACTION")
+		}
+		for _, d := range [...]struct{ name, v string }{
+			{"DUE", t.Due}, {"COMPLETED", t.CompletedAt}, {"CREATED", t.Created},
+		} {
+			name, v := d.name, d.v
+			if v == "" {
+				continue
+			}
+			if name != "DUE" {
+				// COMPLETED and CREATED must be UTC date-times.
+				if d, err := time.Parse("2006-01-02", v); err == nil {
+					v = d.Format(time.RFC3339)
+				}
+			}
+			// The value type follows the value, and only local date-times
+			// have a time zone.
+			p := withoutParams(params[name], "VALUE")
+			if _, err := time.Parse("2006-01-02T15:04", v); err != nil {
+				p = withoutParams(p, "TZID")
+			}
+			if l, ok := formatDateTime(name+p, v); ok {
+				fold(&b, l)
+			}
+		}
+		if p, ok := priorityValues[t.Priority]; ok {
+			fold(&b, prop("PRIORITY")+p)
+		}
+		if len(t.Tags) > 0 {
+			tags := make([]string, len(t.Tags))
+			for i, tag := range t.Tags {
+				tags[i] = textEscaper.Replace(tag)
+			}
+			fold(&b, prop("CATEGORIES")+strings.Join(tags, ","))
+		}
+		if t.Recurrence != "" {
+			fold(&b, prop("RRULE")+t.Recurrence)
+		}
+		if t.Notes != "" {
+			fold(&b, prop("DESCRIPTION")+textEscaper.Replace(t.Notes))
+		}
+		for _, raw := range other {
+			fold(&b, raw)
+		}
+		fold(&b, "END:VTODO")
+	}
+	fold(&b, "END:VCALENDAR")
+	return b.String()
+}
@@ -0,0 +1,128 @@
+package ical
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+var now = time.Date(2026, 10, 21, 10, 0, 0, 0, time.UTC)
+
+// calendar returns an iCalendar stream holding a VTODO with the given lines.
+func calendar(lines ...string) string {
+	all := append([]string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:" + ProdID, "BEGIN:VTODO"}, lines...)
+	all = append(all, "END:VTODO", "END:VCALENDAR", "")
+	return strings.Join(all, "\r\n")
+}
+
+func parseOne(t *testing.T, lines ...string) model.Todo {
+	t.Helper()
+	todos, err := Parse(strings.NewReader(calendar(lines...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(todos) != 1 {
+		t.Fatalf("Parse returned %d todos", len(todos))
+	}
+	return todos[0]
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		out  []string // the lines after DTSTAMP
+	}{
+		{
+			"fields",
+			[]string{"UID:a", "SUMMARY:Buy milk\\, eggs", "STATUS:NEEDS-ACTION", "DUE;VALUE=DATE:20261030", "PRIORITY:1", "CATEGORIES:home,shop"},
+			[]string{"SUMMARY:Buy milk\\, eggs", "STATUS:NEEDS-ACTION", "DUE;VALUE=DATE:20261030", "PRIORITY:1", "CATEGORIES:home,shop"},
+		},
+		{
+			"other status",
+			[]string{"UID:a", "SUMMARY:Paint", "STATUS:IN-PROCESS", "PERCENT-COMPLETE:40"},
+			[]string{"SUMMARY:Paint", "STATUS:IN-PROCESS", "PERCENT-COMPLETE:40"},
+		},
+		{
+			"parameters",
+			[]string{"UID:a", "SUMMARY;LANGUAGE=fr:Peindre", "DUE;TZID=Europe/Paris:20261030T090000"},
+			[]string{"SUMMARY;LANGUAGE=fr:Peindre", "STATUS:NEEDS-ACTION", "DUE;TZID=Europe/Paris:20261030T090000"},
+		},
+		{
+			"nested",
+			[]string{"UID:a", "SUMMARY:Call", "BEGIN:VALARM", "ACTION:DISPLAY", "STATUS:X", "END:VALARM"},
+			[]string{"SUMMARY:Call", "STATUS:NEEDS-ACTION", "BEGIN:VALARM", "ACTION:DISPLAY", "STATUS:X", "END:VALARM"},
+		},
+	}
+	for _, tt := range tests {
+		todo := parseOne(t, tt.in...)
+		want := calendar(append([]string{"UID:a", "DTSTAMP:20261021T100000Z"}, tt.out...)...)
+		if got := Format([]model.Todo{todo}, now); got != want {
+			t.Errorf("%s: Format =\n%s\nwant\n%s", tt.name, got, want)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	todo := parseOne(t, "UID:a", "SUMMARY:Paint", "STATUS:CANCELLED")
+	if todo.Completed {
+		t.Errorf("cancelled todo completed")
+	}
+
+	// A single status is written, whatever the status kept from import.
+	for _, completed := range []bool{false, true} {
+		todo.Completed = completed
+		out := Format([]model.Todo{todo}, now)
+		if n := strings.Count(out, "\r\nSTATUS:"); n != 1 {
+			t.Errorf("completed %v: %d STATUS properties in\n%s", completed, n, out)
+		}
+		want := "STATUS:CANCELLED"
+		if completed {
+			want = "STATUS:COMPLETED"
+		}
+		if !strings.Contains(out, "\r\n"+want+"\r\n") {
+			t.Errorf("completed %v: no %s in\n%s", completed, want, out)
+		}
+	}
+}
+
+func TestChangedParameters(t *testing.T) {
+	todo := parseOne(t, "UID:a", "SUMMARY:Paint", "DUE;TZID=Europe/Paris:20261030T090000")
+	if todo.Due != "2026-10-30T09:00" {
+		t.Fatalf("Due = %q", todo.Due)
+	}
+	tests := []struct {
+		due  string
+		want string
+	}{
+		{"2026-11-02T10:30", "DUE;TZID=Europe/Paris:20261102T103000"},
+		{"2026-11-02", "DUE;VALUE=DATE:20261102"},
+		{"2026-11-02T10:30:00Z", "DUE:20261102T103000Z"},
+	}
+	for _, tt := range tests {
+		todo.Due = tt.due
+		out := Format([]model.Todo{todo}, now)
+		if !strings.Contains(out, "\r\n"+tt.want+"\r\n") || strings.Count(out, "DUE") != 1 {
+			t.Errorf("Due %s: want %s in\n%s", tt.due, tt.want, out)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	todo := parseOne(t,
+		"UID:a", "SUMMARY:Report", "DESCRIPTION:Line 1\\nLine 2", "STATUS:COMPLETED",
+		"COMPLETED:20261020T100000Z", "CREATED:20261001T080000Z", "PRIORITY:6",
+		"CATEGORIES:work", "CATEGORIES:q4\\,2026", "RRULE:FREQ=WEEKLY", "DTSTAMP:20261021T100000Z",
+	)
+	want := model.Todo{
+		ID: "a", Title: "Report", Notes: "Line 1\nLine 2", Completed: true,
+		CompletedAt: "2026-10-20T10:00:00Z", Created: "2026-10-01T08:00:00Z", Priority: "low",
+		Tags: []string{"work", "q4,2026"}, Recurrence: "FREQ=WEEKLY",
+	}
+	if !reflect.DeepEqual(todo, want) {
+		t.Errorf("Parse = %+v, want %+v", todo, want)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	ui "github.com/atdiar/particleui"
+	doc "github.com/atdiar/particleui/drivers/js"
+
+	"github.com/atdiar/todomvc2/src/eventlog"
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// Inspector is the time-travel inspector of development builds, added when
+// HMRMode is on. It lists the events of the event journal, each a transition of
+// the todoslist with the event that triggered it, and lets one preview the
+// list after any of them, diff the lists after two of them, and export the log
+// as a reproduction that can be loaded back.
+//
+// Previews do not change the stored list: they end with "Back to present", or
+// as soon as another writer changes the list. "Restore" makes the previewed
+// list the current one, as an undoable change.
+type Inspector struct {
+	*ui.Element
+}
+
+func InspectorFromRef(ref *ui.Element) Inspector {
+	return Inspector{ref}
+}
+
+func NewInspector(d *doc.Document, id string, tlist TodosListElement, options ...string) Inspector {
+	return Inspector{newinspector(d, id, tlist, options...)}
+}
+
+// parseRepro decodes a reproduction exported by the inspector.
+func parseRepro(content string) (eventlog.Log, error) {
+	var l eventlog.Log
+	if err := json.Unmarshal([]byte(content), &l); err != nil {
+		return l, err
+	}
+	if len(l.Snapshots) == 0 {
+		return l, errors.New("not an event log: no snapshot")
+	}
+	return l, nil
+}
+
+func newinspector(document *doc.Document, id string, tlist TodosListElement, options ...string) *ui.Element {
+	root := document.Div.WithID(id, options...).AsElement()
+	doc.AddClass(root, "inspector")
+
+	var (
+		log    eventlog.Log
+		live   = true // log is the journal, rather than a loaded reproduction
+		open   bool   // the panel is shown
+		cursor int64  // the event the list is previewed at
+		marks  = [2]int64{-1, -1}
+		rows   []*ui.Element
+	)
+
+	toggle := document.Button.WithID(id+"-toggle", "button")
+	doc.AddClass(toggle.AsElement(), "inspector-toggle")
+	toggle.SetText("Inspector")
+
+	panel := document.Div.WithID(id + "-panel")
+	doc.AddClass(panel.AsElement(), "inspector-panel")
+	doc.SetInlineCSS(panel.AsElement(), "display:none")
+
+	source := document.Span.WithID(id + "-source")
+	position := document.Span.WithID(id + "-position")
+	slider := document.Input.WithID(id+"-slider", "range")
+	doc.SetAttribute(slider.AsElement(), "step", "1")
+
+	present := document.Button.WithID(id+"-present", "button")
+	present.SetText("Back to present")
+	restore := document.Button.WithID(id+"-restore", "button")
+	restore.SetText("Restore")
+	golive := document.Button.WithID(id+"-live", "button")
+	golive.SetText("Live log")
+	export := document.Button.WithID(id+"-export", "button")
+	export.SetText("Export")
+	load := document.Button.WithID(id+"-load", "button")
+	load.SetText("Load…")
+	file := document.Input.WithID(id+"-file", "file")
+	doc.SetAttribute(file.AsElement(), "accept", ".json")
+	doc.SetInlineCSS(file.AsElement(), "display:none")
+
+	events := document.Ul.WithID(id + "-events")
+	doc.AddClass(events.AsElement(), "inspector-events")
+	diff := document.Div.WithID(id + "-diff")
+	doc.AddClass(diff.AsElement(), "inspector-diff")
+
+	event := func(seq int64) (eventlog.Event, bool) {
+		for _, e := range log.Events {
+			if e.Seq == seq {
+				return e, true
+			}
+		}
+		return eventlog.Event{}, false
+	}
+
+	describe := func(seq int64) string {
+		if e, ok := event(seq); ok {
+			return "#" + strconv.FormatInt(seq, 10) + " " + e.Describe()
+		}
+		return "#" + strconv.FormatInt(seq, 10) + " snapshot"
+	}
+
+	showPosition := func() {
+		text := describe(cursor) + " (" + strconv.FormatInt(cursor-log.First(), 10) + "/" + strconv.FormatInt(log.Last()-log.First(), 10) + ")"
+		if !tlist.Previewing() {
+			text = "Present: " + text
+		}
+		position.SetText(text)
+		if s, ok := doc.JSValue(slider.AsElement()); ok {
+			s.Set("value", strconv.FormatInt(cursor, 10))
+		}
+	}
+
+	showDiff := func() {
+		if marks[0] < 0 || marks[1] < 0 {
+			diff.SetText("Mark two events A and B to diff the lists after them.")
+			return
+		}
+		before, ok1 := log.At(marks[0])
+		after, ok2 := log.At(marks[1])
+		if !ok1 || !ok2 {
+			diff.SetText("The marked events are no longer in the log.")
+			return
+		}
+		var lines []string
+		for _, c := range model.History(before, after, "", time.Time{}) {
+			lines = append(lines, c.Describe())
+		}
+		if len(lines) == 0 {
+			lines = append(lines, "No difference.")
+		}
+		diff.SetText("A " + describe(marks[0]) + " → B " + describe(marks[1]) + ":\n" + strings.Join(lines, "\n"))
+	}
+
+	var render func()
+
+	// scrub previews the list after the event seq. The last event of the live
+	// log is the present.
+	scrub := func(seq int64) {
+		todos, ok := log.At(seq)
+		if !ok {
+			return
+		}
+		cursor = seq
+		if live && seq == log.Last() {
+			tlist.EndPreview()
+		} else {
+			tlist.Preview(listFromModel(todos))
+		}
+		showPosition()
+	}
+
+	mark := func(i int, seq int64) {
+		marks[i] = seq
+		render()
+	}
+
+	render = func() {
+		if live {
+			source.SetText("Event journal")
+		} else {
+			source.SetText("Loaded reproduction")
+		}
+		doc.SetAttribute(slider.AsElement(), "min", strconv.FormatInt(log.First(), 10))
+		doc.SetAttribute(slider.AsElement(), "max", strconv.FormatInt(log.Last(), 10))
+
+		for _, row := range rows {
+			ui.Delete(row)
+		}
+		rows = rows[:0]
+		// Newest first, the base snapshot last.
+		seqs := []int64{log.First()}
+		for _, e := range log.Events {
+			seqs = append(seqs, e.Seq)
+		}
+		for i := len(seqs) - 1; i >= 0; i-- {
+			seq := seqs[i]
+			row := document.Li()
+
+			label := document.Span()
+			text := describe(seq)
+			if e, ok := event(seq); ok {
+				if t, err := time.Parse(time.RFC3339, e.Time); err == nil {
+					text = t.Local().Format("15:04:05") + " " + text
+				}
+			}
+			label.SetText(text)
+			label.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+				scrub(seq)
+				return false
+			}))
+
+			a := document.Button()
+			a.SetText("A")
+			a.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+				mark(0, seq)
+				return false
+			}))
+			b := document.Button()
+			b.SetText("B")
+			b.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+				mark(1, seq)
+				return false
+			}))
+
+			for i, m := range marks {
+				if m == seq {
+					doc.AddClass(row.AsElement(), "marked-"+[]string{"a", "b"}[i])
+				}
+			}
+			row.AsElement().SetChildren(label.AsElement(), a.AsElement(), b.AsElement())
+			rows = append(rows, row.AsElement())
+		}
+		events.AsElement().SetChildren(rows...)
+		showPosition()
+		showDiff()
+	}
+
+	// reload reads the journal again, unless a past list or a reproduction is
+	// being looked at.
+	reload := func() {
+		if !open || !live || tlist.Previewing() {
+			return
+		}
+		l, err := journal.Log()
+		if err != nil {
+			position.SetText("Unable to read the event journal: " + err.Error())
+			return
+		}
+		log = l
+		cursor = log.Last()
+		render()
+	}
+
+	toggle.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		open = !open
+		if open {
+			doc.SetInlineCSS(panel.AsElement(), "display:block")
+			reload()
+		} else {
+			doc.SetInlineCSS(panel.AsElement(), "display:none")
+		}
+		return false
+	}))
+
+	slider.AsElement().AddEventListener("input", ui.NewEventHandler(func(evt ui.Event) bool {
+		if s, ok := doc.JSValue(slider.AsElement()); ok {
+			if seq, err := strconv.ParseInt(s.Get("value").String(), 10, 64); err == nil {
+				scrub(seq)
+			}
+		}
+		return false
+	}))
+
+	present.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		tlist.EndPreview()
+		live = true
+		reload()
+		return false
+	}))
+
+	restore.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		if !tlist.Previewing() {
+			return false
+		}
+		l := tlist.GetList()
+		tlist.EndPreview()
+		tlist.Checkpoint()
+		tlist.SetList(l)
+		live = true
+		reload()
+		return false
+	}))
+
+	golive.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		tlist.EndPreview()
+		live = true
+		marks = [2]int64{-1, -1}
+		reload()
+		return false
+	}))
+
+	export.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		b, err := json.MarshalIndent(log, "", "  ")
+		if err != nil {
+			position.SetText("Unable to export: " + err.Error())
+			return false
+		}
+		download("todomvc-repro.json", "application/json", string(b))
+		return false
+	}))
+
+	load.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		if f, ok := doc.JSValue(file.AsElement()); ok {
+			f.Set("value", "")
+			f.Call("click")
+		}
+		return false
+	}))
+
+	file.AsElement().AddEventListener("change", ui.NewEventHandler(func(evt ui.Event) bool {
+		f, ok := doc.JSValue(file.AsElement())
+		if !ok {
+			return false
+		}
+		go func() {
+			name, content, err := readFile(f)
+			if err == nil {
+				if _, err = parseRepro(content); err == nil {
+					root.SetUI("repro", ui.String(content))
+					return
+				}
+			}
+			root.SetUI("reproerror", ui.String(name+": "+err.Error()))
+		}()
+		return false
+	}))
+
+	// Reproductions are shown from the event loop, not from the goroutine
+	// reading the file.
+	root.Watch("ui", "repro", root, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		l, err := parseRepro(string(evt.NewValue().(ui.String)))
+		if err != nil {
+			return false
+		}
+		log, live = l, false
+		marks = [2]int64{-1, -1}
+		render()
+		scrub(log.Last())
+		return false
+	}))
+
+	root.Watch("ui", "reproerror", root, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		position.SetText("Unable to load " + string(evt.NewValue().(ui.String)))
+		return false
+	}))
+
+	root.Watch("ui", "todoslist", tlist, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		reload()
+		return false
+	}))
+
+	controls := document.Div.WithID(id + "-controls")
+	doc.AddClass(controls.AsElement(), "inspector-controls")
+	controls.AsElement().SetChildren(
+		source.AsElement(), golive.AsElement(), export.AsElement(), load.AsElement(), file.AsElement(),
+		slider.AsElement(), position.AsElement(), present.AsElement(), restore.AsElement(),
+	)
+	panel.AsElement().SetChildren(controls.AsElement(), events.AsElement(), diff.AsElement())
+	root.SetChildren(toggle.AsElement(), panel.AsElement())
+	return root
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	js "github.com/atdiar/particleui/drivers/js/compat"
+
+	"github.com/atdiar/todomvc2/src/eventlog"
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// EventJournal keeps the event log of the todo list (see package eventlog) in
+// step with the list. The domain events dispatched by the list are appended
+// to the log, and the list is set to the state of the log they lead to. Every
+// other change, such as a bulk action, an import or a change of another
+// writer, is appended as an ops event, so that replaying the log gives back
+// the list at any retained point. The store remains the source of the list on
+// start, which the log is brought in step with as a change of another writer.
+//
+// The log is kept along with the list: in IndexedDB for the indexeddb backend
+// (see idbJournal), and in localStorage otherwise, shared by the tabs of the
+// origin, unless its key is empty. So that appending an event does not rewrite
+// the whole log, it is kept in localStorage in segments, one per snapshot:
+// under key, the sequence numbers of the snapshots; under key/s<seq>, the
+// snapshot seq, written once; and under key/e<seq>, the events that follow it
+// up to the next snapshot. Appending an event only rewrites the events of the
+// last segment. Writes that fail, when the storage is full, are reported as
+// errors.
+type EventJournal struct {
+	key string
+
+	mu  *sync.Mutex
+	log *eventlog.Log
+
+	// stored holds the values of the keys of the log as last read or
+	// written, so that only the ones that changed are written.
+	stored map[string]string
+
+	// idb, when set, keeps the log in IndexedDB instead.
+	idb *idbJournal
+}
+
+// journal is set by App, once the store is open.
+var journal = NewEventJournal("")
+
+// eventsKey prefixes the localStorage key of the event log, followed by the
+// endpoint for the "http" backend.
+const eventsKey = "todomvc2/events"
+
+func NewEventJournal(key string) EventJournal {
+	return EventJournal{key: key, mu: new(sync.Mutex), log: eventlog.New(nil), stored: make(map[string]string)}
+}
+
+// journalFor returns the event journal going with a store. It blocks, for
+// the indexeddb backend, and must be called from main.
+func journalFor(store TodoStore) EventJournal {
+	switch s := store.(type) {
+	case *SyncStore:
+		return NewEventJournal(eventsKey + ":" + s.remote.endpoint)
+	case *MemoryStore:
+		return NewEventJournal("")
+	case *IndexedDBStore:
+		j, err := openIDBJournal(s.db)
+		if err != nil {
+			log.Print("unable to open the event journal: ", err)
+			return NewEventJournal("")
+		}
+		return j
+	}
+	return NewEventJournal(eventsKey)
+}
+
+// idbJournalKey is the key of the event log in the "journal" object store.
+const idbJournalKey = "log"
+
+// idbJournal keeps an event log in the "journal" object store of the database
+// of an IndexedDBStore, whole, under idbJournalKey. Since events are recorded
+// from event handlers, which cannot wait for IndexedDB, the log is read once,
+// when the app starts, and written in the background, the latest version
+// only. Each tab writes its own log, in which the changes of the other tabs
+// are recorded as remote changes: the log of the last tab to write is kept.
+type idbJournal struct {
+	db      js.Value
+	pending chan []byte
+}
+
+// openIDBJournal returns the event journal kept in the "journal" object store
+// of db. The log of earlier versions, kept in localStorage, is moved over when
+// the object store has none.
+func openIDBJournal(db js.Value) (EventJournal, error) {
+	j := NewEventJournal("")
+	tx := db.Call("transaction", "journal", "readonly")
+	v, err := idbRequest(tx.Call("objectStore", "journal").Call("get", idbJournalKey))
+	if err != nil {
+		return j, err
+	}
+	var legacy EventJournal
+	if v.IsUndefined() {
+		legacy = NewEventJournal(eventsKey)
+		legacy.mu.Lock()
+		err := legacy.pull()
+		legacy.mu.Unlock()
+		if err != nil {
+			return j, err
+		}
+		j.log = legacy.log
+	} else if err := json.Unmarshal([]byte(v.String()), j.log); err != nil {
+		return j, err
+	}
+
+	j.idb = &idbJournal{db: db, pending: make(chan []byte, 1)}
+	go j.idb.run()
+	if legacy.key != "" && len(legacy.stored) > 0 {
+		if err := j.push(); err != nil {
+			return j, err
+		}
+		storage := js.Global().Get("localStorage")
+		for key := range legacy.stored {
+			storage.Call("removeItem", key)
+		}
+	}
+	return j, nil
+}
+
+// save queues the writing of the JSON encoding of a log, replacing the one
+// pending, if any.
+func (d *idbJournal) save(b []byte) {
+	select {
+	case <-d.pending:
+	default:
+	}
+	d.pending <- b
+}
+
+func (d *idbJournal) run() {
+	for b := range d.pending {
+		tx := d.db.Call("transaction", "journal", "readwrite")
+		tx.Call("objectStore", "journal").Call("put", string(b), idbJournalKey)
+		if err := idbTransaction(tx); err != nil {
+			log.Print("unable to save the event journal: ", err)
+		}
+	}
+}
+
+// journalIndex is the value stored under the key of the log.
+type journalIndex struct {
+	Segments []int64 `json:"segments"`
+
+	// Snapshots and Events hold the whole log in the format of earlier
+	// versions, which is converted on the next write.
+	Snapshots []eventlog.Snapshot `json:"snapshots,omitempty"`
+	Events    []eventlog.Event    `json:"events,omitempty"`
+}
+
+func (j EventJournal) snapshotKey(seq int64) string {
+	return j.key + "/s" + strconv.FormatInt(seq, 10)
+}
+
+func (j EventJournal) segmentKey(seq int64) string {
+	return j.key + "/e" + strconv.FormatInt(seq, 10)
+}
+
+// read reads the value of key into v, if any, and reports whether there was
+// one. It is called with mu held.
+func (j EventJournal) read(key string, v any) (bool, error) {
+	item := js.Global().Get("localStorage").Call("getItem", key)
+	if item.IsNull() {
+		delete(j.stored, key)
+		return false, nil
+	}
+	raw := item.String()
+	j.stored[key] = raw
+	return true, json.Unmarshal([]byte(raw), v)
+}
+
+// pull reads the stored log, which other tabs may have appended to. It is
+// called with mu held.
+func (j EventJournal) pull() error {
+	if j.key == "" || j.idb != nil {
+		return nil
+	}
+	var index journalIndex
+	if ok, err := j.read(j.key, &index); !ok || err != nil {
+		return err
+	}
+	if len(index.Snapshots) > 0 {
+		*j.log = eventlog.Log{Snapshots: index.Snapshots, Events: index.Events}
+		return nil
+	}
+	var l eventlog.Log
+	for _, seq := range index.Segments {
+		var s eventlog.Snapshot
+		ok, err := j.read(j.snapshotKey(seq), &s)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("event log: snapshot " + strconv.FormatInt(seq, 10) + " is missing")
+		}
+		var events []eventlog.Event
+		if _, err := j.read(j.segmentKey(seq), &events); err != nil {
+			return err
+		}
+		l.Snapshots = append(l.Snapshots, s)
+		l.Events = append(l.Events, events...)
+	}
+	if len(l.Snapshots) > 0 {
+		*j.log = l
+	}
+	return nil
+}
+
+// push writes the segments of the log that changed, then its index, and
+// removes the segments compacted away. It is called with mu held.
+func (j EventJournal) push() error {
+	if j.idb != nil {
+		b, err := json.Marshal(j.log)
+		if err != nil {
+			return err
+		}
+		j.idb.save(b)
+		return nil
+	}
+	if j.key == "" {
+		return nil
+	}
+	storage := js.Global().Get("localStorage")
+	keys := make(map[string]bool)
+	write := func(key string, v any) error {
+		keys[key] = true
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if j.stored[key] != string(b) {
+			if err := setLocalItem(key, string(b)); err != nil {
+				return err
+			}
+			j.stored[key] = string(b)
+		}
+		return nil
+	}
+
+	var index journalIndex
+	snapshots := j.log.Snapshots
+	for i, s := range snapshots {
+		index.Segments = append(index.Segments, s.Seq)
+		// Snapshots never change once written.
+		if key := j.snapshotKey(s.Seq); j.stored[key] != "" {
+			keys[key] = true
+		} else if err := write(key, s); err != nil {
+			return err
+		}
+		events := []eventlog.Event{}
+		for _, e := range j.log.Events {
+			if e.Seq > s.Seq && (i+1 == len(snapshots) || e.Seq <= snapshots[i+1].Seq) {
+				events = append(events, e)
+			}
+		}
+		if err := write(j.segmentKey(s.Seq), events); err != nil {
+			return err
+		}
+	}
+	if err := write(j.key, index); err != nil {
+		return err
+	}
+	for key := range j.stored {
+		if !keys[key] {
+			storage.Call("removeItem", key)
+			delete(j.stored, key)
+		}
+	}
+	return nil
+}
+
+// update applies f to the stored log and saves the result, if f reports that
+// it changed the log.
+func (j EventJournal) update(f func(l *eventlog.Log) bool) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.pull(); err != nil {
+		return err
+	}
+	if !f(j.log) {
+		return nil
+	}
+	return j.push()
+}
+
+// Append records domain events and returns the list they lead to, changes
+// recorded by other tabs included.
+func (j EventJournal) Append(events ...eventlog.Event) ([]model.Todo, error) {
+	var todos []model.Todo
+	err := j.update(func(l *eventlog.Log) bool {
+		for _, e := range events {
+			l.Append(e)
+		}
+		todos = l.State()
+		return true
+	})
+	return todos, err
+}
+
+// Sync records the changes that turn the state of the log into todos, if any,
+// as caused by cause.
+func (j EventJournal) Sync(todos []model.Todo, cause string, now time.Time) error {
+	return j.update(func(l *eventlog.Log) bool {
+		_, changed := l.Sync(todos, cause, now)
+		return changed
+	})
+}
+
+// Log returns a copy of the log.
+func (j EventJournal) Log() (eventlog.Log, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.pull(); err != nil {
+		return eventlog.Log{}, err
+	}
+	l := *j.log
+	l.Snapshots = append([]eventlog.Snapshot(nil), l.Snapshots...)
+	l.Events = append([]eventlog.Event(nil), l.Events...)
+	return l, nil
+}
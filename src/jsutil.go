@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	js "github.com/atdiar/particleui/drivers/js/compat"
+)
+
+// setLocalItem sets a localStorage item. The exception thrown by setItem when
+// the storage is full, which syscall/js raises as a panic, is returned as an
+// error.
+func setLocalItem(key string, value string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("localStorage: %v", r)
+		}
+	}()
+	js.Global().Get("localStorage").Call("setItem", key, value)
+	return nil
+}
+
+// await blocks until a JavaScript promise settles and returns its value.
+// It must not be called from within a JavaScript callback, which would block
+// the event loop: callers run it in a goroutine, or from main.
+func await(promise js.Value) (js.Value, error) {
+	type result struct {
+		v   js.Value
+		err error
+	}
+	done := make(chan result, 1)
+
+	onresolve := js.FuncOf(func(this js.Value, args []js.Value) any {
+		done <- result{v: args[0]}
+		return nil
+	})
+	onreject := js.FuncOf(func(this js.Value, args []js.Value) any {
+		msg := "promise rejected"
+		if len(args) > 0 && !args[0].IsUndefined() && !args[0].IsNull() {
+			msg = args[0].Call("toString").String()
+		}
+		done <- result{err: errors.New(msg)}
+		return nil
+	})
+	defer onresolve.Release()
+	defer onreject.Release()
+
+	promise.Call("then", onresolve, onreject)
+	r := <-done
+	return r.v, r.err
+}
+
+// httpResponse is the part of a fetch response that the app uses.
+type httpResponse struct {
+	Status int
+	Body   string
+	header js.Value
+}
+
+func (r httpResponse) Header(name string) string {
+	v := r.header.Call("get", name)
+	if v.IsNull() {
+		return ""
+	}
+	return v.String()
+}
+
+// fetch performs an HTTP request with the Fetch API. Requests other than GET
+// carry the CSRF token of the session, if signed in, and every request the
+// token of the share link the list was opened with, if any. Like await, it must
+// not be called from within a JavaScript callback.
+func fetch(method string, url string, body string, headers map[string]string) (httpResponse, error) {
+	opts := js.Global().Get("Object").New()
+	opts.Set("method", method)
+	opts.Set("credentials", "same-origin")
+	h := js.Global().Get("Object").New()
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	if method != "GET" && method != "HEAD" && session.CSRF != "" {
+		h.Set("X-CSRF-Token", session.CSRF)
+	}
+	if session.Share != "" {
+		h.Set("X-Share-Token", session.Share)
+	}
+	opts.Set("headers", h)
+	if body != "" {
+		opts.Set("body", body)
+	}
+
+	resp, err := await(js.Global().Call("fetch", url, opts))
+	if err != nil {
+		return httpResponse{}, err
+	}
+	text, err := await(resp.Call("text"))
+	if err != nil {
+		return httpResponse{}, err
+	}
+	return httpResponse{
+		Status: resp.Get("status").Int(),
+		Body:   text.String(),
+		header: resp.Get("headers"),
+	}, nil
+}
+
+// queryParam returns the value of a query parameter of the current URL.
+func queryParam(name string) string {
+	search := js.Global().Get("location").Get("search").String()
+	params := js.Global().Get("URLSearchParams").New(strings.TrimPrefix(search, "?"))
+	v := params.Call("get", name)
+	if v.IsNull() {
+		return ""
+	}
+	return v.String()
+}
+
+// download makes the browser save content as a file.
+func download(filename string, mimetype string, content string) {
+	parts := js.Global().Get("Array").New(content)
+	opts := js.Global().Get("Object").New()
+	opts.Set("type", mimetype)
+	blob := js.Global().Get("Blob").New(parts, opts)
+
+	url := js.Global().Get("URL").Call("createObjectURL", blob)
+	a := js.Global().Get("document").Call("createElement", "a")
+	a.Set("href", url)
+	a.Set("download", filename)
+	a.Call("click")
+	js.Global().Get("URL").Call("revokeObjectURL", url)
+}
+
+// readFile returns the name and text content of the first file selected in a
+// file input. Like await, it must not be called from within a JavaScript
+// callback.
+func readFile(input js.Value) (name string, content string, err error) {
+	files := input.Get("files")
+	if files.Get("length").Int() == 0 {
+		return "", "", errors.New("no file selected")
+	}
+	file := files.Index(0)
+	text, err := await(file.Call("text"))
+	if err != nil {
+		return "", "", err
+	}
+	return file.Get("name").String(), text.String(), nil
+}
+
+// copyText writes text to the clipboard. Like await, it must not be called from
+// within a JavaScript callback.
+func copyText(text string) error {
+	clipboard := js.Global().Get("navigator").Get("clipboard")
+	if clipboard.IsUndefined() {
+		return errors.New("clipboard unavailable")
+	}
+	_, err := await(clipboard.Call("writeText", text))
+	return err
+}
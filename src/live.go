@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	js "github.com/atdiar/particleui/drivers/js/compat"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// Viewer is a client looking at the same list.
+type Viewer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// liveEvent is a message of the change feed of a list: a "snapshot" of the
+// todos, the ops of a "change", or the "presence" of viewers.
+type liveEvent struct {
+	Type     string       `json:"type"`
+	Revision int64        `json:"revision"`
+	Todos    []model.Todo `json:"todos"`
+	Ops      []model.Op   `json:"ops"`
+	Viewers  []Viewer     `json:"viewers"`
+	You      string       `json:"you"`
+	Error    string       `json:"error"`
+}
+
+// liveTransport connects to the change feed of a list.
+type liveTransport interface {
+	// Open asks for the changes made to the list at endpoint after revision
+	// since, or for a snapshot if since is 0. Events are delivered in order on
+	// the returned channel, which is closed when the connection is lost.
+	// Like await, Open must not be called from within a JavaScript callback.
+	Open(endpoint string, since int64) (events <-chan liveEvent, close func(), err error)
+}
+
+// eventQueue hands the events received in JavaScript callbacks, which must
+// not block, over to a channel, in order.
+type eventQueue struct {
+	mu     sync.Mutex
+	queue  []liveEvent
+	closed bool
+	notify chan struct{}
+	out    chan liveEvent
+}
+
+func newEventQueue() *eventQueue {
+	q := &eventQueue{notify: make(chan struct{}, 1), out: make(chan liveEvent)}
+	go q.pump()
+	return q
+}
+
+func (q *eventQueue) push(e liveEvent) {
+	q.mu.Lock()
+	q.queue = append(q.queue, e)
+	q.mu.Unlock()
+	q.wake()
+}
+
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.wake()
+}
+
+func (q *eventQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *eventQueue) pump() {
+	for range q.notify {
+		q.mu.Lock()
+		events, closed := q.queue, q.closed
+		q.queue = nil
+		q.mu.Unlock()
+		for _, e := range events {
+			q.out <- e
+		}
+		if closed {
+			close(q.out)
+			return
+		}
+	}
+}
+
+// liveURL returns the URL of the feed of the list at endpoint, with the
+// given scheme in place of http or https. The token of the share link the list
+// was opened with is passed along, since WebSocket and EventSource requests
+// cannot carry headers.
+func liveURL(endpoint string, path string, scheme string, query url.Values) (string, error) {
+	if session.Share != "" {
+		query.Set("share", session.Share)
+	}
+	base, err := url.Parse(js.Global().Get("location").Get("href").String())
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(strings.TrimSuffix(endpoint, "/") + path)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "https" {
+		scheme += "s"
+	}
+	u.Scheme = scheme
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// wsTransport follows a list over the WebSocket served at endpoint/live.
+type wsTransport struct{}
+
+func (wsTransport) Open(endpoint string, since int64) (<-chan liveEvent, func(), error) {
+	if js.Global().Get("WebSocket").IsUndefined() {
+		return nil, nil, errors.New("WebSocket unavailable")
+	}
+	q := url.Values{}
+	if since > 0 {
+		q.Set("since", strconv.FormatInt(since, 10))
+	}
+	u, err := liveURL(endpoint, "/live", "ws", q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ws := js.Global().Get("WebSocket").New(u)
+	queue := newEventQueue()
+	opened := make(chan error, 1)
+
+	var handlers []js.Func
+	on := func(name string, f func(evt js.Value)) {
+		h := js.FuncOf(func(this js.Value, args []js.Value) any {
+			f(args[0])
+			return nil
+		})
+		handlers = append(handlers, h)
+		ws.Call("addEventListener", name, h)
+	}
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			queue.close()
+			for _, h := range handlers {
+				h.Release()
+			}
+		})
+	}
+
+	on("open", func(js.Value) {
+		opened <- nil
+	})
+	on("message", func(evt js.Value) {
+		var e liveEvent
+		if err := json.Unmarshal([]byte(evt.Get("data").String()), &e); err == nil {
+			queue.push(e)
+		}
+	})
+	on("error", func(js.Value) {
+		select {
+		case opened <- errors.New("WebSocket connection failed"):
+		default:
+		}
+	})
+	on("close", func(js.Value) {
+		select {
+		case opened <- errors.New("WebSocket closed"):
+		default:
+		}
+		// Callbacks are released once the queue is drained, outside of this
+		// callback.
+		go release()
+	})
+
+	if err := <-opened; err != nil {
+		ws.Call("close")
+		return nil, nil, err
+	}
+	return queue.out, func() { ws.Call("close") }, nil
+}
+
+// sseData is the data of the events of endpoint/events.
+type sseData struct {
+	Revision int64        `json:"revision"`
+	ID       string       `json:"id"`
+	Todo     *model.Todo  `json:"todo"`
+	Fields   []string     `json:"fields"`
+	After    *string      `json:"after"`
+	Todos    []model.Todo `json:"todos"`
+}
+
+// sseOps are the op kinds of the events of endpoint/events.
+var sseOps = map[string]string{
+	"created": model.OpCreate,
+	"updated": model.OpUpdate,
+	"deleted": model.OpDelete,
+	"moved":   model.OpReorder,
+}
+
+// sseTransport follows a list through the Server-Sent Events served at
+// endpoint/events, for browsers or networks where WebSockets are not
+// available. It receives changes only: viewers are not reported.
+type sseTransport struct{}
+
+func (sseTransport) Open(endpoint string, since int64) (<-chan liveEvent, func(), error) {
+	if js.Global().Get("EventSource").IsUndefined() {
+		return nil, nil, errors.New("EventSource unavailable")
+	}
+	q := url.Values{}
+	if since > 0 {
+		q.Set("since", strconv.FormatInt(since, 10))
+	}
+	u, err := liveURL(endpoint, "/events", "http", q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	source := js.Global().Get("EventSource").New(u)
+	queue := newEventQueue()
+	opened := make(chan error, 1)
+
+	var handlers []js.Func
+	on := func(name string, f func(evt js.Value)) {
+		h := js.FuncOf(func(this js.Value, args []js.Value) any {
+			f(args[0])
+			return nil
+		})
+		handlers = append(handlers, h)
+		source.Call("addEventListener", name, h)
+	}
+	var once sync.Once
+	closefeed := func() {
+		once.Do(func() {
+			// EventSource reconnects on its own: it is closed instead so that
+			// the caller reconnects from the revision it has applied.
+			source.Call("close")
+			queue.close()
+			go func() {
+				for _, h := range handlers {
+					h.Release()
+				}
+			}()
+		})
+	}
+
+	// The events of a revision are grouped into one change, complete once
+	// the event carrying the revision as id is received.
+	var ops []model.Op
+	data := func(evt js.Value) (sseData, bool) {
+		var d sseData
+		err := json.Unmarshal([]byte(evt.Get("data").String()), &d)
+		return d, err == nil
+	}
+	last := func(evt js.Value, d sseData) bool {
+		return evt.Get("lastEventId").String() == strconv.FormatInt(d.Revision, 10)
+	}
+
+	on("open", func(js.Value) {
+		select {
+		case opened <- nil:
+		default:
+		}
+	})
+	on("error", func(js.Value) {
+		select {
+		case opened <- errors.New("EventSource connection failed"):
+		default:
+		}
+		closefeed()
+	})
+	on("snapshot", func(evt js.Value) {
+		if d, ok := data(evt); ok {
+			queue.push(liveEvent{Type: "snapshot", Revision: d.Revision, Todos: d.Todos})
+		}
+	})
+	on("revision", func(evt js.Value) {
+		if d, ok := data(evt); ok {
+			queue.push(liveEvent{Type: "change", Revision: d.Revision})
+		}
+	})
+	for name, kind := range sseOps {
+		kind := kind
+		on(name, func(evt js.Value) {
+			d, ok := data(evt)
+			if !ok {
+				return
+			}
+			op := model.Op{Kind: kind, ID: d.ID, Todo: d.Todo, Fields: d.Fields}
+			if d.After != nil {
+				op.After = *d.After
+			}
+			ops = append(ops, op)
+			if last(evt, d) {
+				queue.push(liveEvent{Type: "change", Revision: d.Revision, Ops: ops})
+				ops = nil
+			}
+		})
+	}
+
+	if err := <-opened; err != nil {
+		return nil, nil, err
+	}
+	return queue.out, closefeed, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+
+	ui "github.com/atdiar/particleui"
+	doc "github.com/atdiar/particleui/drivers/js"
+	js "github.com/atdiar/particleui/drivers/js/compat"
+)
+
+// LoginForm signs the user in to the server of the "http" backend, or creates
+// an account, then reloads the app.
+type LoginForm struct {
+	*ui.Element
+}
+
+func LoginFormFromRef(ref *ui.Element) LoginForm {
+	return LoginForm{ref}
+}
+
+// NewLoginForm returns a form signing in to the server API at api. message,
+// if any, tells why signing in is needed.
+func NewLoginForm(d *doc.Document, id string, api string, message string, options ...string) LoginForm {
+	return LoginForm{newloginform(d, id, api, message, options...)}
+}
+
+func reloadPage() {
+	js.Global().Get("location").Call("reload")
+}
+
+func newloginform(document *doc.Document, id string, api string, message string, options ...string) *ui.Element {
+	form := document.Div.WithID(id, options...).AsElement()
+	doc.AddClass(form, "login")
+
+	msg := document.Div.WithID(id + "-msg")
+	doc.AddClass(msg.AsElement(), "login-message")
+	msg.SetText(message)
+
+	name := document.Input.WithID(id+"-name", "text")
+	doc.SetAttribute(name.AsElement(), "placeholder", "Name")
+	doc.SetAttribute(name.AsElement(), "autocomplete", "username")
+	doc.Autofocus(name.AsElement())
+
+	password := document.Input.WithID(id+"-password", "password")
+	doc.SetAttribute(password.AsElement(), "placeholder", "Password")
+	doc.SetAttribute(password.AsElement(), "autocomplete", "current-password")
+
+	value := func(input *ui.Element) string {
+		if v, ok := doc.JSValue(input); ok {
+			return v.Get("value").String()
+		}
+		return ""
+	}
+
+	submit := func(signup bool) {
+		n, p := strings.TrimSpace(value(name.AsElement())), value(password.AsElement())
+		if n == "" || p == "" {
+			form.SetUI("loginerror", ui.String("Enter a name and a password."))
+			return
+		}
+		go func() {
+			if err := signIn(api, n, p, signup); err != nil {
+				form.SetUI("loginerror", ui.String(err.Error()))
+				return
+			}
+			reloadPage()
+		}()
+	}
+
+	signin := document.Button.WithID(id+"-signin", "button")
+	signin.SetText("Sign in")
+	signin.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		submit(false)
+		return false
+	}))
+
+	signup := document.Button.WithID(id+"-signup", "button")
+	signup.SetText("Create account")
+	signup.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		submit(true)
+		return false
+	}))
+
+	password.AsElement().AddEventListener("keyup", ui.NewEventHandler(func(evt ui.Event) bool {
+		if key, ok := evt.Value().(ui.Object).Get("key"); ok && key.(ui.String) == "Enter" {
+			submit(false)
+		}
+		return false
+	}))
+
+	form.Watch("ui", "loginerror", form, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		msg.SetText(string(evt.NewValue().(ui.String)))
+		doc.AddClass(msg.AsElement(), "login-error")
+		return false
+	}))
+
+	form.SetChildren(msg.AsElement(), name.AsElement(), password.AsElement(), signin.AsElement(), signup.AsElement())
+	return form
+}
+
+// AccountBar shows who is signed in, with their role on the list, and lets
+// them sign out, or that the list was opened through a share link. It stays
+// hidden for stores that need no account.
+type AccountBar struct {
+	*ui.Element
+}
+
+func AccountBarFromRef(ref *ui.Element) AccountBar {
+	return AccountBar{ref}
+}
+
+func NewAccountBar(d *doc.Document, id string, s Session, options ...string) AccountBar {
+	return AccountBar{newaccountbar(d, id, s, options...)}
+}
+
+func newaccountbar(document *doc.Document, id string, s Session, options ...string) *ui.Element {
+	bar := document.Div.WithID(id, options...).AsElement()
+	doc.AddClass(bar, "account")
+	if s.Share != "" {
+		who := document.Span.WithID(id + "-user")
+		who.SetText("Viewing a read-only link")
+		bar.SetChildren(who.AsElement())
+		return bar
+	}
+	if s.User == "" {
+		doc.SetInlineCSS(bar, "display:none")
+		return bar
+	}
+
+	text := "Signed in as " + s.User
+	if s.Role != "" {
+		text += " (" + s.Role + ")"
+	}
+	who := document.Span.WithID(id + "-user")
+	who.SetText(text)
+
+	signout := document.Button.WithID(id+"-signout", "button")
+	signout.SetText("Sign out")
+	signout.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		go func() {
+			if err := signOut(s.API); err != nil {
+				who.SetText("Unable to sign out: " + err.Error())
+				return
+			}
+			reloadPage()
+		}()
+		return false
+	}))
+
+	bar.SetChildren(who.AsElement(), signout.AsElement())
+	return bar
+}
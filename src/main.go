@@ -1,8 +1,13 @@
 package main
 
 import (
+	"errors"
+	"log"
+
 	ui "github.com/atdiar/particleui"
 	. "github.com/atdiar/particleui/drivers/js"
+
+	"github.com/atdiar/todomvc2/src/eventlog"
 )
 
 func App() *Document {
@@ -14,8 +19,14 @@ func App() *Document {
 	var ToggleAllInput *ui.Element
 	var TodosList *ui.Element
 	var TodoCount *ui.Element
+	var SyncStatusIndicator *ui.Element
+	var Presence *ui.Element
 	var FilterList *ui.Element
 	var ClearCompleteButton *ui.Element
+	var BulkBar *ui.Element
+	var TransferBar *ui.Element
+	var ActivityFeed *ui.Element
+	var Update *ui.Element
 
 	toggleallhandler := ui.NewEventHandler(func(evt ui.Event) bool {
 		var ischecked bool
@@ -45,6 +56,41 @@ func App() *Document {
 		return false
 	})
 
+	undohandler := ui.NewEventHandler(func(evt ui.Event) bool {
+		e, ok := evt.(KeyboardEvent)
+		if !ok {
+			return false
+		}
+		// Inputs keep their native undo behaviour.
+		if target, ok := JSValue(evt.Target()); ok && target.Get("tagName").String() == "INPUT" {
+			return false
+		}
+		tlist := TodoListFromRef(TodosList)
+		switch {
+		case e.Key() == "Escape":
+			tlist.ClearSelection()
+		case e.Key() == "z" && (e.CtrlKey() || e.MetaKey()):
+			evt.PreventDefault()
+			tlist.Undo()
+		}
+		return false
+	})
+
+	store, err := OpenStore()
+	switch {
+	case errors.Is(err, errSignedOut):
+		return LoginApp(session.API, "Sign in to open the list.")
+	case errors.Is(err, errForbidden):
+		return LoginApp(session.API, session.User+" has no access to this list. Sign in with another account.")
+	case errors.Is(err, errShareRevoked):
+		return LoginApp(session.API, "This link has expired or was revoked. Sign in to open the list.")
+	case err != nil:
+		log.Print(err, ", falling back to localStorage")
+		store = NewLocalStore(localStoreKey)
+	}
+	activity = activityLogFor(store)
+	journal = journalFor(store)
+
 	document := NewDocument("Todo-App", EnableScrollRestoration())
 
 	document.Head().AppendChild(
@@ -60,6 +106,7 @@ func App() *Document {
 			E(document.Section.WithID("todoapp"),
 				Ref(&AppSection),
 				Class("todoapp"),
+				Listen("keydown", undohandler),
 				Children(
 					E(document.Header.WithID("header"),
 						Class("header"),
@@ -69,8 +116,11 @@ func App() *Document {
 								Ref(&todosinput),
 								Class("new-todo"),
 							),
+							E(NewQuickAddPreview(document, "new-todo-preview", InputElement{todosinput})),
+							E(NewPastePrompt(document, "new-todo-paste", InputElement{todosinput})),
 						),
 					),
+					E(NewBulkActionBar(document, "bulk-actions"), Ref(&BulkBar)),
 					E(document.Section.WithID("main"),
 						Ref(&MainSection),
 						Class("main"),
@@ -80,11 +130,12 @@ func App() *Document {
 								Class("toggle-all"),
 								Listen("click", toggleallhandler),
 							),
-							E(document.Label().For(&ToggleAllInput)),
-							E(NewTodoList(document, "todo-list", EnableLocalPersistence()),
+							E(document.Label.WithID("toggle-all-label").For(&ToggleAllInput)),
+							E(NewTodoList(document, "todo-list", store),
 								Ref(&TodosList),
 								InitRouter(Hijack("/", "/all"), ui.TrailingSlashMatters),
 							),
+							E(NewActivityList(document, "activity-feed"), Ref(&ActivityFeed)),
 						),
 					),
 					E(document.Footer.WithID("footer"),
@@ -92,6 +143,7 @@ func App() *Document {
 						Class("footer"),
 						Children(
 							E(NewTodoCount(document, "todo-count"), Ref(&TodoCount)),
+							E(NewSyncIndicator(document, "sync-status"), Ref(&SyncStatusIndicator)),
 							E(NewFilterList(document, "filters"), Ref(&FilterList)),
 							E(ClearCompleteBtn(document, "clear-complete"),
 								Ref(&ClearCompleteButton),
@@ -101,9 +153,14 @@ func App() *Document {
 					),
 				),
 			),
+			E(NewPresenceBar(document, "presence"), Ref(&Presence)),
+			E(NewTransferBar(document, "transfer", TodoListFromRef(TodosList)), Ref(&TransferBar)),
+			E(NewShareBar(document, "share", session)),
+			E(NewUpdatePrompt(document, "update-prompt"), Ref(&Update)),
 			E(document.Footer(),
 				Class("info"),
 				Children(
+					E(NewAccountBar(document, "account", session)),
 					E(document.Paragraph().SetText("Double-click to edit a todo")),
 					E(document.Paragraph().SetText("Created with: "),
 						Children(
@@ -117,54 +174,116 @@ func App() *Document {
 
 	// COMPONENTS DATA RELATIONSHIPS
 
+	// Viewers of a shared list can look at it but not change it.
+	if session.ReadOnly() {
+		AddClass(document.Body().AsElement(), "readonly")
+		TodoListFromRef(TodosList).SetReadOnly(true)
+	}
+
+	// Development builds come with the time-travel inspector.
+	if HMRMode != "false" {
+		document.Body().AsElement().AppendChild(NewInspector(document, "inspector", TodoListFromRef(TodosList)).AsElement())
+	}
+
+	enableOffline(UpdatePromptFromRef(Update))
+
+	// The sync status is only shown for stores that synchronise with a server.
+	if s, ok := store.(*SyncStore); ok {
+		s.OnStatus(func(st SyncStatus) {
+			SyncIndicatorFromRef(SyncStatusIndicator).SetStatus(st)
+		})
+		s.OnViewers(func(viewers []Viewer, you string) {
+			PresenceBarFromRef(Presence).SetViewers(viewers, you)
+		})
+	}
+
 	// 4. Watch for new todos to insert
 	AppSection.WatchEvent("newtodo", todosinput.AsElement(), ui.OnMutation(func(evt ui.MutationEvent) bool {
-		tlist := TodoListFromRef(TodosList)
-		tdl := tlist.GetList()
-
-		s, ok := evt.NewValue().(ui.String)
-		if !ok || s == "" {
+		fields, ok := evt.NewValue().(ui.Object)
+		if !ok || fields.MustGetString("title") == "" {
 			panic("BAD TODO")
 		}
-		t := NewTodo(s)
-		tdl = tdl.MakeCopy().Append(t).Commit()
-		tlist.SetList(tdl)
+		t := todoToModel(NewTodoFrom(fields))
+		TodoListFromRef(TodosList).Dispatch(eventlog.Event{Type: eventlog.NewTodo, Todo: &t})
 
 		return false
 	}))
 
-	AppSection.WatchEvent("clear", ClearCompleteButton.AsElement(), ui.OnMutation(func(evt ui.MutationEvent) bool {
-		tlist := TodoListFromRef(TodosList)
-		tdl := tlist.GetList()
-		ntdl := ui.NewList()
-		for _, todo := range tdl.UnsafelyUnwrap() {
-			t := todo.(Todo)
-			c, _ := t.Get("completed")
-			cpl := c.(ui.Bool)
-			if !cpl {
-				ntdl = ntdl.Append(todo)
-			}
+	AppSection.WatchEvent("newtodos", todosinput.AsElement(), ui.OnMutation(func(evt ui.MutationEvent) bool {
+		var events []eventlog.Event
+		for _, fields := range evt.NewValue().(ui.List).UnsafelyUnwrap() {
+			t := todoToModel(NewTodoFrom(fields.(ui.Object)))
+			events = append(events, eventlog.Event{Type: eventlog.NewTodo, Todo: &t})
 		}
+		TodoListFromRef(TodosList).Dispatch(events...)
+		return false
+	}))
 
-		tlist.SetList(ntdl.Commit())
+	AppSection.WatchEvent("clear", ClearCompleteButton.AsElement(), ui.OnMutation(func(evt ui.MutationEvent) bool {
+		TodoListFromRef(TodosList).Dispatch(eventlog.Event{Type: eventlog.Clear})
 		return false
 	}))
 
 	AppSection.WatchEvent("toggleall", ToggleAllInput, ui.OnMutation(func(evt ui.MutationEvent) bool {
 		status := evt.NewValue().(ui.Bool)
 
-		tlist := TodoListFromRef(TodosList)
+		TodoListFromRef(TodosList).Dispatch(eventlog.Event{Type: eventlog.ToggleAll, Completed: bool(status)})
 
-		tdl := tlist.GetList()
-		ntdl := tdl.MakeCopy()
+		return false
+	}))
 
-		for i, todo := range tdl.UnsafelyUnwrap() {
-			t := todo.(Todo)
-			t = t.MakeCopy().Set("completed", status).Commit()
-			ntdl.Set(i, t)
+	AppSection.WatchEvent("bulk", BulkBar, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		o := evt.NewValue().(ui.Object)
+		action := o.MustGetString("action")
+		value, _ := o.Get("value")
+		if err := TodoListFromRef(TodosList).ApplyBulk(string(action), value); err != nil {
+			log.Print("unable to apply the bulk action: ", err)
 		}
-		tlist.SetList(ntdl.Commit())
+		return false
+	}))
+
+	AppSection.WatchEvent("importtodos", TransferBar, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		if err := TodoListFromRef(TodosList).Import(evt.NewValue().(ui.List)); err != nil {
+			log.Print("unable to import the todos: ", err)
+		}
+		return false
+	}))
 
+	AppSection.WatchEvent("undo", BulkBar, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		TodoListFromRef(TodosList).Undo()
+		return false
+	}))
+
+	AppSection.Watch("ui", "selection", TodosList, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		n := len(TodoListFromRef(TodosList).Selection())
+		BulkActionBarFromRef(BulkBar).SetCount(n)
+		return false
+	}))
+
+	// The activity view replaces the todos with the activity feed of the list,
+	// reloaded on every change of the list while shown.
+	activityView := func() bool {
+		f, ok := TodosList.GetUI("filter")
+		return ok && f.(ui.String) == "activity"
+	}
+
+	SetInlineCSS(ActivityFeed, "display:none")
+	AppSection.Watch("ui", "filter", TodosList, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		if !activityView() {
+			RemoveClass(MainSection.AsElement(), "activity-view")
+			SetInlineCSS(ActivityFeed, "display:none")
+			return false
+		}
+		AddClass(MainSection.AsElement(), "activity-view")
+		SetInlineCSS(ActivityFeed, "display:block")
+		go ActivityListFromRef(ActivityFeed).Load("")
+		return false
+	}).RunASAP())
+
+	AppSection.Watch("ui", "todoslist", TodosList, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		if activityView() {
+			go ActivityListFromRef(ActivityFeed).Load("")
+		}
 		return false
 	}))
 
@@ -199,7 +318,7 @@ func App() *Document {
 		var itemsleft = len(l.UnsafelyUnwrap()) - countcomplete
 		tc.SetCount(itemsleft)
 
-		if countcomplete == 0 {
+		if countcomplete == 0 || tlist.ReadOnly() {
 			SetInlineCSS(ClearCompleteButton.AsElement(), "display:none")
 		} else {
 			SetInlineCSS(ClearCompleteButton.AsElement(), "display:block")
@@ -233,7 +352,7 @@ func App() *Document {
 	MainSection.WatchEvent("renderlist", TodosList, ui.OnMutation(func(evt ui.MutationEvent) bool {
 		tlist := TodoListFromRef(TodosList)
 		tdl := tlist.GetList()
-		if len(tdl.UnsafelyUnwrap()) == 0 {
+		if len(tdl.UnsafelyUnwrap()) == 0 && !activityView() {
 			SetInlineCSS(MainSection.AsElement(), "display : none")
 		} else {
 			SetInlineCSS(MainSection.AsElement(), "display : block")
@@ -241,10 +360,47 @@ func App() *Document {
 		return false
 	}).RunASAP())
 
+	removeRenderedPage()
 	return document
 
 }
 
+// LoginApp is shown instead of App when the server of the "http" backend
+// needs the user to sign in.
+func LoginApp(api string, message string) *Document {
+	var Update *ui.Element
+
+	document := NewDocument("Todo-App", EnableScrollRestoration())
+
+	document.Head().AppendChild(
+		E(document.Link.WithID("todocss").
+			SetRel("stylesheet").
+			SetHref("./assets/styles/todomvc.css"),
+		),
+	)
+
+	E(document.Body(),
+		Children(
+			E(document.Section.WithID("todoapp"),
+				Class("todoapp"),
+				Children(
+					E(document.Header.WithID("header"),
+						Class("header"),
+						Children(
+							E(document.H1.WithID("apptitle").SetText("Todo")),
+						),
+					),
+					E(NewLoginForm(document, "login", api, message)),
+				),
+			),
+			E(NewUpdatePrompt(document, "update-prompt"), Ref(&Update)),
+		),
+	)
+	enableOffline(UpdatePromptFromRef(Update))
+	removeRenderedPage()
+	return document
+}
+
 func main() {
 	ListenAndServe := NewBuilder(App)
 	ListenAndServe(nil)
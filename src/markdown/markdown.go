@@ -0,0 +1,361 @@
+// Package markdown renders the subset of Markdown used in todo notes to HTML.
+//
+// The output is safe to insert into a document: every piece of text coming
+// from the source is escaped, raw HTML is not supported, and links are only
+// kept when they point to an http, https or mailto URL or to a relative path.
+//
+// Supported syntax: paragraphs, ATX headings, fenced code blocks, block quotes,
+// ordered and unordered lists (nested by indentation), task list items, inline
+// code, emphasis, strong emphasis, links and bare http(s) URLs. As in
+// CommonMark, underscores only emphasise whole words, so that snake_case names
+// are left alone.
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Render returns the sanitised HTML rendering of src.
+func Render(src string) string {
+	var b strings.Builder
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case strings.HasPrefix(trimmed, "```"):
+			i = renderCode(&b, lines, i)
+
+		case heading(trimmed) > 0:
+			n := heading(trimmed)
+			tag := "h" + string(rune('0'+n))
+			b.WriteString("<" + tag + ">")
+			b.WriteString(inline(strings.TrimSpace(trimmed[n:])))
+			b.WriteString("</" + tag + ">")
+			i++
+
+		case strings.HasPrefix(trimmed, ">"):
+			var quote []string
+			for i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">") {
+				q := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quote = append(quote, strings.TrimPrefix(q, " "))
+				i++
+			}
+			b.WriteString("<blockquote>")
+			b.WriteString(Render(strings.Join(quote, "\n")))
+			b.WriteString("</blockquote>")
+
+		case isListItem(line):
+			i = renderList(&b, lines, i, indentation(line))
+
+		default:
+			var para []string
+			for i < len(lines) {
+				l := strings.TrimSpace(lines[i])
+				if l == "" || strings.HasPrefix(l, "```") || heading(l) > 0 || strings.HasPrefix(l, ">") || isListItem(lines[i]) {
+					break
+				}
+				para = append(para, l)
+				i++
+			}
+			b.WriteString("<p>")
+			b.WriteString(inline(strings.Join(para, "\n")))
+			b.WriteString("</p>")
+		}
+	}
+	return b.String()
+}
+
+// heading returns the level of an ATX heading, or 0.
+func heading(line string) int {
+	n := 0
+	for n < len(line) && line[n] == '#' {
+		n++
+	}
+	if n == 0 || n > 6 || n == len(line) || line[n] != ' ' {
+		return 0
+	}
+	return n
+}
+
+func renderCode(b *strings.Builder, lines []string, i int) int {
+	var code []string
+	i++
+	for i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```") {
+		code = append(code, lines[i])
+		i++
+	}
+	b.WriteString("<pre><code>")
+	b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+	b.WriteString("</code></pre>")
+	return i + 1
+}
+
+func indentation(line string) int {
+	n := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			n++
+		case '\t':
+			n += 4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// listMarker returns the marker of a list item and whether the list is
+// ordered. It returns an empty marker when the line is not a list item.
+func listMarker(line string) (marker string, ordered bool) {
+	l := strings.TrimSpace(line)
+	for _, m := range []string{"- ", "* ", "+ "} {
+		if strings.HasPrefix(l, m) {
+			return m, false
+		}
+	}
+	n := 0
+	for n < len(l) && l[n] >= '0' && l[n] <= '9' {
+		n++
+	}
+	if n > 0 && n+1 < len(l) && (l[n] == '.' || l[n] == ')') && l[n+1] == ' ' {
+		return l[:n+2], true
+	}
+	return "", false
+}
+
+func isListItem(line string) bool {
+	m, _ := listMarker(line)
+	return m != ""
+}
+
+// renderList renders the list starting at line i whose items are indented by
+// indent spaces, along with any nested lists. It returns the index of the first
+// line after the list.
+func renderList(b *strings.Builder, lines []string, i int, indent int) int {
+	_, ordered := listMarker(lines[i])
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	b.WriteString("<" + tag + ">")
+
+	open := false
+	for i < len(lines) {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			i++
+			continue
+		}
+		ind := indentation(line)
+		if ind < indent {
+			break
+		}
+		if ind > indent {
+			if !isListItem(line) {
+				// Continuation of the previous item.
+				b.WriteString(" ")
+				b.WriteString(inline(strings.TrimSpace(line)))
+				i++
+				continue
+			}
+			i = renderList(b, lines, i, ind)
+			continue
+		}
+		m, o := listMarker(line)
+		if m == "" || o != ordered {
+			break
+		}
+		if open {
+			b.WriteString("</li>")
+		}
+		item := strings.TrimSpace(line)[len(m):]
+		switch {
+		case strings.HasPrefix(item, "[ ] "):
+			b.WriteString(`<li class="task"><input type="checkbox" disabled> `)
+			item = item[4:]
+		case strings.HasPrefix(item, "[x] "), strings.HasPrefix(item, "[X] "):
+			b.WriteString(`<li class="task"><input type="checkbox" checked disabled> `)
+			item = item[4:]
+		default:
+			b.WriteString("<li>")
+		}
+		b.WriteString(inline(item))
+		open = true
+		i++
+	}
+	if open {
+		b.WriteString("</li>")
+	}
+	b.WriteString("</" + tag + ">")
+	return i
+}
+
+// safeURL reports whether a link destination may be rendered.
+func safeURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return true
+	case "":
+		// Relative references only, no protocol-relative URLs, which
+		// browsers also recognise with backslashes.
+		return len(s) < 2 || !strings.ContainsRune(`/\`, rune(s[0])) || !strings.ContainsRune(`/\`, rune(s[1]))
+	}
+	return false
+}
+
+func wordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// emphasisEnd returns the index in s of the delimiter d closing an emphasis,
+// or -1. Underscores must not be followed by a letter or digit.
+func emphasisEnd(s string, d string) int {
+	for i := 0; i < len(s); i++ {
+		j := strings.Index(s[i:], d)
+		if j < 0 {
+			return -1
+		}
+		i += j
+		if d[0] == '*' {
+			return i
+		}
+		if r, _ := utf8.DecodeRuneInString(s[i+len(d):]); !wordRune(r) {
+			return i
+		}
+	}
+	return -1
+}
+
+// inline renders the inline elements of a piece of text.
+func inline(src string) string {
+	var b strings.Builder
+	s := src
+	// opens reports whether the delimiter at the start of s may open an
+	// emphasis: underscores must not follow a letter or digit, nor the
+	// underscores of a delimiter that could not.
+	opens := func() bool {
+		r, _ := utf8.DecodeLastRuneInString(src[:len(src)-len(s)])
+		return s[0] == '*' || !wordRune(r) && r != '_'
+	}
+	for len(s) > 0 {
+		switch {
+		case s[0] == '\\' && len(s) > 1 && strings.ContainsRune("\\`*_[]()#!-+.>", rune(s[1])):
+			b.WriteString(html.EscapeString(s[1:2]))
+			s = s[2:]
+			continue
+
+		case s[0] == '`':
+			if end := strings.IndexByte(s[1:], '`'); end >= 0 {
+				b.WriteString("<code>")
+				b.WriteString(html.EscapeString(s[1 : end+1]))
+				b.WriteString("</code>")
+				s = s[end+2:]
+				continue
+			}
+
+		case strings.HasPrefix(s, "**") || strings.HasPrefix(s, "__"):
+			if end := emphasisEnd(s[2:], s[:2]); end > 0 && opens() {
+				b.WriteString("<strong>")
+				b.WriteString(inline(s[2 : end+2]))
+				b.WriteString("</strong>")
+				s = s[end+4:]
+				continue
+			}
+
+		case s[0] == '*' || s[0] == '_':
+			if end := emphasisEnd(s[1:], s[:1]); end > 0 && opens() {
+				b.WriteString("<em>")
+				b.WriteString(inline(s[1 : end+1]))
+				b.WriteString("</em>")
+				s = s[end+2:]
+				continue
+			}
+
+		case s[0] == '[':
+			if text, dest, n, ok := link(s); ok {
+				if safeURL(dest) {
+					b.WriteString(`<a href="`)
+					b.WriteString(html.EscapeString(dest))
+					b.WriteString(`" rel="noopener noreferrer nofollow" target="_blank">`)
+					b.WriteString(inline(text))
+					b.WriteString("</a>")
+				} else {
+					b.WriteString(inline(text))
+				}
+				s = s[n:]
+				continue
+			}
+
+		case strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://"):
+			end := strings.IndexAny(s, " \t\n<>\"")
+			if end < 0 {
+				end = len(s)
+			}
+			u := strings.TrimRight(s[:end], ".,;:!?)")
+			if safeURL(u) {
+				b.WriteString(`<a href="`)
+				b.WriteString(html.EscapeString(u))
+				b.WriteString(`" rel="noopener noreferrer nofollow" target="_blank">`)
+				b.WriteString(html.EscapeString(u))
+				b.WriteString("</a>")
+				s = s[len(u):]
+				continue
+			}
+
+		case s[0] == '\n':
+			b.WriteString("<br>")
+			s = s[1:]
+			continue
+		}
+
+		b.WriteString(html.EscapeString(s[:1]))
+		s = s[1:]
+	}
+	return b.String()
+}
+
+// link parses an inline link [text](destination) at the start of s.
+// It returns the number of bytes consumed.
+func link(s string) (text string, dest string, n int, ok bool) {
+	depth := 0
+	closing := -1
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+		if depth == 0 {
+			closing = i
+			break
+		}
+	}
+	if closing < 0 || closing+1 >= len(s) || s[closing+1] != '(' {
+		return "", "", 0, false
+	}
+	end := strings.IndexByte(s[closing+2:], ')')
+	if end < 0 {
+		return "", "", 0, false
+	}
+	dest = strings.TrimSpace(s[closing+2 : closing+2+end])
+	if strings.ContainsAny(dest, " \t\n") {
+		return "", "", 0, false
+	}
+	return s[1:closing], dest, closing + 3 + end, true
+}
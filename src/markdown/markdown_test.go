@@ -0,0 +1,99 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSafeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		safe bool
+	}{
+		{"http://example.com", true},
+		{"https://example.com/a?b=c#d", true},
+		{"HTTPS://example.com", true},
+		{"mailto:someone@example.com", true},
+		{"/notes/1", true},
+		{"notes/1", true},
+		{"#top", true},
+		{"?q=1", true},
+		{"/", true},
+		{"", true},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(1)", false},
+		{"vbscript:msgbox(1)", false},
+		{"data:text/html,<script>alert(1)</script>", false},
+		{"file:///etc/passwd", false},
+		{"java\tscript:alert(1)", false},
+		{"\x01javascript:alert(1)", false},
+		{"//evil.example", false},
+		{`/\evil.example`, false},
+		{`\\evil.example`, false},
+		{`\/evil.example`, false},
+	}
+	for _, tt := range tests {
+		if got := safeURL(tt.url); got != tt.safe {
+			t.Errorf("safeURL(%q) = %v, want %v", tt.url, got, tt.safe)
+		}
+	}
+}
+
+func TestRenderEscapes(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`<script>alert(1)</script>`, `<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>`},
+		{`<img src=x onerror=alert(1)>`, `<p>&lt;img src=x onerror=alert(1)&gt;</p>`},
+		{`[click](javascript:alert%281%29)`, `<p>click</p>`},
+		{`[click](JAVASCRIPT:alert%281%29)`, `<p>click</p>`},
+		{`[click](data:text/html;base64,PHNjcmlwdD4=)`, `<p>click</p>`},
+		{`[click](//evil.example)`, `<p>click</p>`},
+		{`[<b>x</b>](javascript:void)`, `<p>&lt;b&gt;x&lt;/b&gt;</p>`},
+		{`[x](/a"onmouseover="alert(1))`, `<p><a href="/a&#34;onmouseover=&#34;alert(1" rel="noopener noreferrer nofollow" target="_blank">x</a>)</p>`},
+		{`https://example.com/"onmouseover="alert(1)`, `<p><a href="https://example.com/" rel="noopener noreferrer nofollow" target="_blank">https://example.com/</a>&#34;onmouseover=&#34;alert(1)</p>`},
+		{"`<b>`", `<p><code>&lt;b&gt;</code></p>`},
+		{"```\n<script>\n```", `<pre><code>&lt;script&gt;</code></pre>`},
+		{`# <i>title</i>`, `<h1>&lt;i&gt;title&lt;/i&gt;</h1>`},
+		{`- [ ] <u>task</u>`, `<ul><li class="task"><input type="checkbox" disabled> &lt;u&gt;task&lt;/u&gt;</li></ul>`},
+		{`> <iframe>`, `<blockquote><p>&lt;iframe&gt;</p></blockquote>`},
+		{`*<em>*`, `<p><em>&lt;em&gt;</em></p>`},
+		{`\<script>`, `<p>\&lt;script&gt;</p>`},
+	}
+	for _, tt := range tests {
+		got := Render(tt.src)
+		if got != tt.want {
+			t.Errorf("Render(%q) =\n%s\nwant\n%s", tt.src, got, tt.want)
+		}
+		if strings.Contains(got, "<script") || strings.Contains(got, "javascript:") {
+			t.Errorf("Render(%q) = %s is unsafe", tt.src, got)
+		}
+	}
+}
+
+func TestRenderEmphasis(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`*a*`, `<p><em>a</em></p>`},
+		{`_a_`, `<p><em>a</em></p>`},
+		{`**a**`, `<p><strong>a</strong></p>`},
+		{`__a__`, `<p><strong>a</strong></p>`},
+		{`snake_case_name`, `<p>snake_case_name</p>`},
+		{`a __dunder__ b`, `<p>a <strong>dunder</strong> b</p>`},
+		{`my__init__method`, `<p>my__init__method</p>`},
+		{`_foo_bar_`, `<p><em>foo_bar</em></p>`},
+		{`(_a_)`, `<p>(<em>a</em>)</p>`},
+		{`é_a_`, `<p>é_a_</p>`},
+		{`_a_é`, `<p>_a_é</p>`},
+		{`un*frigging*believable`, `<p>un<em>frigging</em>believable</p>`},
+		{`file_1 and file_2`, `<p>file_1 and file_2</p>`},
+	}
+	for _, tt := range tests {
+		if got := Render(tt.src); got != tt.want {
+			t.Errorf("Render(%q) = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package markdown
+
+import (
+	"strings"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// TaskList returns a GitHub-flavoured Markdown task list of the todos. Tags,
+// priority and due date follow the title. Checklist items are nested tasks
+// and notes are indented under their todo.
+func TaskList(todos []model.Todo) string {
+	var b strings.Builder
+	for _, t := range todos {
+		b.WriteString(task(t.Completed, oneLine(t.Title)))
+		for _, tag := range t.Tags {
+			b.WriteString(" `#" + tag + "`")
+		}
+		if t.Priority != "" {
+			b.WriteString(" (" + t.Priority + " priority)")
+		}
+		if t.Due != "" {
+			b.WriteString(" — due " + t.Due)
+		}
+		b.WriteString("\n")
+
+		for _, item := range t.Checklist {
+			b.WriteString("  ")
+			b.WriteString(task(item.Done, oneLine(item.Text)))
+			b.WriteString("\n")
+		}
+		if notes := strings.TrimSpace(t.Notes); notes != "" {
+			b.WriteString("\n")
+			for _, l := range strings.Split(notes, "\n") {
+				if l = strings.TrimRight(l, " \t\r"); l != "" {
+					b.WriteString("  " + l)
+				}
+				b.WriteString("\n")
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func task(done bool, text string) string {
+	if done {
+		return "- [x] " + text
+	}
+	return "- [ ] " + text
+}
+
+// oneLine keeps a title on a single line.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Change is an entry of the history of a todo: its creation, its deletion or
+// the change of one of its fields. Changes are only ever appended.
+type Change struct {
+	// Time is the RFC 3339 time of the change and By the user who made it,
+	// empty when the list has no accounts.
+	Time string `json:"time"`
+	By   string `json:"by,omitempty"`
+
+	// Kind is OpCreate, OpDelete or OpUpdate. Title is the title of the todo
+	// after the change, or before its deletion.
+	Kind  string `json:"kind"`
+	Todo  string `json:"todo"`
+	Title string `json:"title"`
+
+	// Field is the JSON name of the field changed by an update, Old and New
+	// its values before and after, as text.
+	Field string `json:"field,omitempty"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+// derivedFields are not recorded in histories: they follow from other fields.
+var derivedFields = map[string]bool{"updated": true, "completedat": true}
+
+// History returns the changes that turn prev into next, as made by user by at
+// now. Moving a todo within the list does not change it.
+func History(prev, next []Todo, by string, now time.Time) []Change {
+	stamp := now.UTC().Format(time.RFC3339)
+	change := func(kind string, t Todo) Change {
+		return Change{Time: stamp, By: by, Kind: kind, Todo: t.ID, Title: t.Title}
+	}
+
+	var changes []Change
+	byID := make(map[string]Todo, len(prev))
+	for _, t := range prev {
+		byID[t.ID] = t
+	}
+	inNext := make(map[string]bool, len(next))
+	for _, t := range next {
+		inNext[t.ID] = true
+		p, ok := byID[t.ID]
+		if !ok {
+			changes = append(changes, change(OpCreate, t))
+			continue
+		}
+		pv, tv := reflect.ValueOf(p), reflect.ValueOf(t)
+		for i := 0; i < tv.NumField(); i++ {
+			name := jsonName(tv.Type().Field(i))
+			if derivedFields[name] || reflect.DeepEqual(pv.Field(i).Interface(), tv.Field(i).Interface()) {
+				continue
+			}
+			c := change(OpUpdate, t)
+			c.Field, c.Old, c.New = name, fieldText(pv.Field(i)), fieldText(tv.Field(i))
+			changes = append(changes, c)
+		}
+	}
+	for _, t := range prev {
+		if !inNext[t.ID] {
+			changes = append(changes, change(OpDelete, t))
+		}
+	}
+	return changes
+}
+
+// fieldText returns the value of a todo field as text.
+func fieldText(v reflect.Value) string {
+	switch x := v.Interface().(type) {
+	case string:
+		return x
+	case bool:
+		return strconv.FormatBool(x)
+	case []string:
+		return strings.Join(x, ", ")
+	case Extensions:
+		return strings.Join(x, " ")
+	case []ChecklistItem:
+		items := make([]string, len(x))
+		for i, item := range x {
+			mark := "[ ]"
+			if item.Done {
+				mark = "[x]"
+			}
+			items[i] = mark + " " + item.Text
+		}
+		return strings.Join(items, "; ")
+	case map[string]string:
+		pairs := make([]string, 0, len(x))
+		for k, v := range x {
+			pairs = append(pairs, k+":"+v)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, " ")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// Describe returns a short sentence telling what a change did, without its
+// time and author.
+func (c Change) Describe() string {
+	switch c.Kind {
+	case OpCreate:
+		return "created " + strconv.Quote(c.Title)
+	case OpDelete:
+		return "deleted " + strconv.Quote(c.Title)
+	}
+	if c.Field == "title" {
+		return "renamed " + strconv.Quote(c.Old) + " to " + strconv.Quote(c.New)
+	}
+	if c.Field == "completed" {
+		if c.New == "true" {
+			return "completed " + strconv.Quote(c.Title)
+		}
+		return "reopened " + strconv.Quote(c.Title)
+	}
+	old, new := c.Old, c.New
+	if old == "" {
+		old = "(none)"
+	}
+	if new == "" {
+		new = "(none)"
+	}
+	return "changed " + c.Field + " of " + strconv.Quote(c.Title) + ": " + old + " → " + new
+}
@@ -0,0 +1,132 @@
+package model
+
+import (
+	"reflect"
+	"time"
+)
+
+// sameContent reports whether two versions of a todo only differ by their
+// Updated time.
+func sameContent(a, b Todo) bool {
+	a.Updated, b.Updated = "", ""
+	return reflect.DeepEqual(a, b)
+}
+
+// Touch sets the Updated time of the todos of next that are new or differ from
+// their version in prev to now. The others keep the Updated time of prev.
+func Touch(prev []Todo, next []Todo, now time.Time) {
+	byID := make(map[string]Todo, len(prev))
+	for _, t := range prev {
+		byID[t.ID] = t
+	}
+	stamp := now.UTC().Format(time.RFC3339Nano)
+	for i, t := range next {
+		p, ok := byID[t.ID]
+		switch {
+		case !ok || !sameContent(p, t):
+			next[i].Updated = stamp
+		default:
+			next[i].Updated = p.Updated
+		}
+	}
+}
+
+// later reports whether a was updated after b. Times that do not parse are
+// the oldest.
+func later(a, b Todo) bool {
+	ta, erra := time.Parse(time.RFC3339Nano, a.Updated)
+	tb, errb := time.Parse(time.RFC3339Nano, b.Updated)
+	switch {
+	case erra != nil:
+		return false
+	case errb != nil:
+		return true
+	}
+	return ta.After(tb)
+}
+
+// mergeTodo merges the local and remote versions of a todo, both derived from
+// base, field by field. Fields changed on one side only keep that change; a
+// field changed on both sides takes the value of the last written version,
+// remote winning ties.
+func mergeTodo(base, local, remote Todo) Todo {
+	if sameContent(local, base) {
+		return remote
+	}
+	if sameContent(remote, base) {
+		return local
+	}
+	localwins := later(local, remote)
+
+	merged := remote
+	b := reflect.ValueOf(base)
+	l := reflect.ValueOf(local)
+	r := reflect.ValueOf(remote)
+	m := reflect.ValueOf(&merged).Elem()
+	for i := 0; i < m.NumField(); i++ {
+		lchanged := !reflect.DeepEqual(l.Field(i).Interface(), b.Field(i).Interface())
+		rchanged := !reflect.DeepEqual(r.Field(i).Interface(), b.Field(i).Interface())
+		if lchanged && (!rchanged || localwins) {
+			m.Field(i).Set(l.Field(i))
+		}
+	}
+	if localwins {
+		merged.Updated = local.Updated
+	}
+	return merged
+}
+
+// MergeLists reconciles the local list of todos with a remote one, both derived
+// from base, the last list they shared. Todos are matched by id and merged
+// field by field. A todo deleted on one side stays deleted unless the other
+// side changed it. The todos whose ids are in keep, such as todos being edited,
+// keep their local version.
+//
+// The result follows the remote order, todos only added locally keeping their
+// local position.
+func MergeLists(base, local, remote []Todo, keep map[string]bool) []Todo {
+	index := func(todos []Todo) map[string]Todo {
+		m := make(map[string]Todo, len(todos))
+		for _, t := range todos {
+			m[t.ID] = t
+		}
+		return m
+	}
+	b, l := index(base), index(local)
+	r := index(remote)
+
+	var result []Todo
+	for _, rt := range remote {
+		lt, inlocal := l[rt.ID]
+		bt, inbase := b[rt.ID]
+		switch {
+		case inlocal && keep[rt.ID]:
+			result = append(result, lt)
+		case inlocal && inbase:
+			result = append(result, mergeTodo(bt, lt, rt))
+		case inlocal:
+			// Added on both sides with the same id.
+			result = append(result, mergeTodo(Todo{ID: rt.ID}, lt, rt))
+		case inbase && sameContent(bt, rt):
+			// Deleted locally.
+		default:
+			result = append(result, rt)
+		}
+	}
+
+	for i, lt := range local {
+		if _, ok := r[lt.ID]; ok {
+			continue
+		}
+		bt, inbase := b[lt.ID]
+		if inbase && sameContent(bt, lt) && !keep[lt.ID] {
+			// Deleted remotely.
+			continue
+		}
+		if i > len(result) {
+			i = len(result)
+		}
+		result = append(result[:i], append([]Todo{lt}, result[i:]...)...)
+	}
+	return result
+}
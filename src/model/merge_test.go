@@ -0,0 +1,187 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	t1 = "2024-05-01T10:00:00Z"
+	t2 = "2024-05-01T11:00:00Z"
+	t3 = "2024-05-01T12:00:00Z"
+)
+
+// at returns todos with the given ids, titled after them and updated at t.
+func at(ids string, t string) []Todo {
+	l := todos(ids)
+	for i := range l {
+		l[i].Updated = t
+	}
+	return l
+}
+
+// with returns a copy of todos in which the todo id is changed by f.
+func with(l []Todo, id string, f func(t *Todo)) []Todo {
+	l = append([]Todo(nil), l...)
+	for i := range l {
+		if l[i].ID == id {
+			f(&l[i])
+		}
+	}
+	return l
+}
+
+func retitled(title, updated string) func(t *Todo) {
+	return func(t *Todo) { t.Title, t.Updated = title, updated }
+}
+
+func TestMergeLists(t *testing.T) {
+	base := at("a b c", t1)
+	tests := []struct {
+		name                string
+		base, local, remote []Todo
+		keep                string
+		want                []Todo
+	}{
+		{
+			name:   "unchanged",
+			base:   base,
+			local:  base,
+			remote: base,
+			want:   base,
+		},
+		{
+			name:   "remote change",
+			base:   base,
+			local:  base,
+			remote: with(base, "b", retitled("remote", t2)),
+			want:   with(base, "b", retitled("remote", t2)),
+		},
+		{
+			name:   "local change",
+			base:   base,
+			local:  with(base, "b", retitled("local", t2)),
+			remote: base,
+			want:   with(base, "b", retitled("local", t2)),
+		},
+		{
+			name:   "later remote change wins",
+			base:   base,
+			local:  with(base, "b", retitled("local", t2)),
+			remote: with(base, "b", retitled("remote", t3)),
+			want:   with(base, "b", retitled("remote", t3)),
+		},
+		{
+			name:   "later local change wins",
+			base:   base,
+			local:  with(base, "b", retitled("local", t3)),
+			remote: with(base, "b", retitled("remote", t2)),
+			want:   with(base, "b", retitled("local", t3)),
+		},
+		{
+			name:   "remote wins ties",
+			base:   base,
+			local:  with(base, "b", retitled("local", t2)),
+			remote: with(base, "b", retitled("remote", t2)),
+			want:   with(base, "b", retitled("remote", t2)),
+		},
+		{
+			name:   "local wins while editing",
+			base:   base,
+			local:  with(base, "b", retitled("local", t2)),
+			remote: with(base, "b", retitled("remote", t3)),
+			keep:   "b",
+			want:   with(base, "b", retitled("local", t2)),
+		},
+		{
+			name:   "unchanged todo being edited",
+			base:   base,
+			local:  base,
+			remote: with(base, "b", retitled("remote", t2)),
+			keep:   "b",
+			want:   base,
+		},
+		{
+			name: "fields changed on each side",
+			base: base,
+			local: with(base, "b", func(t *Todo) {
+				t.Completed, t.Updated = true, t3
+			}),
+			remote: with(base, "b", retitled("remote", t2)),
+			want: with(base, "b", func(t *Todo) {
+				t.Title, t.Completed, t.Updated = "remote", true, t3
+			}),
+		},
+		{
+			name:   "remote delete",
+			base:   base,
+			local:  base,
+			remote: at("a c", t1),
+			want:   at("a c", t1),
+		},
+		{
+			name:   "remote delete of a todo changed locally",
+			base:   base,
+			local:  with(base, "b", retitled("local", t2)),
+			remote: at("a c", t1),
+			want:   with(base, "b", retitled("local", t2)),
+		},
+		{
+			name:   "remote delete of a todo being edited",
+			base:   base,
+			local:  base,
+			remote: at("a c", t1),
+			keep:   "b",
+			want:   base,
+		},
+		{
+			name:   "local delete",
+			base:   base,
+			local:  at("a c", t1),
+			remote: base,
+			want:   at("a c", t1),
+		},
+		{
+			name:   "local delete of a todo changed remotely",
+			base:   base,
+			local:  at("a c", t1),
+			remote: with(base, "b", retitled("remote", t2)),
+			want:   with(base, "b", retitled("remote", t2)),
+		},
+		{
+			name:   "remote reorder",
+			base:   base,
+			local:  base,
+			remote: at("c a b", t1),
+			want:   at("c a b", t1),
+		},
+		{
+			name:   "local reorder",
+			base:   base,
+			local:  at("c a b", t1),
+			remote: base,
+			want:   base,
+		},
+		{
+			name:   "local add keeps its position",
+			base:   base,
+			local:  at("a d b c", t1),
+			remote: at("c a b", t1),
+			want:   at("c d a b", t1),
+		},
+		{
+			name:   "added on both sides",
+			base:   base,
+			local:  at("a b c d", t1),
+			remote: at("a b c e", t1),
+			want:   at("a b c d e", t1),
+		},
+	}
+	for _, tt := range tests {
+		keep := map[string]bool{tt.keep: tt.keep != ""}
+		got := MergeLists(tt.base, tt.local, tt.remote, keep)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: MergeLists =\n%+v\nwant\n%+v", tt.name, got, tt.want)
+		}
+	}
+}
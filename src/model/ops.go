@@ -0,0 +1,253 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Op kinds. Toggle, Retitle and Update change fields of a todo and only differ
+// by the fields they name.
+const (
+	OpCreate  = "create"
+	OpToggle  = "toggle"
+	OpRetitle = "retitle"
+	OpUpdate  = "update"
+	OpDelete  = "delete"
+	OpReorder = "reorder"
+)
+
+// Op is a change to a list of todos. Ops are idempotent, so that they can be
+// replayed after a failed delivery, and are applied by id rather than by
+// position, so that they still make sense on a list changed by others.
+type Op struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+
+	// OpID names the op, for the server to apply it once however many times
+	// it is sent. It is set by the client queuing the op.
+	OpID string `json:"opid,omitempty"`
+
+	// Todo holds the created todo, or the new values of Fields.
+	Todo *Todo `json:"todo,omitempty"`
+
+	// Fields are the JSON names of the fields changed by a toggle, retitle
+	// or update.
+	Fields []string `json:"fields,omitempty"`
+
+	// After is the id of the todo that a created or reordered todo follows,
+	// empty for the first position.
+	After string `json:"after,omitempty"`
+}
+
+// jsonName returns the JSON name of a struct field.
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// changedFields returns the JSON names of the fields that differ between two
+// versions of a todo.
+func changedFields(a, b Todo) []string {
+	var fields []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			fields = append(fields, jsonName(va.Type().Field(i)))
+		}
+	}
+	return fields
+}
+
+// copyFields copies the named fields of src to dst.
+func copyFields(dst *Todo, src Todo, fields []string) {
+	d := reflect.ValueOf(dst).Elem()
+	s := reflect.ValueOf(src)
+	for i := 0; i < d.NumField(); i++ {
+		name := jsonName(d.Type().Field(i))
+		for _, f := range fields {
+			if f == name && name != "id" {
+				d.Field(i).Set(s.Field(i))
+			}
+		}
+	}
+}
+
+func fieldKind(fields []string) string {
+	only := func(names ...string) bool {
+		for _, f := range fields {
+			var ok bool
+			for _, n := range names {
+				ok = ok || f == n
+			}
+			if !ok {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case only("completed", "completedat", "updated"):
+		return OpToggle
+	case only("title", "updated"):
+		return OpRetitle
+	}
+	return OpUpdate
+}
+
+// longestIncreasing returns the positions, in seq, of a longest increasing
+// subsequence of seq.
+func longestIncreasing(seq []int) []int {
+	var tails []int // positions of the smallest tail of each subsequence length
+	prev := make([]int, len(seq))
+	for i, v := range seq {
+		lo, hi := 0, len(tails)
+		for lo < hi {
+			m := (lo + hi) / 2
+			if seq[tails[m]] < v {
+				lo = m + 1
+			} else {
+				hi = m
+			}
+		}
+		if lo > 0 {
+			prev[i] = tails[lo-1]
+		} else {
+			prev[i] = -1
+		}
+		if lo == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[lo] = i
+		}
+	}
+	if len(tails) == 0 {
+		return nil
+	}
+	positions := make([]int, len(tails))
+	k := tails[len(tails)-1]
+	for i := len(tails) - 1; i >= 0; i-- {
+		positions[i] = k
+		k = prev[k]
+	}
+	return positions
+}
+
+// Diff returns the ops that turn prev into next. Todos that keep their
+// relative order are not reordered.
+func Diff(prev, next []Todo) []Op {
+	var ops []Op
+
+	inNext := make(map[string]bool, len(next))
+	for _, t := range next {
+		inNext[t.ID] = true
+	}
+	prevIndex := make(map[string]int, len(prev))
+	for i, t := range prev {
+		prevIndex[t.ID] = i
+		if !inNext[t.ID] {
+			ops = append(ops, Op{Kind: OpDelete, ID: t.ID})
+		}
+	}
+
+	// Todos kept in place are those of a longest run already in order.
+	var kept []string
+	var seq []int
+	for _, t := range next {
+		if i, ok := prevIndex[t.ID]; ok {
+			kept = append(kept, t.ID)
+			seq = append(seq, i)
+		}
+	}
+	stable := make(map[string]bool, len(seq))
+	for _, p := range longestIncreasing(seq) {
+		stable[kept[p]] = true
+	}
+
+	for i, t := range next {
+		after := ""
+		if i > 0 {
+			after = next[i-1].ID
+		}
+		p, ok := prevIndex[t.ID]
+		if !ok {
+			t := t
+			ops = append(ops, Op{Kind: OpCreate, ID: t.ID, Todo: &t, After: after})
+			continue
+		}
+		if !stable[t.ID] {
+			ops = append(ops, Op{Kind: OpReorder, ID: t.ID, After: after})
+		}
+		if fields := changedFields(prev[p], t); len(fields) > 0 {
+			t := t
+			ops = append(ops, Op{Kind: fieldKind(fields), ID: t.ID, Todo: &t, Fields: fields})
+		}
+	}
+	return ops
+}
+
+func indexByID(todos []Todo, id string) int {
+	for i, t := range todos {
+		if t.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// insertAfter inserts t after the todo with the given id, first when after is
+// empty and last when there is no such todo.
+func insertAfter(todos []Todo, t Todo, after string) []Todo {
+	i := 0
+	if after != "" {
+		i = indexByID(todos, after) + 1
+		if i == 0 {
+			i = len(todos)
+		}
+	}
+	todos = append(todos, Todo{})
+	copy(todos[i+1:], todos[i:])
+	todos[i] = t
+	return todos
+}
+
+// Apply returns the result of applying ops to todos, which is left unchanged.
+// Ops about todos that no longer exist are ignored.
+func Apply(todos []Todo, ops []Op) []Todo {
+	result := append([]Todo(nil), todos...)
+	for _, op := range ops {
+		i := indexByID(result, op.ID)
+		switch op.Kind {
+		case OpCreate:
+			if op.Todo == nil {
+				continue
+			}
+			if i >= 0 {
+				result[i] = *op.Todo
+				continue
+			}
+			result = insertAfter(result, *op.Todo, op.After)
+		case OpToggle, OpRetitle, OpUpdate:
+			if i >= 0 && op.Todo != nil {
+				copyFields(&result[i], *op.Todo, op.Fields)
+			}
+		case OpDelete:
+			if i >= 0 {
+				result = append(result[:i], result[i+1:]...)
+			}
+		case OpReorder:
+			if i < 0 || op.After == op.ID {
+				continue
+			}
+			if op.After != "" && indexByID(result, op.After) < 0 {
+				continue
+			}
+			t := result[i]
+			result = append(result[:i], result[i+1:]...)
+			result = insertAfter(result, t, op.After)
+		}
+	}
+	return result
+}
@@ -0,0 +1,193 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// todos returns todos with the given ids, titled after them.
+func todos(ids string) []Todo {
+	var l []Todo
+	for _, id := range strings.Fields(ids) {
+		l = append(l, Todo{ID: id, Title: "Todo " + id})
+	}
+	return l
+}
+
+func ids(l []Todo) string {
+	var s []string
+	for _, t := range l {
+		s = append(s, t.ID)
+	}
+	return strings.Join(s, " ")
+}
+
+func sameTodos(a, b []Todo) bool {
+	return len(a) == 0 && len(b) == 0 || reflect.DeepEqual(a, b)
+}
+
+func TestDiffApply(t *testing.T) {
+	edited := todos("a b c")
+	edited[1].Completed = true
+	edited[1].CompletedAt = "2024-05-01"
+	edited[2].Tags = []string{"home"}
+	edited[2].Title = "Call mum"
+
+	tests := []struct {
+		name       string
+		prev, next []Todo
+	}{
+		{"empty", nil, nil},
+		{"unchanged", todos("a b c"), todos("a b c")},
+		{"create first", nil, todos("a")},
+		{"create", todos("a c"), todos("a b c d")},
+		{"delete", todos("a b c d"), todos("b d")},
+		{"delete all", todos("a b"), nil},
+		{"move to the end", todos("a b c d"), todos("b c d a")},
+		{"move to the front", todos("a b c d"), todos("d a b c")},
+		{"reverse", todos("a b c d e"), todos("e d c b a")},
+		{"swap", todos("a b c d"), todos("a c b d")},
+		{"create, delete and move", todos("a b c d"), todos("e d b f")},
+		{"fields", todos("a b c"), edited},
+	}
+	for _, tt := range tests {
+		prev := append([]Todo(nil), tt.prev...)
+		ops := Diff(tt.prev, tt.next)
+		got := Apply(tt.prev, ops)
+		if !sameTodos(got, tt.next) {
+			t.Errorf("%s: Apply(prev, Diff(prev, next)) = %+v, want %+v (ops %+v)", tt.name, got, tt.next, ops)
+		}
+		if again := Apply(got, ops); !sameTodos(again, tt.next) {
+			t.Errorf("%s: ops applied twice give %+v, want %+v", tt.name, again, tt.next)
+		}
+		if !sameTodos(tt.prev, prev) {
+			t.Errorf("%s: Apply changed prev to %+v", tt.name, tt.prev)
+		}
+	}
+}
+
+func TestDiffMoves(t *testing.T) {
+	// Todos that keep their relative order are not reordered.
+	tests := []struct {
+		prev, next string
+		moved      []string
+	}{
+		{"a b c d", "a b c d", nil},
+		{"a b c d", "b c d a", []string{"a"}},
+		{"a b c d", "d a b c", []string{"d"}},
+		{"a b c d", "a c b d", []string{"c"}},
+		{"a b c d", "b a d c", []string{"b", "d"}},
+	}
+	for _, tt := range tests {
+		var moved []string
+		for _, op := range Diff(todos(tt.prev), todos(tt.next)) {
+			if op.Kind == OpReorder {
+				moved = append(moved, op.ID)
+			}
+		}
+		if !reflect.DeepEqual(moved, tt.moved) {
+			t.Errorf("Diff(%s, %s) moves %v, want %v", tt.prev, tt.next, moved, tt.moved)
+		}
+	}
+}
+
+func TestApplyReplay(t *testing.T) {
+	b := Todo{ID: "b", Title: "Todo b"}
+	tests := []struct {
+		name  string
+		todos string
+		ops   []Op
+		want  string
+	}{
+		{"create twice", "a c", []Op{{Kind: OpCreate, ID: "b", Todo: &b, After: "a"}, {Kind: OpCreate, ID: "b", Todo: &b, After: "a"}}, "a b c"},
+		{"create of an existing todo", "a b c", []Op{{Kind: OpCreate, ID: "b", Todo: &b, After: "c"}}, "a b c"},
+		{"create after a missing todo", "a", []Op{{Kind: OpCreate, ID: "b", Todo: &b, After: "x"}}, "a b"},
+		{"create without a todo", "a", []Op{{Kind: OpCreate, ID: "b"}}, "a"},
+		{"delete twice", "a b c", []Op{{Kind: OpDelete, ID: "b"}, {Kind: OpDelete, ID: "b"}}, "a c"},
+		{"delete of a missing todo", "a c", []Op{{Kind: OpDelete, ID: "b"}}, "a c"},
+		{"update of a deleted todo", "a c", []Op{{Kind: OpRetitle, ID: "b", Todo: &b, Fields: []string{"title"}}}, "a c"},
+	}
+	for _, tt := range tests {
+		got := Apply(todos(tt.todos), tt.ops)
+		if ids(got) != tt.want {
+			t.Errorf("%s: Apply gives %s, want %s", tt.name, ids(got), tt.want)
+		}
+	}
+}
+
+func TestApplyReorder(t *testing.T) {
+	tests := []struct {
+		todos string
+		op    Op
+		want  string
+	}{
+		{"a b c d", Op{Kind: OpReorder, ID: "a", After: "d"}, "b c d a"},
+		{"a b c d", Op{Kind: OpReorder, ID: "d"}, "d a b c"},
+		{"a b c d", Op{Kind: OpReorder, ID: "b", After: "c"}, "a c b d"},
+		{"a b c d", Op{Kind: OpReorder, ID: "c", After: "b"}, "a b c d"},
+		{"a b c d", Op{Kind: OpReorder, ID: "b", After: "b"}, "a b c d"},
+		{"a b c d", Op{Kind: OpReorder, ID: "b", After: "x"}, "a b c d"},
+		{"a b c d", Op{Kind: OpReorder, ID: "x", After: "a"}, "a b c d"},
+	}
+	for _, tt := range tests {
+		got := ids(Apply(todos(tt.todos), []Op{tt.op}))
+		if got != tt.want {
+			t.Errorf("Apply(%s, reorder %s after %q) = %s, want %s", tt.todos, tt.op.ID, tt.op.After, got, tt.want)
+		}
+		if again := ids(Apply(todos(tt.todos), []Op{tt.op, tt.op})); again != tt.want {
+			t.Errorf("Apply(%s, reorder %s after %q) twice = %s, want %s", tt.todos, tt.op.ID, tt.op.After, again, tt.want)
+		}
+	}
+}
+
+func TestApplyFields(t *testing.T) {
+	current := Todo{
+		ID:        "a",
+		Title:     "Buy milk",
+		Tags:      []string{"shop"},
+		Priority:  "A",
+		Due:       "2024-05-01",
+		Notes:     "semi-skimmed",
+		Checklist: []ChecklistItem{{Text: "Milk"}},
+		Updated:   "2024-04-01T10:00:00Z",
+	}
+	// The op carries a stale copy of the todo, under another id: only the
+	// fields it names, but for the id, may be taken from it.
+	stale := Todo{ID: "x", Title: "Buy bread", Completed: true, CompletedAt: "2024-04-02", Updated: "2024-04-02T10:00:00Z"}
+
+	tests := []struct {
+		name   string
+		kind   string
+		fields []string
+		want   func(t *Todo)
+	}{
+		{"retitle", OpRetitle, []string{"title", "updated"}, func(t *Todo) {
+			t.Title, t.Updated = "Buy bread", "2024-04-02T10:00:00Z"
+		}},
+		{"toggle", OpToggle, []string{"completed", "completedat"}, func(t *Todo) {
+			t.Completed, t.CompletedAt = true, "2024-04-02"
+		}},
+		{"clear", OpUpdate, []string{"tags", "notes"}, func(t *Todo) {
+			t.Tags, t.Notes = nil, ""
+		}},
+		{"id", OpUpdate, []string{"id"}, func(*Todo) {}},
+		{"no fields", OpUpdate, nil, func(*Todo) {}},
+	}
+	for _, tt := range tests {
+		want := current.Clone()
+		tt.want(&want)
+		op := stale
+		got := Apply([]Todo{current.Clone()}, []Op{{Kind: tt.kind, ID: "a", Todo: &op, Fields: tt.fields}})
+		if !reflect.DeepEqual(got, []Todo{want}) {
+			t.Errorf("%s: Apply gives %+v, want %+v", tt.name, got[0], want)
+		}
+	}
+
+	// The op does not change the todo it was applied to.
+	l := []Todo{current.Clone()}
+	Apply(l, []Op{{Kind: OpRetitle, ID: "a", Todo: &stale, Fields: []string{"title"}}})
+	if l[0].Title != current.Title {
+		t.Errorf("Apply changed the todos it was given: %+v", l[0])
+	}
+}
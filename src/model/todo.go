@@ -0,0 +1,152 @@
+// Package model defines the serialisable form of todos, shared by the storage
+// backends, the import and export formats and the server.
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Todo is the serialisable form of a todo.
+type Todo struct {
+	ID         string          `json:"id"`
+	Title      string          `json:"title"`
+	Completed  bool            `json:"completed"`
+	Tags       []string        `json:"tags,omitempty"`
+	Priority   string          `json:"priority,omitempty"`
+	Due        string          `json:"due,omitempty"`
+	List       string          `json:"list,omitempty"`
+	Recurrence string          `json:"recurrence,omitempty"`
+	Notes      string          `json:"notes,omitempty"`
+	Checklist  []ChecklistItem `json:"checklist,omitempty"`
+
+	// Created and CompletedAt are ISO 8601 dates or date-times.
+	Created     string `json:"created,omitempty"`
+	CompletedAt string `json:"completedat,omitempty"`
+
+	// Updated is the RFC 3339 time of the last change of the todo, used to
+	// merge concurrent changes.
+	Updated string `json:"updated,omitempty"`
+
+	// Extensions holds the key:value extensions of imported todo.txt tasks,
+	// so that they survive a round trip.
+	Extensions Extensions `json:"extensions,omitempty"`
+
+	// ICal holds the content lines of imported iCalendar to-dos that have no
+	// matching todo field, so that they survive a round trip.
+	ICal []string `json:"ical,omitempty"`
+}
+
+// Extensions are the key:value extensions of a todo.txt task, as written and
+// in the order of the task: keys may repeat.
+type Extensions []string
+
+// UnmarshalJSON also accepts the object of key:value pairs written by earlier
+// versions.
+func (e *Extensions) UnmarshalJSON(b []byte) error {
+	var pairs map[string]string
+	if err := json.Unmarshal(b, &pairs); err == nil {
+		keys := make([]string, 0, len(pairs))
+		for k := range pairs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		*e = nil
+		for _, k := range keys {
+			*e = append(*e, k+":"+pairs[k])
+		}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(e))
+}
+
+// Clone returns a copy of the todo that shares none of its slices.
+func (t Todo) Clone() Todo {
+	c := t
+	c.Tags = append([]string(nil), t.Tags...)
+	c.Checklist = append([]ChecklistItem(nil), t.Checklist...)
+	c.Extensions = append(Extensions(nil), t.Extensions...)
+	c.ICal = append([]string(nil), t.ICal...)
+	return c
+}
+
+// ChecklistItem is an entry of the checklist of a todo.
+type ChecklistItem struct {
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+var Priorities = []string{"high", "medium", "low"}
+
+// Validate reports whether the todo can be displayed by the app.
+func (t Todo) Validate() error {
+	if t.ID == "" {
+		return errors.New("todo has no id")
+	}
+	if t.Title == "" {
+		return fmt.Errorf("todo %s has no title", t.ID)
+	}
+	if t.Priority != "" {
+		var ok bool
+		for _, p := range Priorities {
+			ok = ok || p == t.Priority
+		}
+		if !ok {
+			return fmt.Errorf("todo %s has an invalid priority %q", t.ID, t.Priority)
+		}
+	}
+	return nil
+}
+
+// Meta returns a short description of the optional properties of a todo, as
+// shown next to its title.
+func (t Todo) Meta() string {
+	var parts []string
+	if t.Priority != "" {
+		parts = append(parts, "!"+t.Priority)
+	}
+	if t.Due != "" {
+		parts = append(parts, "due "+t.Due)
+	}
+	for _, tag := range t.Tags {
+		parts = append(parts, "#"+tag)
+	}
+	if t.Recurrence != "" {
+		parts = append(parts, "↻ "+t.Recurrence)
+	}
+	if t.List != "" {
+		parts = append(parts, "in "+t.List)
+	}
+	if len(t.Checklist) > 0 {
+		done := 0
+		for _, item := range t.Checklist {
+			if item.Done {
+				done++
+			}
+		}
+		parts = append(parts, strconv.Itoa(done)+"/"+strconv.Itoa(len(t.Checklist)))
+	}
+	if t.Notes != "" {
+		parts = append(parts, "✎")
+	}
+	return strings.Join(parts, " ")
+}
+
+// ValidateList validates every todo of a list and checks that ids are unique.
+func ValidateList(todos []Todo) error {
+	ids := make(map[string]bool, len(todos))
+	for _, t := range todos {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+		if ids[t.ID] {
+			return fmt.Errorf("duplicate todo id %s", t.ID)
+		}
+		ids[t.ID] = true
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// WorkspaceVersion is the version of the workspace format written by Marshal.
+const WorkspaceVersion = 1
+
+// Workspace is the JSON document used to export and import every todo.
+// Earlier versions also wrote the app settings, which are ignored on import.
+type Workspace struct {
+	Version  int       `json:"version"`
+	Exported time.Time `json:"exported"`
+	Todos    []Todo    `json:"todos"`
+}
+
+func NewWorkspace(todos []Todo) Workspace {
+	return Workspace{
+		Version:  WorkspaceVersion,
+		Exported: time.Now().UTC(),
+		Todos:    todos,
+	}
+}
+
+func (w Workspace) Marshal() ([]byte, error) {
+	return json.MarshalIndent(w, "", "  ")
+}
+
+// ParseWorkspace decodes and validates a workspace document.
+func ParseWorkspace(data []byte) (Workspace, error) {
+	var w Workspace
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&w); err != nil {
+		return w, fmt.Errorf("not a valid workspace file: %w", err)
+	}
+	switch {
+	case w.Version == 0:
+		return w, fmt.Errorf("not a valid workspace file: missing version")
+	case w.Version > WorkspaceVersion:
+		return w, fmt.Errorf("workspace version %d is not supported", w.Version)
+	}
+	if err := ValidateList(w.Todos); err != nil {
+		return w, fmt.Errorf("invalid workspace: %w", err)
+	}
+	return w, nil
+}
+
+// ImportMode tells how imported todos are combined with the current ones.
+type ImportMode int
+
+const (
+	// Replace discards the current todos.
+	Replace ImportMode = iota
+	// Merge updates the current todos with the imported todos of the same id
+	// and appends the others.
+	Merge
+)
+
+// ImportPlan describes the outcome of an import before it is applied.
+type ImportPlan struct {
+	Added   []Todo
+	Updated []Todo
+	Removed []Todo
+	Result  []Todo
+}
+
+// Summary returns a one line description of the changes of the plan.
+func (p ImportPlan) Summary() string {
+	return fmt.Sprintf("%d new, %d updated, %d removed", len(p.Added), len(p.Updated), len(p.Removed))
+}
+
+// Details returns a line per todo the plan adds, updates, naming the fields
+// updated, or removes, at most max of each, current being the todos the plan
+// was computed from.
+func (p ImportPlan) Details(current []Todo, max int) []string {
+	byID := make(map[string]Todo, len(current))
+	for _, t := range current {
+		byID[t.ID] = t
+	}
+	var lines []string
+	add := func(kind string, todos []Todo, line func(t Todo) string) {
+		for i, t := range todos {
+			if i == max {
+				lines = append(lines, fmt.Sprintf("%s: and %d more", kind, len(todos)-max))
+				break
+			}
+			lines = append(lines, kind+": "+line(t))
+		}
+	}
+	add("New", p.Added, func(t Todo) string { return t.Title })
+	add("Updated", p.Updated, func(t Todo) string {
+		var fields []string
+		for _, c := range History([]Todo{byID[t.ID]}, []Todo{t}, "", time.Time{}) {
+			fields = append(fields, c.Field)
+		}
+		if len(fields) == 0 {
+			return t.Title
+		}
+		return t.Title + " (" + strings.Join(fields, ", ") + ")"
+	})
+	add("Removed", p.Removed, func(t Todo) string { return t.Title })
+	return lines
+}
+
+// PlanImport computes the todos resulting from the import of incoming into
+// current.
+func PlanImport(current []Todo, incoming []Todo, mode ImportMode) ImportPlan {
+	var p ImportPlan
+
+	byID := make(map[string]Todo, len(current))
+	for _, t := range current {
+		byID[t.ID] = t
+	}
+	imported := make(map[string]Todo, len(incoming))
+	for _, t := range incoming {
+		imported[t.ID] = t
+		old, ok := byID[t.ID]
+		switch {
+		case !ok:
+			p.Added = append(p.Added, t)
+		case !reflect.DeepEqual(old, t):
+			p.Updated = append(p.Updated, t)
+		}
+	}
+
+	switch mode {
+	case Replace:
+		for _, t := range current {
+			if _, ok := imported[t.ID]; !ok {
+				p.Removed = append(p.Removed, t)
+			}
+		}
+		p.Result = append([]Todo(nil), incoming...)
+
+	case Merge:
+		for _, t := range current {
+			if n, ok := imported[t.ID]; ok {
+				t = n
+			}
+			p.Result = append(p.Result, t)
+		}
+		p.Result = append(p.Result, p.Added...)
+	}
+	return p
+}
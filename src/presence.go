@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+
+	ui "github.com/atdiar/particleui"
+	doc "github.com/atdiar/particleui/drivers/js"
+)
+
+// PresenceBar lists the people viewing the same list.
+type PresenceBar struct {
+	*ui.Element
+}
+
+// SetViewers shows the viewers of the list, you being the id of this client.
+func (p PresenceBar) SetViewers(viewers []Viewer, you string) PresenceBar {
+	names := ui.NewList()
+	for _, v := range viewers {
+		name := v.Name
+		if v.ID == you {
+			name += " (you)"
+		}
+		names = names.Append(ui.String(name))
+	}
+	p.AsElement().SetUI("viewers", names.Commit())
+	return p
+}
+
+func PresenceBarFromRef(ref *ui.Element) PresenceBar {
+	return PresenceBar{ref}
+}
+
+func NewPresenceBar(d *doc.Document, id string, options ...string) PresenceBar {
+	return PresenceBar{newpresencebar(d, id, options...)}
+}
+
+func newpresencebar(document *doc.Document, id string, options ...string) *ui.Element {
+	p := document.Div.WithID(id, options...)
+	doc.AddClass(p.AsElement(), "presence")
+	doc.SetInlineCSS(p.AsElement(), "display:none")
+
+	p.AsElement().Watch("ui", "viewers", p.AsElement(), ui.OnMutation(func(evt ui.MutationEvent) bool {
+		var names []string
+		for _, v := range evt.NewValue().(ui.List).UnsafelyUnwrap() {
+			names = append(names, string(v.(ui.String)))
+		}
+		// Alone on the list: nothing worth showing.
+		if len(names) < 2 {
+			doc.SetInlineCSS(p.AsElement(), "display:none")
+			return false
+		}
+		p.SetText("Viewing now: " + strings.Join(names, ", "))
+		doc.SetInlineCSS(p.AsElement(), "display:block")
+		return false
+	}))
+	return p.AsElement()
+}
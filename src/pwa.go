@@ -0,0 +1,136 @@
+package main
+
+import (
+	"log"
+
+	ui "github.com/atdiar/particleui"
+	doc "github.com/atdiar/particleui/drivers/js"
+	js "github.com/atdiar/particleui/drivers/js/compat"
+)
+
+// The app is made installable and able to load offline by cmd/pwa, which adds
+// a web app manifest and a service worker, sw.js, to the build. Each build has
+// its own version of the worker: a new one is installed in the background, and
+// waits for the app to tell it to take over, which the user is asked for.
+
+// UpdatePrompt tells that a new version of the app is available, and reloads
+// the app with it when asked to.
+type UpdatePrompt struct {
+	*ui.Element
+}
+
+func UpdatePromptFromRef(ref *ui.Element) UpdatePrompt {
+	return UpdatePrompt{ref}
+}
+
+func NewUpdatePrompt(d *doc.Document, id string, options ...string) UpdatePrompt {
+	return UpdatePrompt{newupdateprompt(d, id, options...)}
+}
+
+func newupdateprompt(document *doc.Document, id string, options ...string) *ui.Element {
+	p := document.Div.WithID(id, options...).AsElement()
+	doc.AddClass(p, "update-prompt")
+	doc.SetInlineCSS(p, "display:none")
+
+	msg := document.Span.WithID(id + "-msg")
+	msg.SetText("A new version of the app is available.")
+
+	reload := document.Button.WithID(id+"-reload", "button")
+	reload.SetText("Reload")
+	later := document.Button.WithID(id+"-later", "button")
+	later.SetText("Later")
+
+	var accepted bool
+
+	p.Watch("ui", "update", p, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		if evt.NewValue().(ui.Bool) {
+			doc.SetInlineCSS(p, "display:block")
+		} else {
+			doc.SetInlineCSS(p, "display:none")
+		}
+		return false
+	}))
+
+	reload.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		if waitingWorker.IsUndefined() {
+			return false
+		}
+		accepted = true
+		m := js.Global().Get("Object").New()
+		m.Set("type", "skipWaiting")
+		waitingWorker.Call("postMessage", m)
+		return false
+	}))
+
+	later.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		p.SetUI("update", ui.Bool(false))
+		return false
+	}))
+
+	// The page is reloaded once the new worker has taken over, which only
+	// happens when asked here: the first worker installed takes over
+	// without reloading.
+	if sw := js.Global().Get("navigator").Get("serviceWorker"); !sw.IsUndefined() {
+		sw.Call("addEventListener", "controllerchange", js.FuncOf(func(this js.Value, args []js.Value) any {
+			if accepted {
+				js.Global().Get("location").Call("reload")
+			}
+			return nil
+		}))
+	}
+
+	p.SetChildren(msg.AsElement(), reload.AsElement(), later.AsElement())
+	return p
+}
+
+// enableOffline registers the service worker of builds made installable by
+// cmd/pwa, showing p when a new version is deployed. Development builds go
+// without, the service worker caching files that change all the time. Both App
+// and LoginApp enable it, so that the first visit installs the worker even when
+// the user has to sign in.
+func enableOffline(p UpdatePrompt) {
+	if doc.HMRMode == "false" && doc.SSRMode == "false" {
+		go registerServiceWorker(p)
+	}
+}
+
+// waitingWorker is the new version of the service worker, once installed and
+// waiting to take over.
+var waitingWorker js.Value
+
+// registerServiceWorker registers the service worker of the build, if any, and
+// shows p whenever a new version of it is waiting to take over. Like fetch, it
+// must not be called from within a JavaScript callback.
+func registerServiceWorker(p UpdatePrompt) {
+	sw := js.Global().Get("navigator").Get("serviceWorker")
+	if sw.IsUndefined() {
+		return
+	}
+	ready := func(w js.Value) {
+		waitingWorker = w
+		p.AsElement().SetUI("update", ui.Bool(true))
+	}
+
+	reg, err := await(sw.Call("register", "./sw.js"))
+	if err != nil {
+		log.Print("unable to register the service worker: ", err)
+		return
+	}
+	// Without controller, the worker is the first one and takes over at once.
+	if w := reg.Get("waiting"); !w.IsNull() && !sw.Get("controller").IsNull() {
+		ready(w)
+	}
+	reg.Call("addEventListener", "updatefound", js.FuncOf(func(this js.Value, args []js.Value) any {
+		w := reg.Get("installing")
+		if w.IsNull() {
+			return nil
+		}
+		w.Call("addEventListener", "statechange", js.FuncOf(func(this js.Value, args []js.Value) any {
+			if w.Get("state").String() == "installed" && !sw.Get("controller").IsNull() {
+				ready(w)
+			}
+			return nil
+		}))
+		return nil
+	}))
+}
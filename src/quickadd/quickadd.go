@@ -0,0 +1,418 @@
+// Package quickadd parses the short-hand syntax accepted by the new todo input.
+//
+// Besides the title, an entry may hold:
+//   - tags, written #tag
+//   - a priority, written !high, !medium or !low (or !h, !m, !l, !1, !2, !3)
+//   - a due date: today, tomorrow, a weekday, next <weekday>, in N days|weeks,
+//     or an ISO date such as 2026-10-20, optionally followed by a time of day
+//     such as 5pm, 5:30pm or 17:00 (possibly introduced by "at")
+//   - a recurrence: daily, weekly, monthly, yearly, every day|week|month|year,
+//     every N days|weeks|months, every weekday or every <weekday>
+//
+// Dates, times and recurrences are only read at the end of an entry, where
+// tags and priorities may follow them: "Review daily report" and "Call mom
+// today about the trip" are left as they are, while "Call mom today #family"
+// is due today.
+//
+// A word preceded by a backslash, or text enclosed in double quotes, is always
+// kept as part of the title: `Buy \#1 "every friday" special` has no tag and no
+// recurrence.
+package quickadd
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Result holds the fields parsed from a quick add entry.
+type Result struct {
+	Title string
+
+	// Due is the zero time when no due date was given.
+	Due        time.Time
+	DueHasTime bool
+
+	Tags     []string
+	Priority string // "high", "medium", "low" or ""
+
+	// Recurrence is an RFC 5545 recurrence rule such as "FREQ=WEEKLY;BYDAY=FR".
+	Recurrence string
+}
+
+// DueString returns the due date as an ISO 8601 date, or date and time when a
+// time of day was given. It returns the empty string when there is no due date.
+func (r Result) DueString() string {
+	if r.Due.IsZero() {
+		return ""
+	}
+	if r.DueHasTime {
+		return r.Due.Format("2006-01-02T15:04")
+	}
+	return r.Due.Format("2006-01-02")
+}
+
+type token struct {
+	text    string
+	literal bool
+}
+
+// tokenize splits the input on white space. Quoted segments and words preceded
+// by a backslash are returned as literal tokens.
+func tokenize(s string) []token {
+	var tokens []token
+	var b strings.Builder
+	var literal, quoted, escaped bool
+
+	flush := func() {
+		if b.Len() > 0 {
+			tokens = append(tokens, token{b.String(), literal})
+		}
+		b.Reset()
+		literal = false
+	}
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+			literal = true
+		case r == '"':
+			if quoted {
+				flush()
+				quoted = false
+				continue
+			}
+			flush()
+			quoted = true
+			literal = true
+		case quoted:
+			b.WriteRune(r)
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			flush()
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if escaped {
+		b.WriteRune('\\')
+	}
+	if quoted {
+		literal = true
+	}
+	flush()
+	return tokens
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thurs": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+var byday = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+var priorities = map[string]string{
+	"high": "high", "h": "high", "1": "high",
+	"medium": "medium", "med": "medium", "m": "medium", "2": "medium",
+	"low": "low", "l": "low", "3": "low",
+}
+
+var frequencies = map[string]string{
+	"day": "DAILY", "days": "DAILY", "daily": "DAILY",
+	"week": "WEEKLY", "weeks": "WEEKLY", "weekly": "WEEKLY",
+	"month": "MONTHLY", "months": "MONTHLY", "monthly": "MONTHLY",
+	"year": "YEARLY", "years": "YEARLY", "yearly": "YEARLY",
+}
+
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// nextWeekday returns the first day strictly after now that falls on wd.
+func nextWeekday(now time.Time, wd time.Weekday) time.Time {
+	n := (int(wd) - int(now.Weekday()) + 7) % 7
+	if n == 0 {
+		n = 7
+	}
+	return midnight(now).AddDate(0, 0, n)
+}
+
+// parseClock parses times of day such as 5pm, 5:30pm, 12am or 17:00.
+func parseClock(s string) (hour, min int, ok bool) {
+	s = strings.ToLower(s)
+	var suffix string
+	if strings.HasSuffix(s, "am") || strings.HasSuffix(s, "pm") {
+		suffix = s[len(s)-2:]
+		s = s[:len(s)-2]
+	}
+	h, m, found := strings.Cut(s, ":")
+	if !found && suffix == "" {
+		return 0, 0, false
+	}
+	hour, err := strconv.Atoi(h)
+	if err != nil {
+		return 0, 0, false
+	}
+	if found {
+		if len(m) != 2 {
+			return 0, 0, false
+		}
+		min, err = strconv.Atoi(m)
+		if err != nil || min > 59 {
+			return 0, 0, false
+		}
+	}
+	switch suffix {
+	case "":
+		if hour > 23 {
+			return 0, 0, false
+		}
+	default:
+		if hour < 1 || hour > 12 {
+			return 0, 0, false
+		}
+		hour %= 12
+		if suffix == "pm" {
+			hour += 12
+		}
+	}
+	return hour, min, true
+}
+
+// phrase is a due date, time of day or recurrence read from an entry, with the
+// words it was read from.
+type phrase struct {
+	words []string
+	apply func()
+}
+
+// Parse parses a quick add entry. Relative dates are resolved against now.
+func Parse(input string, now time.Time) Result {
+	var r Result
+	var title []string
+	var date time.Time
+	var hour, min int
+	var hasClock bool
+
+	tokens := tokenize(input)
+	word := func(i int) string {
+		if i >= len(tokens) || tokens[i].literal {
+			return ""
+		}
+		return strings.ToLower(tokens[i].text)
+	}
+
+	// Phrases are only applied when they end the entry, tags and priorities
+	// aside: those followed by a word of the title are part of it.
+	var pending []phrase
+	schedule := func(i, n int, apply func()) {
+		p := phrase{apply: apply}
+		for _, t := range tokens[i : i+n] {
+			p.words = append(p.words, t.text)
+		}
+		pending = append(pending, p)
+	}
+	addTitle := func(s string) {
+		for _, p := range pending {
+			title = append(title, p.words...)
+		}
+		pending = nil
+		title = append(title, s)
+	}
+	setDate := func(d time.Time) func() {
+		return func() { date = d }
+	}
+	setClock := func(h, m int) func() {
+		return func() { hour, min, hasClock = h, m, true }
+	}
+	setRecurrence := func(rule string) func() {
+		return func() { r.Recurrence = rule }
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.literal {
+			addTitle(tok.text)
+			continue
+		}
+		w := word(i)
+
+		switch {
+		case len(w) > 1 && w[0] == '#':
+			r.Tags = append(r.Tags, tok.text[1:])
+			continue
+
+		case len(w) > 1 && w[0] == '!' && priorities[w[1:]] != "":
+			r.Priority = priorities[w[1:]]
+			continue
+
+		case w == "today":
+			schedule(i, 1, setDate(midnight(now)))
+			continue
+
+		case w == "tomorrow":
+			schedule(i, 1, setDate(midnight(now).AddDate(0, 0, 1)))
+			continue
+
+		case w == "next":
+			if wd, ok := weekdays[word(i+1)]; ok {
+				schedule(i, 2, setDate(nextWeekday(now, wd)))
+				i++
+				continue
+			}
+			if word(i+1) == "week" {
+				schedule(i, 2, setDate(nextWeekday(now, time.Monday)))
+				i++
+				continue
+			}
+
+		case w == "in":
+			n, err := strconv.Atoi(word(i + 1))
+			if err == nil && n > 0 {
+				switch word(i + 2) {
+				case "day", "days":
+					schedule(i, 3, setDate(midnight(now).AddDate(0, 0, n)))
+					i += 2
+					continue
+				case "week", "weeks":
+					schedule(i, 3, setDate(midnight(now).AddDate(0, 0, 7*n)))
+					i += 2
+					continue
+				}
+			}
+
+		case w == "at":
+			if h, m, ok := parseClock(word(i + 1)); ok {
+				schedule(i, 2, setClock(h, m))
+				i++
+				continue
+			}
+
+		case w == "every":
+			next := word(i + 1)
+			if wd, ok := weekdays[next]; ok {
+				schedule(i, 2, func() {
+					r.Recurrence = "FREQ=WEEKLY;BYDAY=" + byday[wd]
+					if date.IsZero() {
+						date = nextWeekday(now, wd)
+					}
+				})
+				i++
+				continue
+			}
+			if next == "weekday" {
+				schedule(i, 2, setRecurrence("FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"))
+				i++
+				continue
+			}
+			if f, ok := frequencies[next]; ok {
+				schedule(i, 2, setRecurrence("FREQ="+f))
+				i++
+				continue
+			}
+			if n, err := strconv.Atoi(next); err == nil && n > 0 {
+				if f, ok := frequencies[word(i+2)]; ok {
+					rule := "FREQ=" + f
+					if n > 1 {
+						rule += ";INTERVAL=" + strconv.Itoa(n)
+					}
+					schedule(i, 3, setRecurrence(rule))
+					i += 2
+					continue
+				}
+			}
+
+		case w == "daily" || w == "weekly" || w == "monthly" || w == "yearly":
+			schedule(i, 1, setRecurrence("FREQ="+frequencies[w]))
+			continue
+		}
+
+		if wd, ok := weekdays[w]; ok && len(w) > 3 {
+			schedule(i, 1, setDate(nextWeekday(now, wd)))
+			continue
+		}
+		if d, err := time.ParseInLocation("2006-01-02", w, now.Location()); err == nil {
+			schedule(i, 1, setDate(d))
+			continue
+		}
+		if h, m, ok := parseClock(w); ok {
+			schedule(i, 1, setClock(h, m))
+			continue
+		}
+
+		addTitle(tok.text)
+	}
+	for _, p := range pending {
+		p.apply()
+	}
+
+	if hasClock && date.IsZero() {
+		date = midnight(now)
+	}
+	if !date.IsZero() {
+		r.Due = date
+		if hasClock {
+			y, mo, d := date.Date()
+			r.Due = time.Date(y, mo, d, hour, min, 0, 0, date.Location())
+			r.DueHasTime = true
+		}
+	}
+	r.Title = strings.Join(title, " ")
+	return r
+}
+
+// Line is an entry of a multi-line text such as a Markdown checklist.
+type Line struct {
+	Text string
+	Done bool
+}
+
+var listMarkers = []string{"- ", "* ", "+ "}
+
+// ParseLines splits a multi-line text into entries, one per non-blank line.
+// List markers are removed and Markdown task list items such as "- [ ] item"
+// and "- [x] done" keep their completion state.
+func ParseLines(text string) []Line {
+	var lines []Line
+	for _, l := range strings.Split(text, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		for _, m := range listMarkers {
+			if strings.HasPrefix(l, m) {
+				l = strings.TrimSpace(l[len(m):])
+				break
+			}
+		}
+		if i := strings.Index(l, ". "); i > 0 {
+			if _, err := strconv.Atoi(l[:i]); err == nil {
+				l = strings.TrimSpace(l[i+2:])
+			}
+		}
+
+		var done bool
+		switch {
+		case strings.HasPrefix(l, "[ ]"):
+			l = l[3:]
+		case strings.HasPrefix(l, "[x]"), strings.HasPrefix(l, "[X]"):
+			l = l[3:]
+			done = true
+		}
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		lines = append(lines, Line{l, done})
+	}
+	return lines
+}
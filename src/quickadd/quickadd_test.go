@@ -0,0 +1,139 @@
+package quickadd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// now is a Wednesday.
+var now = time.Date(2026, 10, 21, 10, 0, 0, 0, time.UTC)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input      string
+		title      string
+		due        string
+		tags       []string
+		priority   string
+		recurrence string
+	}{
+		{input: "Buy milk", title: "Buy milk"},
+		{input: "Buy milk today", title: "Buy milk", due: "2026-10-21"},
+		{input: "Buy milk tomorrow", title: "Buy milk", due: "2026-10-22"},
+		{input: "Call mom friday", title: "Call mom", due: "2026-10-23"},
+		{input: "Call mom wednesday", title: "Call mom", due: "2026-10-28"},
+		{input: "Call mom next monday", title: "Call mom", due: "2026-10-26"},
+		{input: "Plan next week", title: "Plan", due: "2026-10-26"},
+		{input: "Renew passport in 3 days", title: "Renew passport", due: "2026-10-24"},
+		{input: "Renew passport in 2 weeks", title: "Renew passport", due: "2026-11-04"},
+		{input: "Pay rent 2026-11-01", title: "Pay rent", due: "2026-11-01"},
+		{input: "Dentist tomorrow at 5pm", title: "Dentist", due: "2026-10-22T17:00"},
+		{input: "Dentist tomorrow 5:30pm", title: "Dentist", due: "2026-10-22T17:30"},
+		{input: "Standup 9:15", title: "Standup", due: "2026-10-21T09:15"},
+		{input: "Lunch at 12am", title: "Lunch", due: "2026-10-21T00:00"},
+		{input: "Water plants daily", title: "Water plants", recurrence: "FREQ=DAILY"},
+		{input: "Water plants every 2 weeks", title: "Water plants", recurrence: "FREQ=WEEKLY;INTERVAL=2"},
+		{input: "Standup every weekday", title: "Standup", recurrence: "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"},
+		{input: "Team sync every friday", title: "Team sync", due: "2026-10-23", recurrence: "FREQ=WEEKLY;BYDAY=FR"},
+		{input: "Team sync every friday 2026-11-06", title: "Team sync", due: "2026-11-06", recurrence: "FREQ=WEEKLY;BYDAY=FR"},
+		{input: "Fix bug #work !high", title: "Fix bug", tags: []string{"work"}, priority: "high"},
+		{input: "#home Fix the !1 sink", title: "Fix the sink", tags: []string{"home"}, priority: "high"},
+		{input: "Fix bug !urgent", title: "Fix bug !urgent"},
+
+		// Tags and priorities may follow the phrases ending an entry.
+		{input: "Call mom today #family", title: "Call mom", due: "2026-10-21", tags: []string{"family"}},
+		{input: "Standup daily !m #work", title: "Standup", priority: "medium", tags: []string{"work"}, recurrence: "FREQ=DAILY"},
+
+		// Phrases followed by words of the title are part of it.
+		{input: "Review daily report", title: "Review daily report"},
+		{input: "Write weekly report every friday", title: "Write weekly report", due: "2026-10-23", recurrence: "FREQ=WEEKLY;BYDAY=FR"},
+		{input: "Call mom today about the trip", title: "Call mom today about the trip"},
+		{input: "Read Friday Night Lights", title: "Read Friday Night Lights"},
+		{input: "Meet at 5pm with Bob", title: "Meet at 5pm with Bob"},
+		{input: "Buy 2026-10-30 edition #news", title: "Buy 2026-10-30 edition", tags: []string{"news"}},
+		{input: "Check in 3 days whether it works", title: "Check in 3 days whether it works"},
+
+		// Words that only look like phrases.
+		{input: "Check in on Anna", title: "Check in on Anna"},
+		{input: "Call at noon", title: "Call at noon"},
+		{input: "Visit next door", title: "Visit next door"},
+		{input: "Fix every bug", title: "Fix every bug"},
+		{input: "Buy mon", title: "Buy mon"},
+
+		// Literals.
+		{input: `Buy \#1 "every friday" special`, title: "Buy #1 every friday special"},
+		{input: `Watch "Friday"`, title: "Watch Friday"},
+		{input: `Read "The Daily" today`, title: "Read The Daily", due: "2026-10-21"},
+	}
+	for _, tt := range tests {
+		r := Parse(tt.input, now)
+		if r.Title != tt.title {
+			t.Errorf("Parse(%q).Title = %q, want %q", tt.input, r.Title, tt.title)
+		}
+		if got := r.DueString(); got != tt.due {
+			t.Errorf("Parse(%q).Due = %q, want %q", tt.input, got, tt.due)
+		}
+		if !reflect.DeepEqual(r.Tags, tt.tags) {
+			t.Errorf("Parse(%q).Tags = %q, want %q", tt.input, r.Tags, tt.tags)
+		}
+		if r.Priority != tt.priority {
+			t.Errorf("Parse(%q).Priority = %q, want %q", tt.input, r.Priority, tt.priority)
+		}
+		if r.Recurrence != tt.recurrence {
+			t.Errorf("Parse(%q).Recurrence = %q, want %q", tt.input, r.Recurrence, tt.recurrence)
+		}
+	}
+}
+
+func TestParseDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		input string
+		now   time.Time
+		due   time.Time
+	}{
+		// Clocks go forward on 2026-03-08 and back on 2026-11-01.
+		{"Dentist tomorrow at 5pm", time.Date(2026, 3, 7, 10, 0, 0, 0, ny), time.Date(2026, 3, 8, 17, 0, 0, 0, ny)},
+		{"Run 2026-03-08 7:30", time.Date(2026, 3, 7, 10, 0, 0, 0, ny), time.Date(2026, 3, 8, 7, 30, 0, 0, ny)},
+		{"Brunch sunday at 11am", time.Date(2026, 10, 28, 10, 0, 0, 0, ny), time.Date(2026, 11, 1, 11, 0, 0, 0, ny)},
+		{"Call at 11pm", time.Date(2026, 11, 1, 8, 0, 0, 0, ny), time.Date(2026, 11, 1, 23, 0, 0, 0, ny)},
+	}
+	for _, tt := range tests {
+		r := Parse(tt.input, tt.now)
+		if !r.Due.Equal(tt.due) || !r.DueHasTime {
+			t.Errorf("Parse(%q, %v).Due = %v, want %v", tt.input, tt.now, r.Due, tt.due)
+		}
+		if h, m, _ := r.Due.Clock(); h != tt.due.Hour() || m != tt.due.Minute() {
+			t.Errorf("Parse(%q, %v) is due at %02d:%02d, want %02d:%02d", tt.input, tt.now, h, m, tt.due.Hour(), tt.due.Minute())
+		}
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	text := `
+- [ ] Buy milk
+- [x] Call mom
+* Water plants
+1. Pay rent
+
++ [X] Fix the sink
+   plain line
+- [ ]
+`
+	want := []Line{
+		{"Buy milk", false},
+		{"Call mom", true},
+		{"Water plants", false},
+		{"Pay rent", false},
+		{"Fix the sink", true},
+		{"plain line", false},
+	}
+	if got := ParseLines(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseLines = %+v, want %+v", got, want)
+	}
+}
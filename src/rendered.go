@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	js "github.com/atdiar/particleui/drivers/js/compat"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// Pages served by the server of the http backend come rendered with the list
+// they open (see cmd/todoserver/render.go), marked with a data-ssr attribute,
+// so that it shows before the app has loaded. The page is not hydrated: the
+// driver has no way to bind the elements of the app to existing nodes, so the
+// app creates its own, as always, and the rendered ones are removed once it is
+// built, so that no id is used twice. The list is
+// embedded in the ssrstate script element, along with its revision, for the
+// store to start from without loading it again.
+
+// ssrState is the list rendered along with the page.
+type ssrState struct {
+	List  string       `json:"list"`
+	ETag  string       `json:"etag"`
+	Todos []model.Todo `json:"todos"`
+}
+
+// readSSRState returns the list rendered along with the page, if it is the list
+// of endpoint.
+func readSSRState(endpoint string) (ssrState, bool) {
+	var st ssrState
+	e := js.Global().Get("document").Call("getElementById", "ssrstate")
+	if e.IsNull() {
+		return st, false
+	}
+	if err := json.Unmarshal([]byte(e.Get("textContent").String()), &st); err != nil {
+		return st, false
+	}
+	if err := model.ValidateList(st.Todos); err != nil {
+		return st, false
+	}
+	return st, strings.HasSuffix(strings.TrimRight(endpoint, "/"), "/api/lists/"+st.List)
+}
+
+// removeRenderedPage removes the rendered page, once the app is built, leaving
+// the elements of the app in its place.
+func removeRenderedPage() {
+	document := js.Global().Get("document")
+	nodes := document.Call("querySelectorAll", "[data-ssr], #ssrstate")
+	for i := 0; i < nodes.Length(); i++ {
+		nodes.Index(i).Call("remove")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Session is the account signed in to the server of the "http" backend, and
+// its role on the list at Endpoint. API is the base URL of the server API, such
+// as /api. Lists opened through a share link have no user but the Share token
+// of the link instead, which makes them read-only.
+type Session struct {
+	API      string `json:"-"`
+	Endpoint string `json:"-"`
+	User     string `json:"user"`
+	CSRF     string `json:"csrf"`
+	Role     string `json:"-"`
+	Share    string `json:"-"`
+}
+
+// ReadOnly reports whether the list can only be looked at.
+func (s Session) ReadOnly() bool {
+	return s.Role == "viewer"
+}
+
+// session is set by OpenStore, before the app starts, and not changed after:
+// signing in or out reloads the page.
+var session Session
+
+var (
+	errSignedOut    = errors.New("signed out")
+	errForbidden    = errors.New("no access to this list")
+	errShareRevoked = errors.New("share link expired or revoked")
+)
+
+// apiBase returns the base URL of the API serving the list at endpoint.
+func apiBase(endpoint string) string {
+	if i := strings.LastIndex(endpoint, "/lists/"); i >= 0 {
+		return endpoint[:i]
+	}
+	return "/api"
+}
+
+// openSession returns the session of the user signed in to the server of the
+// list at endpoint, and the role of the user on the list. It fails with
+// errSignedOut or errForbidden, in which case the returned session still holds
+// the API base URL. When the server cannot be reached, the session is returned
+// without user and the list is assumed editable: changes are queued until the
+// server answers. Like fetch, it must not be called from within a JavaScript
+// callback.
+//
+// When the URL holds a share token, in its "share" query parameter, the list
+// is opened read-only through the link instead, failing with errShareRevoked
+// if the link no longer works.
+func openSession(endpoint string) (Session, error) {
+	s := Session{API: apiBase(endpoint), Endpoint: endpoint}
+	if token := queryParam("share"); token != "" {
+		return openShare(s, token)
+	}
+	resp, err := fetch("GET", s.API+"/session", "", map[string]string{"Accept": "application/json"})
+	if err != nil {
+		return s, nil
+	}
+	switch resp.Status {
+	case 200:
+	case 401:
+		return s, errSignedOut
+	default:
+		return s, fmt.Errorf("GET %s/session: status %d", s.API, resp.Status)
+	}
+	if err := json.Unmarshal([]byte(resp.Body), &s); err != nil {
+		return s, err
+	}
+
+	resp, err = fetch("GET", endpoint, "", map[string]string{"Accept": "application/json"})
+	if err != nil {
+		return s, nil
+	}
+	switch resp.Status {
+	case 200:
+	case 401:
+		return s, errSignedOut
+	case 403, 404:
+		return s, errForbidden
+	default:
+		return s, fmt.Errorf("GET %s: status %d", endpoint, resp.Status)
+	}
+	var list struct {
+		Role string `json:"role"`
+	}
+	if err := json.Unmarshal([]byte(resp.Body), &list); err != nil {
+		return s, err
+	}
+	s.Role = list.Role
+	return s, nil
+}
+
+// openShare opens the list of s through the share link of token.
+func openShare(s Session, token string) (Session, error) {
+	s.Share, s.Role = token, "viewer"
+	resp, err := fetch("GET", s.Endpoint, "", map[string]string{"Accept": "application/json", "X-Share-Token": token})
+	if err != nil {
+		return s, nil
+	}
+	switch resp.Status {
+	case 200:
+		return s, nil
+	case 401, 403, 404:
+		return s, errShareRevoked
+	}
+	return s, fmt.Errorf("GET %s: status %d", s.Endpoint, resp.Status)
+}
+
+// signIn signs in to the server at api, creating the account first if signup is
+// true. Like fetch, it must not be called from within a JavaScript callback.
+func signIn(api string, name string, password string, signup bool) error {
+	b, err := json.Marshal(map[string]string{"name": name, "password": password})
+	if err != nil {
+		return err
+	}
+	path := "/login"
+	if signup {
+		path = "/signup"
+	}
+	resp, err := fetch("POST", api+path, string(b), map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return err
+	}
+	if resp.Status == 200 || resp.Status == 201 {
+		return nil
+	}
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal([]byte(resp.Body), &e) == nil && e.Error != "" {
+		return errors.New(e.Error)
+	}
+	return fmt.Errorf("status %d", resp.Status)
+}
+
+// signOut ends the session. Like fetch, it must not be called from within a
+// JavaScript callback.
+func signOut(api string) error {
+	resp, err := fetch("POST", api+"/logout", "", nil)
+	if err != nil {
+		return err
+	}
+	if resp.Status != 204 {
+		return fmt.Errorf("POST %s/logout: status %d", api, resp.Status)
+	}
+	return nil
+}
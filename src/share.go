@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	ui "github.com/atdiar/particleui"
+	doc "github.com/atdiar/particleui/drivers/js"
+	js "github.com/atdiar/particleui/drivers/js/compat"
+)
+
+// shareLink is a read-only link to the list, as described by the server.
+type shareLink struct {
+	ID      string     `json:"id"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires"`
+	URL     string     `json:"url"`
+}
+
+// shareExpiries are the lifetimes a new link can be given, 0 meaning that it
+// does not expire.
+var shareExpiries = []struct {
+	label   string
+	seconds int64
+}{
+	{"never expires", 0},
+	{"expires in 1 day", 24 * 3600},
+	{"expires in 7 days", 7 * 24 * 3600},
+	{"expires in 30 days", 30 * 24 * 3600},
+}
+
+// listShares returns the share links of the list at endpoint. Like fetch, it
+// must not be called from within a JavaScript callback.
+func listShares(endpoint string) ([]shareLink, error) {
+	resp, err := fetch("GET", endpoint+"/shares", "", map[string]string{"Accept": "application/json"})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != 200 {
+		return nil, fmt.Errorf("GET %s/shares: status %d", endpoint, resp.Status)
+	}
+	var body struct {
+		Shares []shareLink `json:"shares"`
+	}
+	err = json.Unmarshal([]byte(resp.Body), &body)
+	return body.Shares, err
+}
+
+// createShare asks for a new share link of the list at endpoint. Like fetch, it
+// must not be called from within a JavaScript callback.
+func createShare(endpoint string, expiresIn int64) (shareLink, error) {
+	var link shareLink
+	b, err := json.Marshal(map[string]int64{"expires_in": expiresIn})
+	if err != nil {
+		return link, err
+	}
+	resp, err := fetch("POST", endpoint+"/shares", string(b), map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return link, err
+	}
+	if resp.Status != 201 {
+		return link, fmt.Errorf("POST %s/shares: status %d", endpoint, resp.Status)
+	}
+	err = json.Unmarshal([]byte(resp.Body), &link)
+	return link, err
+}
+
+// revokeShare deletes a share link of the list at endpoint. Like fetch, it
+// must not be called from within a JavaScript callback.
+func revokeShare(endpoint string, id string) error {
+	resp, err := fetch("DELETE", endpoint+"/shares/"+id, "", nil)
+	if err != nil {
+		return err
+	}
+	if resp.Status != 204 && resp.Status != 404 {
+		return fmt.Errorf("DELETE %s/shares/%s: status %d", endpoint, id, resp.Status)
+	}
+	return nil
+}
+
+// absoluteURL resolves a path of the server against the current page.
+func absoluteURL(path string) string {
+	return js.Global().Get("location").Get("origin").String() + path
+}
+
+// ShareBar lets the owners of a list create read-only links to it, with an
+// optional expiry, and revoke them. It stays hidden for everyone else.
+type ShareBar struct {
+	*ui.Element
+}
+
+func ShareBarFromRef(ref *ui.Element) ShareBar {
+	return ShareBar{ref}
+}
+
+func NewShareBar(d *doc.Document, id string, s Session, options ...string) ShareBar {
+	return ShareBar{newsharebar(d, id, s, options...)}
+}
+
+func newsharebar(document *doc.Document, id string, s Session, options ...string) *ui.Element {
+	bar := document.Div.WithID(id, options...).AsElement()
+	doc.AddClass(bar, "share")
+	if s.Role != "owner" {
+		doc.SetInlineCSS(bar, "display:none")
+		return bar
+	}
+
+	var links []shareLink
+	var rows []*ui.Element
+	expiry := 0
+
+	msg := document.Span.WithID(id + "-msg")
+	list := document.Ul.WithID(id + "-links")
+
+	refresh := func() {
+		l, err := listShares(s.Endpoint)
+		if err != nil {
+			bar.SetUI("sharemsg", ui.String("Unable to load the share links: "+err.Error()))
+			return
+		}
+		links = l
+		bar.TriggerEvent("rendershares")
+	}
+
+	expirybtn := document.Button.WithID(id+"-expiry", "button")
+	expirybtn.SetText(shareExpiries[expiry].label)
+	// Each click picks the next lifetime.
+	expirybtn.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		expiry = (expiry + 1) % len(shareExpiries)
+		expirybtn.SetText(shareExpiries[expiry].label)
+		return false
+	}))
+
+	create := document.Button.WithID(id+"-create", "button")
+	create.SetText("Create read-only link")
+	create.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		seconds := shareExpiries[expiry].seconds
+		go func() {
+			link, err := createShare(s.Endpoint, seconds)
+			if err != nil {
+				bar.SetUI("sharemsg", ui.String("Unable to create a link: "+err.Error()))
+				return
+			}
+			if copyText(absoluteURL(link.URL)) == nil {
+				bar.SetUI("sharemsg", ui.String("Link copied to the clipboard."))
+			} else {
+				bar.SetUI("sharemsg", ui.String("Link created."))
+			}
+			refresh()
+		}()
+		return false
+	}))
+
+	bar.WatchEvent("rendershares", bar, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		for _, row := range rows {
+			ui.Delete(row)
+		}
+		rows = rows[:0]
+		for _, link := range links {
+			link := link
+			row := document.Li()
+
+			u := document.Input.WithID(id+"-link-"+link.ID, "text")
+			doc.SetAttribute(u.AsElement(), "readonly", "")
+			u.SetUI("value", ui.String(absoluteURL(link.URL)))
+
+			expires := document.Span()
+			if link.Expires != nil {
+				expires.SetText("until " + link.Expires.Local().Format("Jan 2 15:04"))
+			} else {
+				expires.SetText("no expiry")
+			}
+
+			revoke := document.Button.WithID(id+"-revoke-"+link.ID, "button")
+			revoke.SetText("Revoke")
+			revoke.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+				go func() {
+					if err := revokeShare(s.Endpoint, link.ID); err != nil {
+						bar.SetUI("sharemsg", ui.String("Unable to revoke the link: "+err.Error()))
+						return
+					}
+					bar.SetUI("sharemsg", ui.String("Link revoked."))
+					refresh()
+				}()
+				return false
+			}))
+
+			row.AsElement().SetChildren(u.AsElement(), expires.AsElement(), revoke.AsElement())
+			list.AsElement().AppendChild(row.AsElement())
+			rows = append(rows, row.AsElement())
+		}
+		return false
+	}))
+
+	bar.Watch("ui", "sharemsg", bar, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		msg.SetText(string(evt.NewValue().(ui.String)))
+		return false
+	}))
+
+	bar.SetChildren(create.AsElement(), expirybtn.AsElement(), msg.AsElement(), list.AsElement())
+	go refresh()
+	return bar
+}
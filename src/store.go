@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"reflect"
+
+	doc "github.com/atdiar/particleui/drivers/js"
+	js "github.com/atdiar/particleui/drivers/js/compat"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// TodoStore is where the todo list is persisted.
+//
+// Methods may block on I/O: apart from the initial Load, they are called from
+// a goroutine rather than from an event handler.
+type TodoStore interface {
+	// Load returns the stored todos, in list order.
+	Load() ([]model.Todo, error)
+
+	// Save replaces the stored todos.
+	Save(todos []model.Todo) error
+
+	// Watch registers a function that is called with the stored todos whenever
+	// they are changed by another writer, such as another tab or client.
+	// The returned function unregisters it.
+	Watch(f func([]model.Todo)) (unwatch func())
+
+	// Transact replaces the stored todos with the result of f, applied to the
+	// stored todos, as one change. See each store for what other writers may
+	// do in between.
+	Transact(f func([]model.Todo) ([]model.Todo, error)) error
+}
+
+// StoreBackend is the storage used when neither the URL query nor
+// zui.config.json selects one. It can be changed at build time with
+// -ldflags "-X main.StoreBackend=indexeddb".
+var StoreBackend = "local"
+
+// localStoreKey is the localStorage key used by the "local" backend.
+const localStoreKey = "todomvc2/todos"
+
+// legacyTodosKey is the localStorage key where the todos were kept before
+// stores, by the EnableLocalPersistence option of the todo list: zui names its
+// entries after the element, the category and the property persisted.
+const legacyTodosKey = "todo-list/data/todoslist"
+
+// syncStoreKey prefixes the localStorage key of the outbox of the "http"
+// backend, followed by the endpoint.
+const syncStoreKey = "todomvc2/sync:"
+
+// StoreEndpoint is the base URL of the list used by the "http" backend.
+var StoreEndpoint = "/api/lists/default"
+
+// storeConfig is the "store" entry of zui.config.json.
+type storeConfig struct {
+	Backend  string `json:"backend"`
+	Endpoint string `json:"endpoint"`
+}
+
+// readStoreConfig reads the "store" entry of zui.config.json, if it is served
+// alongside the app.
+func readStoreConfig() (storeConfig, error) {
+	var cfg struct {
+		Store storeConfig `json:"store"`
+	}
+	resp, err := fetch("GET", "./zui.config.json", "", nil)
+	if err != nil {
+		return cfg.Store, err
+	}
+	if resp.Status != 200 {
+		return cfg.Store, errors.New("zui.config.json is not available")
+	}
+	err = json.Unmarshal([]byte(resp.Body), &cfg)
+	return cfg.Store, err
+}
+
+// OpenStore returns the store selected by the "store" and "endpoint" URL query
+// parameters, falling back to the "store" entry of zui.config.json and then to
+// StoreBackend. Backends are "local", "indexeddb", "memory" and "http".
+//
+// The "http" backend first checks the session of the user on the server, or
+// the share link the app was opened with, and fails with errSignedOut,
+// errForbidden or errShareRevoked when the list cannot be opened.
+//
+// It blocks and must be called from main, before the app starts handling events.
+func OpenStore() (TodoStore, error) {
+	if doc.SSRMode != "false" {
+		return NewMemoryStore(), nil
+	}
+
+	backend, endpoint := StoreBackend, StoreEndpoint
+	if cfg, err := readStoreConfig(); err == nil {
+		if cfg.Backend != "" {
+			backend = cfg.Backend
+		}
+		if cfg.Endpoint != "" {
+			endpoint = cfg.Endpoint
+		}
+	}
+	if b := queryParam("store"); b != "" {
+		backend = b
+	}
+	if e := queryParam("endpoint"); e != "" {
+		endpoint = e
+	}
+
+	switch backend {
+	case "local":
+		s := NewLocalStore(localStoreKey)
+		if err := importLegacyTodos(s); err != nil {
+			log.Print("unable to import the todos of an earlier version: ", err)
+		}
+		return s, nil
+	case "indexeddb":
+		s, err := NewIndexedDBStore("todomvc2")
+		if err != nil {
+			return nil, err
+		}
+		// Todos saved by the local backend, or by earlier versions, are
+		// moved over on first load.
+		if err := s.MigrateFrom(NewLocalStore(localStoreKey)); err != nil {
+			log.Print("unable to migrate todos from localStorage: ", err)
+		}
+		if err := importLegacyTodos(s); err != nil {
+			log.Print("unable to import the todos of an earlier version: ", err)
+		}
+		return s, nil
+	case "memory":
+		return NewMemoryStore(), nil
+	case "http":
+		s, err := openSession(endpoint)
+		session = s
+		if err != nil {
+			return nil, err
+		}
+		key := syncStoreKey + endpoint
+		if s.Share != "" {
+			// Kept apart from the outbox of the members of the list who may
+			// use the same browser.
+			key += "#share"
+		}
+		// Changes are queued while the server is unreachable.
+		store := NewSyncStore(NewHTTPStore(endpoint), key)
+		if st, ok := readSSRState(endpoint); ok {
+			store.Seed(st.Todos, st.ETag)
+		}
+		return store, nil
+	}
+	return nil, errors.New("unknown store backend: " + backend)
+}
+
+// importLegacyTodos adds the todos kept by earlier versions of the app under
+// legacyTodosKey to the store, after its own, and removes the entry once they
+// are saved. Todos the store already has are left as they are.
+func importLegacyTodos(store TodoStore) error {
+	storage := js.Global().Get("localStorage")
+	v := storage.Call("getItem", legacyTodosKey)
+	if v.IsNull() {
+		return nil
+	}
+	todos, err := jsonTodos(v.String())
+	if err != nil {
+		return err
+	}
+	err = store.Transact(func(stored []model.Todo) ([]model.Todo, error) {
+		return mergeMissing(stored, todos), nil
+	})
+	if err != nil {
+		return err
+	}
+	storage.Call("removeItem", legacyTodosKey)
+	return nil
+}
+
+// mergeMissing returns todos followed by the todos of imported they do not
+// have.
+func mergeMissing(todos, imported []model.Todo) []model.Todo {
+	have := make(map[string]bool, len(todos))
+	for _, t := range todos {
+		have[t.ID] = true
+	}
+	for _, t := range imported {
+		if !have[t.ID] {
+			todos = append(todos, t)
+			have[t.ID] = true
+		}
+	}
+	return todos
+}
+
+// persister returns a function that saves todos to the store in the
+// background. Saves are done one at a time and only the latest pending list is
+// written. base is the list the todos derive from: when other writers changed
+// the store since, their changes are merged rather than overwritten.
+func persister(store TodoStore) func(base, todos []model.Todo) {
+	type save struct{ base, todos []model.Todo }
+	pending := make(chan save, 1)
+	go func() {
+		for p := range pending {
+			err := store.Transact(func(stored []model.Todo) ([]model.Todo, error) {
+				if reflect.DeepEqual(stored, p.base) {
+					return p.todos, nil
+				}
+				return model.MergeLists(p.base, p.todos, stored, nil), nil
+			})
+			if err != nil {
+				log.Print("unable to save todos: ", err)
+			}
+		}
+	}()
+	return func(base, todos []model.Todo) {
+		select {
+		case old := <-pending:
+			// The dropped save is still the common ancestor of the store.
+			base = old.base
+		default:
+		}
+		pending <- save{base, todos}
+	}
+}
+
+// jsonTodos parses a JSON array of todos.
+func jsonTodos(s string) ([]model.Todo, error) {
+	var todos []model.Todo
+	if s == "" {
+		return todos, nil
+	}
+	if err := json.Unmarshal([]byte(s), &todos); err != nil {
+		return nil, err
+	}
+	return todos, model.ValidateList(todos)
+}
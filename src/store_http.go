@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// HTTPStore keeps todos on a remote server. endpoint is the URL of a list, such
+// as /api/lists/default, and the todos are served at endpoint/todos:
+//   - GET returns {"todos": [...], "next": cursor}, one page at a time, with the
+//     revision of the list as ETag; the next page is requested with ?cursor=.
+//   - PUT with a {"todos": [...]} body replaces every todo. With If-Match, it
+//     fails with 412 Precondition Failed when the list has changed.
+type HTTPStore struct {
+	endpoint string
+
+	mu   sync.Mutex
+	etag string
+}
+
+// httpPollInterval is how often Watch checks the server for changes.
+var httpPollInterval = 10 * time.Second
+
+func NewHTTPStore(endpoint string) *HTTPStore {
+	return &HTTPStore{endpoint: endpoint}
+}
+
+type todosPage struct {
+	Todos []model.Todo `json:"todos"`
+	Next  string       `json:"next,omitempty"`
+}
+
+// load returns the todos and the revision of the list. When etag is the
+// current revision, it returns no todos and changed is false.
+func (s *HTTPStore) load(etag string) (todos []model.Todo, revision string, changed bool, err error) {
+	cursor := ""
+	for {
+		u := s.endpoint + "/todos"
+		if cursor != "" {
+			u += "?cursor=" + url.QueryEscape(cursor)
+		}
+		headers := map[string]string{"Accept": "application/json"}
+		if etag != "" && cursor == "" {
+			headers["If-None-Match"] = etag
+		}
+		resp, err := fetch("GET", u, "", headers)
+		if err != nil {
+			return nil, "", false, err
+		}
+		if resp.Status == 304 {
+			return nil, etag, false, nil
+		}
+		if resp.Status == 401 {
+			return nil, "", false, errSignedOut
+		}
+		if resp.Status != 200 {
+			return nil, "", false, fmt.Errorf("GET %s: status %d", u, resp.Status)
+		}
+		if cursor == "" {
+			revision = resp.Header("ETag")
+		} else if resp.Header("ETag") != revision {
+			// The list changed while it was being paged through.
+			return s.load("")
+		}
+
+		var page todosPage
+		if err := json.Unmarshal([]byte(resp.Body), &page); err != nil {
+			return nil, "", false, err
+		}
+		todos = append(todos, page.Todos...)
+		if page.Next == "" {
+			break
+		}
+		cursor = page.Next
+	}
+	return todos, revision, true, model.ValidateList(todos)
+}
+
+func (s *HTTPStore) Load() ([]model.Todo, error) {
+	todos, etag, _, err := s.load("")
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.etag = etag
+	s.mu.Unlock()
+	return todos, nil
+}
+
+var errConflict = errors.New("the list was changed by another client")
+
+// put replaces the todos on the server. If etag is not empty, the request is
+// conditional.
+func (s *HTTPStore) put(todos []model.Todo, etag string) error {
+	b, err := json.Marshal(todosPage{Todos: todos})
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"Content-Type": "application/json"}
+	if etag != "" {
+		headers["If-Match"] = etag
+	}
+	resp, err := fetch("PUT", s.endpoint+"/todos", string(b), headers)
+	if err != nil {
+		return err
+	}
+	switch resp.Status {
+	case 200, 204:
+	case 412:
+		return errConflict
+	default:
+		return fmt.Errorf("PUT %s/todos: status %d", s.endpoint, resp.Status)
+	}
+	s.mu.Lock()
+	s.etag = resp.Header("ETag")
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *HTTPStore) Save(todos []model.Todo) error {
+	return s.put(todos, "")
+}
+
+// Watch polls the server for changes.
+func (s *HTTPStore) Watch(f func([]model.Todo)) func() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(httpPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			s.mu.Lock()
+			etag := s.etag
+			s.mu.Unlock()
+
+			todos, revision, changed, err := s.load(etag)
+			if err != nil || !changed {
+				continue
+			}
+			s.mu.Lock()
+			s.etag = revision
+			s.mu.Unlock()
+			f(todos)
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// Transact retries when the list is changed by another client between the load
+// and the conditional save.
+func (s *HTTPStore) Transact(f func([]model.Todo) ([]model.Todo, error)) error {
+	for attempt := 0; attempt < 5; attempt++ {
+		todos, etag, _, err := s.load("")
+		if err != nil {
+			return err
+		}
+		todos, err = f(todos)
+		if err != nil {
+			return err
+		}
+		err = s.put(todos, etag)
+		if err != errConflict {
+			return err
+		}
+	}
+	return errConflict
+}
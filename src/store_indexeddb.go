@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	js "github.com/atdiar/particleui/drivers/js/compat"
+
+	"github.com/atdiar/todomvc2/src/crdt"
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// Version 2 adds the "completed" and "due" indexes, version 3 the "crdt"
+// object store and version 4 the "journal" object store, which holds the event
+// journal (see journal.go).
+const idbVersion = 4
+
+// idbDocKey is the key of the CRDT document in the "crdt" object store.
+const idbDocKey = "list"
+
+// IndexedDBStore keeps todos in an IndexedDB database, one record per todo
+// keyed by id. The position of a todo in the list is stored alongside it.
+//
+// Like LocalStore, every tab is a replica of a CRDT document (see package
+// crdt), stored next to the records, which the records are written from.
+// Since the tabs cannot read and write the database in a single transaction,
+// a tab may overwrite the document another tab has just written: every tab
+// merges the stored document when told of a change, and writes it back if it
+// holds changes the stored one lacks.
+//
+// Saves are incremental: only the todos that changed since the last load or
+// save are written, and the todos that were removed are deleted.
+type IndexedDBStore struct {
+	mu      sync.Mutex
+	name    string
+	db      js.Value
+	channel js.Value
+	doc     *crdt.Doc
+
+	// saved holds the JSON encoding of the records as last loaded or saved,
+	// by todo id, and savedDoc the one of the document.
+	saved    map[string]string
+	savedDoc string
+}
+
+// idbRequest waits for an IndexedDB request to complete. Like await, it must
+// not be called from within a JavaScript callback.
+func idbRequest(req js.Value) (js.Value, error) {
+	type result struct {
+		v   js.Value
+		err error
+	}
+	done := make(chan result, 1)
+
+	onsuccess := js.FuncOf(func(this js.Value, args []js.Value) any {
+		done <- result{v: req.Get("result")}
+		return nil
+	})
+	onerror := js.FuncOf(func(this js.Value, args []js.Value) any {
+		done <- result{err: errors.New(req.Get("error").Call("toString").String())}
+		return nil
+	})
+	defer onsuccess.Release()
+	defer onerror.Release()
+
+	req.Set("onsuccess", onsuccess)
+	req.Set("onerror", onerror)
+	r := <-done
+	return r.v, r.err
+}
+
+// idbTransaction waits for an IndexedDB transaction to commit.
+func idbTransaction(tx js.Value) error {
+	done := make(chan error, 1)
+	oncomplete := js.FuncOf(func(this js.Value, args []js.Value) any {
+		done <- nil
+		return nil
+	})
+	onerror := js.FuncOf(func(this js.Value, args []js.Value) any {
+		done <- errors.New("indexeddb transaction failed")
+		return nil
+	})
+	defer oncomplete.Release()
+	defer onerror.Release()
+
+	tx.Set("oncomplete", oncomplete)
+	tx.Set("onerror", onerror)
+	tx.Set("onabort", onerror)
+	return <-done
+}
+
+// NewIndexedDBStore opens, and creates or upgrades if needed, the database of
+// the given name.
+func NewIndexedDBStore(name string) (*IndexedDBStore, error) {
+	req := js.Global().Get("indexedDB").Call("open", name, idbVersion)
+	onupgrade := js.FuncOf(func(this js.Value, args []js.Value) any {
+		db := req.Get("result")
+		var os js.Value
+		if !db.Get("objectStoreNames").Call("contains", "todos").Bool() {
+			opts := js.Global().Get("Object").New()
+			opts.Set("keyPath", "id")
+			os = db.Call("createObjectStore", "todos", opts)
+		} else {
+			os = req.Get("transaction").Call("objectStore", "todos")
+		}
+		// Booleans are not valid IndexedDB keys: completion is indexed on the
+		// numeric "done" property of the record.
+		indexes := os.Get("indexNames")
+		if !indexes.Call("contains", "completed").Bool() {
+			os.Call("createIndex", "completed", "done")
+		}
+		if !indexes.Call("contains", "due").Bool() {
+			os.Call("createIndex", "due", "due")
+		}
+		if !db.Get("objectStoreNames").Call("contains", "crdt").Bool() {
+			db.Call("createObjectStore", "crdt")
+		}
+		if !db.Get("objectStoreNames").Call("contains", "journal").Bool() {
+			db.Call("createObjectStore", "journal")
+		}
+		return nil
+	})
+	defer onupgrade.Release()
+	req.Set("onupgradeneeded", onupgrade)
+
+	db, err := idbRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return &IndexedDBStore{
+		name:    name,
+		db:      db,
+		channel: js.Global().Get("BroadcastChannel").New(name),
+		doc:     crdt.New(randomID()),
+		saved:   make(map[string]string),
+	}, nil
+}
+
+type idbRecord struct {
+	model.Todo
+	Position int `json:"position"`
+	Done     int `json:"done"`
+}
+
+func newIDBRecord(t model.Todo, position int) idbRecord {
+	r := idbRecord{Todo: t, Position: position}
+	if t.Completed {
+		r.Done = 1
+	}
+	return r
+}
+
+// records decodes the result of a getAll request, in list order.
+func (s *IndexedDBStore) records(all js.Value) ([]idbRecord, error) {
+	var records []idbRecord
+	raw := js.Global().Get("JSON").Call("stringify", all).String()
+	if err := json.Unmarshal([]byte(raw), &records); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Position < records[j].Position
+	})
+	return records, nil
+}
+
+func todosOf(records []idbRecord) []model.Todo {
+	todos := make([]model.Todo, 0, len(records))
+	for _, r := range records {
+		todos = append(todos, r.Todo)
+	}
+	return todos
+}
+
+func (s *IndexedDBStore) Load() ([]model.Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// load merges the stored document into the one of the tab and returns its
+// todos. Databases written by earlier versions have no document: their
+// records are converted as the JSON arrays of LocalStore are.
+func (s *IndexedDBStore) load() ([]model.Todo, error) {
+	tx := s.db.Call("transaction", js.ValueOf([]any{"todos", "crdt"}), "readonly")
+	all, err := idbRequest(tx.Call("objectStore", "todos").Call("getAll"))
+	if err != nil {
+		return nil, err
+	}
+	doc, err := idbRequest(tx.Call("objectStore", "crdt").Call("get", idbDocKey))
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.records(all)
+	if err != nil {
+		return nil, err
+	}
+
+	s.saved = make(map[string]string, len(records))
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		s.saved[r.ID] = string(b)
+	}
+
+	s.savedDoc = ""
+	if doc.IsUndefined() {
+		legacy := crdt.New(legacyReplica)
+		legacy.Update(todosOf(records))
+		s.doc.Merge(legacy)
+	} else {
+		s.savedDoc = doc.String()
+		stored, err := crdt.Unmarshal([]byte(s.savedDoc), "")
+		if err != nil {
+			return nil, err
+		}
+		s.doc.Merge(stored)
+	}
+
+	todos := s.doc.Todos()
+	return todos, model.ValidateList(todos)
+}
+
+// query returns the todos of an index matching key, in list order.
+func (s *IndexedDBStore) query(index string, key js.Value) ([]model.Todo, error) {
+	tx := s.db.Call("transaction", "todos", "readonly")
+	idx := tx.Call("objectStore", "todos").Call("index", index)
+	all, err := idbRequest(idx.Call("getAll", key))
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.records(all)
+	if err != nil {
+		return nil, err
+	}
+	return todosOf(records), nil
+}
+
+// LoadCompleted returns the todos whose completion state is completed.
+func (s *IndexedDBStore) LoadCompleted(completed bool) ([]model.Todo, error) {
+	key := 0
+	if completed {
+		key = 1
+	}
+	return s.query("completed", js.ValueOf(key))
+}
+
+// LoadDueBy returns the todos due on or before the given ISO 8601 date.
+func (s *IndexedDBStore) LoadDueBy(date string) ([]model.Todo, error) {
+	// Date-times on the last day sort after the date itself.
+	bound := js.Global().Get("IDBKeyRange").Call("upperBound", date+"T99")
+	return s.query("due", bound)
+}
+
+func (s *IndexedDBStore) Save(todos []model.Todo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save(todos)
+}
+
+// save records todos as changes of the document of the tab, then writes the
+// document, if it changed since the last load or save, the todos that changed
+// and deletes the ones that were removed.
+//
+// Positions are kept when they are still in order, so that appending, editing
+// or deleting a todo does not rewrite the todos that follow it.
+func (s *IndexedDBStore) save(todos []model.Todo) error {
+	s.doc.Update(todos)
+	todos = s.doc.Todos()
+	doc, err := s.doc.Marshal()
+	if err != nil {
+		return err
+	}
+
+	positions := make(map[string]int, len(s.saved))
+	for id, raw := range s.saved {
+		var r idbRecord
+		if err := json.Unmarshal([]byte(raw), &r); err != nil {
+			return err
+		}
+		positions[id] = r.Position
+	}
+
+	saved := make(map[string]string, len(todos))
+	var puts []string
+	prev := -1
+	for _, t := range todos {
+		pos, ok := positions[t.ID]
+		if !ok || pos <= prev {
+			pos = prev + 1
+		}
+		prev = pos
+
+		b, err := json.Marshal(newIDBRecord(t, pos))
+		if err != nil {
+			return err
+		}
+		saved[t.ID] = string(b)
+		if s.saved[t.ID] != string(b) {
+			puts = append(puts, string(b))
+		}
+	}
+	var deletes []string
+	for id := range s.saved {
+		if _, ok := saved[id]; !ok {
+			deletes = append(deletes, id)
+		}
+	}
+	if len(puts) == 0 && len(deletes) == 0 && string(doc) == s.savedDoc {
+		return nil
+	}
+
+	tx := s.db.Call("transaction", js.ValueOf([]any{"todos", "crdt"}), "readwrite")
+	os := tx.Call("objectStore", "todos")
+	for _, raw := range puts {
+		os.Call("put", js.Global().Get("JSON").Call("parse", raw))
+	}
+	for _, id := range deletes {
+		os.Call("delete", id)
+	}
+	tx.Call("objectStore", "crdt").Call("put", string(doc), idbDocKey)
+	if err := idbTransaction(tx); err != nil {
+		// The database may now differ from what was last saved: reloading
+		// makes the next save write everything that needs it.
+		s.load()
+		return err
+	}
+	s.saved = saved
+	s.savedDoc = string(doc)
+	s.channel.Call("postMessage", "changed")
+	return nil
+}
+
+// MigrateFrom moves the todos of a LocalStore into the database, after the
+// ones it already has, and removes them from localStorage once saved. Todos
+// the database already has are left as they are.
+func (s *IndexedDBStore) MigrateFrom(local LocalStore) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if local.storage().Call("getItem", local.key).IsNull() {
+		return nil
+	}
+	current, err := s.load()
+	if err != nil {
+		return err
+	}
+	todos, err := local.Load()
+	if err != nil {
+		return err
+	}
+	if err := s.save(mergeMissing(current, todos)); err != nil {
+		return err
+	}
+	local.storage().Call("removeItem", local.key)
+	return nil
+}
+
+// Watch is notified by the other tabs through a BroadcastChannel, since
+// IndexedDB has no change events. The changes of the tab that the other tab
+// overwrote are written back.
+func (s *IndexedDBStore) Watch(f func([]model.Todo)) func() {
+	channel := js.Global().Get("BroadcastChannel").New(s.name)
+	handler := js.FuncOf(func(this js.Value, args []js.Value) any {
+		go func() {
+			var todos []model.Todo
+			err := s.Transact(func(current []model.Todo) ([]model.Todo, error) {
+				todos = current
+				return current, nil
+			})
+			if err != nil {
+				return
+			}
+			f(todos)
+		}()
+		return nil
+	})
+	channel.Call("addEventListener", "message", handler)
+	return func() {
+		channel.Call("close")
+		handler.Release()
+	}
+}
+
+// Transact is atomic with respect to the other writers of this tab. An
+// IndexedDB transaction cannot be used, since it commits as soon as it has no
+// pending request, before f has run: the changes of other tabs in between are
+// merged through the document.
+func (s *IndexedDBStore) Transact(f func([]model.Todo) ([]model.Todo, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	todos, err := s.load()
+	if err != nil {
+		return err
+	}
+	todos, err = f(todos)
+	if err != nil {
+		return err
+	}
+	return s.save(todos)
+}
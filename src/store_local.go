@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	js "github.com/atdiar/particleui/drivers/js/compat"
+
+	"github.com/atdiar/todomvc2/src/crdt"
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// LocalStore keeps todos in the localStorage of the browser, under a single
+// key, as a CRDT document (see package crdt). Every tab is a replica: before
+// each read or write, the stored document is merged into the one of the tab,
+// so that concurrent edits from several tabs converge instead of overwriting
+// each other.
+type LocalStore struct {
+	key string
+
+	mu  *sync.Mutex
+	doc *crdt.Doc
+}
+
+func NewLocalStore(key string) LocalStore {
+	return LocalStore{key: key, mu: new(sync.Mutex), doc: crdt.New(randomID())}
+}
+
+// newReplicaID returns a random replica name. NewID cannot be used since its
+// seed is fixed in development builds, which would give every tab the same
+// name.
+func randomID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (s LocalStore) storage() js.Value {
+	return js.Global().Get("localStorage")
+}
+
+// legacyReplica is the replica of the documents converted from the JSON
+// arrays stored by earlier versions. Every tab converts them alike, so that
+// they merge without duplicates.
+const legacyReplica = "legacy"
+
+// pull merges the stored document into the one of the tab. It is called with
+// mu held.
+func (s LocalStore) pull() error {
+	v := s.storage().Call("getItem", s.key)
+	if v.IsNull() {
+		return nil
+	}
+	data := v.String()
+	if strings.HasPrefix(strings.TrimSpace(data), "[") {
+		todos, err := jsonTodos(data)
+		if err != nil {
+			return err
+		}
+		legacy := crdt.New(legacyReplica)
+		legacy.Update(todos)
+		s.doc.Merge(legacy)
+		return nil
+	}
+	stored, err := crdt.Unmarshal([]byte(data), "")
+	if err != nil {
+		return err
+	}
+	s.doc.Merge(stored)
+	return nil
+}
+
+// push writes the document of the tab. It is called with mu held.
+func (s LocalStore) push() error {
+	b, err := s.doc.Marshal()
+	if err != nil {
+		return err
+	}
+	s.storage().Call("setItem", s.key, string(b))
+	return nil
+}
+
+func (s LocalStore) Load() ([]model.Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.pull(); err != nil {
+		return nil, err
+	}
+	todos := s.doc.Todos()
+	return todos, model.ValidateList(todos)
+}
+
+func (s LocalStore) Save(todos []model.Todo) error {
+	return s.Transact(func([]model.Todo) ([]model.Todo, error) {
+		return todos, nil
+	})
+}
+
+// Watch relies on the storage event, which the browser dispatches to the
+// other tabs of the same origin when the key is written.
+func (s LocalStore) Watch(f func([]model.Todo)) func() {
+	handler := js.FuncOf(func(this js.Value, args []js.Value) any {
+		evt := args[0]
+		if evt.Get("key").IsNull() || evt.Get("key").String() != s.key {
+			return nil
+		}
+		todos, err := s.Load()
+		if err != nil {
+			return nil
+		}
+		f(todos)
+		return nil
+	})
+	js.Global().Call("addEventListener", "storage", handler)
+	return func() {
+		js.Global().Call("removeEventListener", "storage", handler)
+		handler.Release()
+	}
+}
+
+// Transact records the result of f as changes of the document of the tab.
+// Calls of the tab are serialised by mu, but nothing locks the key across
+// tabs: another tab may write it between the pull and the push, and its write
+// is then overwritten. Its changes are still held by its own document, and
+// are written back with its next change, unless it is closed first.
+func (s LocalStore) Transact(f func([]model.Todo) ([]model.Todo, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.pull(); err != nil {
+		return err
+	}
+	todos, err := f(s.doc.Todos())
+	if err != nil {
+		return err
+	}
+	s.doc.Update(todos)
+	return s.push()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// MemoryStore keeps todos in memory, for the time of a visit. It is used for
+// server-side rendering, where no browser storage is available, and by the
+// "memory" backend, which leaves nothing behind in the browser.
+type MemoryStore struct {
+	mu       sync.Mutex
+	todos    []model.Todo
+	watchers map[int]func([]model.Todo)
+	next     int
+}
+
+func NewMemoryStore(todos ...model.Todo) *MemoryStore {
+	return &MemoryStore{
+		todos:    append([]model.Todo(nil), todos...),
+		watchers: make(map[int]func([]model.Todo)),
+	}
+}
+
+func (m *MemoryStore) Load() ([]model.Todo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]model.Todo(nil), m.todos...), nil
+}
+
+func (m *MemoryStore) Save(todos []model.Todo) error {
+	return m.Transact(func([]model.Todo) ([]model.Todo, error) {
+		return todos, nil
+	})
+}
+
+func (m *MemoryStore) Watch(f func([]model.Todo)) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := m.next
+	m.next++
+	m.watchers[id] = f
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.watchers, id)
+	}
+}
+
+func (m *MemoryStore) Transact(f func([]model.Todo) ([]model.Todo, error)) error {
+	m.mu.Lock()
+	todos, err := f(append([]model.Todo(nil), m.todos...))
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	m.todos = append([]model.Todo(nil), todos...)
+	watchers := make([]func([]model.Todo), 0, len(m.watchers))
+	for _, w := range m.watchers {
+		watchers = append(watchers, w)
+	}
+	m.mu.Unlock()
+
+	for _, w := range watchers {
+		w(append([]model.Todo(nil), todos...))
+	}
+	return nil
+}
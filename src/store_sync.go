@@ -0,0 +1,577 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	js "github.com/atdiar/particleui/drivers/js/compat"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// SyncStore keeps working offline in front of an HTTPStore. Every save is
+// recorded as ops in an outbox persisted in localStorage, along with the last
+// todos received from the server. Ops are sent to endpoint/ops in the
+// background, with retries and exponential backoff, and the local list is
+// always the server todos with the pending ops replayed on top: when the
+// server list changes, the pending ops are rebased onto it. Since the server
+// applies the ops of every client in one order, clients converge without the
+// CRDT document of the local backends.
+//
+// Changes made by other clients are followed live through a liveTransport,
+// reconnecting from the last revision seen, and otherwise by polling.
+type SyncStore struct {
+	remote *HTTPStore
+	key    string
+
+	mu       sync.Mutex
+	state    syncState
+	status   SyncStatus
+	watchers map[int]func([]model.Todo)
+	onstatus map[int]func(SyncStatus)
+	viewers  map[int]func(viewers []Viewer, you string)
+	next     int
+	live     bool
+
+	wake chan struct{}
+}
+
+// syncState is what SyncStore persists.
+type syncState struct {
+	Server []model.Todo `json:"server"`
+	ETag   string       `json:"etag"`
+	Ops    []model.Op   `json:"ops"`
+}
+
+// SyncStatus describes the progress of the synchronisation. Rejected is the
+// reason the server gave for refusing the last change it refused, until the
+// next change is made.
+type SyncStatus struct {
+	Pending  int
+	Offline  bool
+	Err      error
+	Rejected string
+}
+
+func (s SyncStatus) String() string {
+	switch {
+	case errors.Is(s.Err, errSignedOut):
+		return "Signed out, reload to sign in"
+	case errors.Is(s.Err, errForbidden):
+		return "Read-only: changes not saved"
+	case s.Err != nil:
+		return "Sync error: " + s.Err.Error()
+	case s.Rejected != "":
+		return "Change not saved: " + s.Rejected
+	case s.Offline && s.Pending > 0:
+		return "Offline, " + strconv.Itoa(s.Pending) + " pending"
+	case s.Offline:
+		return "Offline"
+	case s.Pending > 0:
+		return "Pending " + strconv.Itoa(s.Pending)
+	}
+	return "Synced"
+}
+
+var (
+	syncMinBackoff = time.Second
+	syncMaxBackoff = time.Minute
+)
+
+// NewSyncStore returns a store synchronised with remote, whose outbox is kept
+// in localStorage under key.
+func NewSyncStore(remote *HTTPStore, key string) *SyncStore {
+	s := &SyncStore{
+		remote:   remote,
+		key:      key,
+		watchers: make(map[int]func([]model.Todo)),
+		onstatus: make(map[int]func(SyncStatus)),
+		viewers:  make(map[int]func([]Viewer, string)),
+		wake:     make(chan struct{}, 1),
+	}
+	s.reload()
+	s.status.Pending = len(s.state.Ops)
+
+	online := js.FuncOf(func(this js.Value, args []js.Value) any {
+		s.poke()
+		return nil
+	})
+	js.Global().Call("addEventListener", "online", online)
+
+	go s.run()
+	go s.follow(wsTransport{}, sseTransport{})
+	return s
+}
+
+// reload reads the state back from localStorage, where other tabs of the app
+// may have changed it. It is called with mu held before every change of the
+// state, so that the outbox is shared rather than overwritten. Tabs may still
+// push the same ops before either has dequeued them: the server applies each
+// op once, by its OpID, so that a change made by another client in between is
+// not overwritten by the second push.
+func (s *SyncStore) reload() {
+	v := js.Global().Get("localStorage").Call("getItem", s.key)
+	if v.IsNull() {
+		return
+	}
+	var state syncState
+	if err := json.Unmarshal([]byte(v.String()), &state); err != nil {
+		return
+	}
+	s.state = state
+}
+
+// persist saves the state. It is called with mu held.
+func (s *SyncStore) persist() {
+	b, err := json.Marshal(s.state)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	js.Global().Get("localStorage").Call("setItem", s.key, string(b))
+}
+
+// poke wakes the background loop up.
+func (s *SyncStore) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// local returns the server todos with the pending ops applied. It is called
+// with mu held.
+func (s *SyncStore) local() []model.Todo {
+	return model.Apply(s.state.Server, s.state.Ops)
+}
+
+// Load returns the local todos. The server is asked first if it has never been
+// reached.
+func (s *SyncStore) Load() ([]model.Todo, error) {
+	s.mu.Lock()
+	synced := s.state.ETag != ""
+	s.mu.Unlock()
+	if !synced {
+		if todos, etag, _, err := s.remote.load(""); err == nil {
+			s.mu.Lock()
+			s.reload()
+			s.state.Server, s.state.ETag = todos, etag
+			s.persist()
+			s.mu.Unlock()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reload()
+	return s.local(), nil
+}
+
+// Seed starts the store from todos, the list at revision etag rendered by
+// the server along with the page, unless it knows a later revision, so that
+// Load does not need to load the list again.
+func (s *SyncStore) Seed(todos []model.Todo, etag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reload()
+	if s.state.ETag != "" && etagRevision(s.state.ETag) >= etagRevision(etag) {
+		return
+	}
+	s.state.Server, s.state.ETag = todos, etag
+	s.persist()
+}
+
+// Save queues the ops turning the local todos into todos.
+func (s *SyncStore) Save(todos []model.Todo) error {
+	s.mu.Lock()
+	s.reload()
+	ops := model.Diff(s.local(), todos)
+	if len(ops) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	for i := range ops {
+		ops[i].OpID = randomID()
+	}
+	s.state.Ops = append(s.state.Ops, ops...)
+	s.persist()
+	pending := len(s.state.Ops)
+	s.mu.Unlock()
+
+	s.setStatus(func(st *SyncStatus) { st.Pending, st.Rejected = pending, "" })
+	s.poke()
+	return nil
+}
+
+// Transact needs no network round trip: the change is queued like any save.
+func (s *SyncStore) Transact(f func([]model.Todo) ([]model.Todo, error)) error {
+	todos, err := s.Load()
+	if err != nil {
+		return err
+	}
+	todos, err = f(todos)
+	if err != nil {
+		return err
+	}
+	return s.Save(todos)
+}
+
+// Watch registers f, called with the local todos whenever changes from the
+// server are received.
+func (s *SyncStore) Watch(f func([]model.Todo)) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.next
+	s.next++
+	s.watchers[id] = f
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.watchers, id)
+	}
+}
+
+// OnStatus registers f, called with the current status and then whenever it
+// changes.
+func (s *SyncStore) OnStatus(f func(SyncStatus)) func() {
+	s.mu.Lock()
+	id := s.next
+	s.next++
+	s.onstatus[id] = f
+	st := s.status
+	s.mu.Unlock()
+
+	f(st)
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.onstatus, id)
+	}
+}
+
+func (s *SyncStore) setStatus(change func(st *SyncStatus)) {
+	s.mu.Lock()
+	old := s.status
+	change(&s.status)
+	st := s.status
+	fs := make([]func(SyncStatus), 0, len(s.onstatus))
+	for _, f := range s.onstatus {
+		fs = append(fs, f)
+	}
+	s.mu.Unlock()
+
+	if reflect.DeepEqual(old, st) {
+		return
+	}
+	for _, f := range fs {
+		f(st)
+	}
+}
+
+// received replaces the server todos, rebases the pending ops onto them and
+// notifies the watchers. acked are the ops that the server has applied, which
+// leave the outbox unless another tab already removed them.
+func (s *SyncStore) received(todos []model.Todo, etag string, acked []model.Op) {
+	s.mu.Lock()
+	before := s.local()
+	s.reload()
+	// A response may arrive after live changes that are more recent.
+	if etagRevision(etag) >= etagRevision(s.state.ETag) {
+		s.state.Server, s.state.ETag = todos, etag
+	}
+	s.dequeue(acked)
+	s.persist()
+	after := s.local()
+	pending := len(s.state.Ops)
+	fs := make([]func([]model.Todo), 0, len(s.watchers))
+	for _, f := range s.watchers {
+		fs = append(fs, f)
+	}
+	s.mu.Unlock()
+
+	s.setStatus(func(st *SyncStatus) {
+		st.Pending, st.Offline = pending, false
+		if len(acked) > 0 {
+			st.Err = nil
+		}
+	})
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+	for _, f := range fs {
+		f(after)
+	}
+}
+
+// dequeue removes ops from the head of the outbox. It is called with mu held.
+func (s *SyncStore) dequeue(ops []model.Op) {
+	if len(ops) <= len(s.state.Ops) && reflect.DeepEqual(s.state.Ops[:len(ops)], ops) {
+		s.state.Ops = s.state.Ops[len(ops):]
+	}
+}
+
+// rejectedError reports ops that the server refused and will never accept,
+// with the reason it gave.
+type rejectedError struct {
+	reason string
+	err    error
+}
+
+func (e *rejectedError) Error() string { return "changes rejected by the server: " + e.reason }
+func (e *rejectedError) Unwrap() error { return e.err }
+
+// rejected returns the rejectedError of a response refusing ops.
+func rejected(resp httpResponse, err error) *rejectedError {
+	var body struct {
+		Error string `json:"error"`
+	}
+	reason := "status " + strconv.Itoa(resp.Status)
+	if json.Unmarshal([]byte(resp.Body), &body) == nil && body.Error != "" {
+		reason = body.Error
+	}
+	return &rejectedError{reason, err}
+}
+
+// push sends the pending ops, or only the first one if single is set, and
+// returns them.
+func (s *SyncStore) push(single bool) ([]model.Op, error) {
+	s.mu.Lock()
+	s.reload()
+	ops := append([]model.Op(nil), s.state.Ops...)
+	s.mu.Unlock()
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	if single {
+		ops = ops[:1]
+	}
+
+	b, err := json.Marshal(struct {
+		Ops []model.Op `json:"ops"`
+	}{ops})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fetch("POST", s.remote.endpoint+"/ops", string(b), map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.Status == 200:
+	case resp.Status == 401, resp.Status == 403 && session.CSRF == "":
+		// Signed out, or never signed in when the app started offline: the
+		// ops wait for the user to sign in.
+		return nil, errSignedOut
+	case resp.Status == 403:
+		return ops, rejected(resp, errForbidden)
+	case resp.Status >= 400 && resp.Status < 500 && resp.Status != 408 && resp.Status != 429:
+		return ops, rejected(resp, nil)
+	default:
+		return nil, fmt.Errorf("POST %s/ops: status %d", s.remote.endpoint, resp.Status)
+	}
+
+	var page todosPage
+	if err := json.Unmarshal([]byte(resp.Body), &page); err != nil {
+		return nil, err
+	}
+	s.received(page.Todos, resp.Header("ETag"), ops)
+	return ops, nil
+}
+
+// pull fetches the server todos if they changed.
+func (s *SyncStore) pull() error {
+	s.mu.Lock()
+	etag := s.state.ETag
+	s.mu.Unlock()
+
+	todos, revision, changed, err := s.remote.load(etag)
+	if err != nil || !changed {
+		return err
+	}
+	s.received(todos, revision, nil)
+	return nil
+}
+
+// run pushes pending ops as soon as possible and otherwise polls the server.
+// Failures are retried with exponential backoff.
+//
+// When the server rejects the pending ops, they are sent again one at a time,
+// so that only the op it refuses is dropped. Ops refused for want of the right
+// to edit the list are all dropped at once.
+func (s *SyncStore) run() {
+	backoff := syncMinBackoff
+	var single bool
+	for {
+		sent, err := s.push(single)
+		if err == nil && len(sent) == 0 && !s.following() {
+			err = s.pull()
+		}
+		if len(sent) == 0 {
+			single = false
+		}
+
+		var r *rejectedError
+		var wait time.Duration
+		switch {
+		case errors.As(err, &r) && len(sent) > 1 && !errors.Is(err, errForbidden):
+			single = true
+			continue
+		case errors.As(err, &r):
+			// The ops are dropped and the server list wins.
+			log.Print(err)
+			single = false
+			s.mu.Lock()
+			s.reload()
+			s.dequeue(sent)
+			s.state.ETag = ""
+			s.persist()
+			pending := len(s.state.Ops)
+			s.mu.Unlock()
+			s.setStatus(func(st *SyncStatus) {
+				st.Pending, st.Rejected = pending, r.reason
+				if errors.Is(err, errForbidden) {
+					st.Err = errForbidden
+				}
+			})
+			continue
+		case errors.Is(err, errSignedOut):
+			s.mu.Lock()
+			pending := len(s.state.Ops)
+			s.mu.Unlock()
+			s.setStatus(func(st *SyncStatus) { st.Pending, st.Offline, st.Err = pending, false, err })
+			wait = syncMaxBackoff
+		case err != nil:
+			s.mu.Lock()
+			pending := len(s.state.Ops)
+			s.mu.Unlock()
+			s.setStatus(func(st *SyncStatus) { st.Pending, st.Offline = pending, true })
+			wait = backoff
+			backoff = min(2*backoff, syncMaxBackoff)
+		case len(sent) > 0:
+			backoff = syncMinBackoff
+			continue
+		default:
+			backoff = syncMinBackoff
+			wait = httpPollInterval
+		}
+
+		select {
+		case <-s.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// etagRevision returns the revision of the list in an ETag of the server, or
+// 0.
+func etagRevision(etag string) int64 {
+	n, _ := strconv.ParseInt(strings.Trim(strings.TrimPrefix(etag, "W/"), `"`), 10, 64)
+	return n
+}
+
+func revisionETag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+func (s *SyncStore) following() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.live
+}
+
+// OnViewers registers f, called with the viewers of the list, you being the
+// id of this client among them, whenever they change.
+func (s *SyncStore) OnViewers(f func(viewers []Viewer, you string)) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.next
+	s.next++
+	s.viewers[id] = f
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.viewers, id)
+	}
+}
+
+func (s *SyncStore) setViewers(viewers []Viewer, you string) {
+	s.mu.Lock()
+	fs := make([]func([]Viewer, string), 0, len(s.viewers))
+	for _, f := range s.viewers {
+		fs = append(fs, f)
+	}
+	s.mu.Unlock()
+	for _, f := range fs {
+		f(viewers, you)
+	}
+}
+
+// apply handles an event of the change feed. It returns false when a change
+// is missing, in which case the feed must be reopened to catch up.
+func (s *SyncStore) apply(e liveEvent) bool {
+	switch e.Type {
+	case "snapshot":
+		s.received(e.Todos, revisionETag(e.Revision), nil)
+	case "change":
+		s.mu.Lock()
+		s.reload()
+		current := etagRevision(s.state.ETag)
+		server := s.state.Server
+		s.mu.Unlock()
+		switch {
+		case e.Revision <= current:
+			// Already known, from our own push or a previous connection.
+		case e.Revision == current+1:
+			s.received(model.Apply(server, e.Ops), revisionETag(e.Revision), nil)
+		default:
+			return false
+		}
+	case "presence":
+		s.setViewers(e.Viewers, e.You)
+	case "error":
+		log.Print("live: ", e.Error)
+	}
+	return true
+}
+
+// follow keeps a live connection to the change feed of the list open through
+// the first transport that works, and reconnects with backoff when it is
+// lost. While connected, the server is not polled.
+func (s *SyncStore) follow(transports ...liveTransport) {
+	backoff := syncMinBackoff
+	for {
+		for _, t := range transports {
+			s.mu.Lock()
+			since := etagRevision(s.state.ETag)
+			s.mu.Unlock()
+
+			events, closefeed, err := t.Open(s.remote.endpoint, since)
+			if err != nil {
+				continue
+			}
+			backoff = syncMinBackoff
+			s.mu.Lock()
+			s.live = true
+			s.mu.Unlock()
+			for e := range events {
+				if !s.apply(e) {
+					closefeed()
+				}
+			}
+			s.mu.Lock()
+			s.live = false
+			s.mu.Unlock()
+			s.setViewers(nil, "")
+			s.poke()
+			break
+		}
+		time.Sleep(backoff)
+		backoff = min(2*backoff, syncMaxBackoff)
+	}
+}
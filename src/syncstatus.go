@@ -0,0 +1,58 @@
+package main
+
+import (
+	ui "github.com/atdiar/particleui"
+	doc "github.com/atdiar/particleui/drivers/js"
+)
+
+// SyncIndicator shows, in the footer, whether the changes made to the list
+// have reached the server.
+type SyncIndicator struct {
+	*ui.Element
+}
+
+func (s SyncIndicator) SetStatus(st SyncStatus) SyncIndicator {
+	state := "synced"
+	switch {
+	case st.Err != nil, st.Rejected != "":
+		state = "error"
+	case st.Offline:
+		state = "offline"
+	case st.Pending > 0:
+		state = "pending"
+	}
+	o := ui.NewObject()
+	o.Set("text", ui.String(st.String()))
+	o.Set("state", ui.String(state))
+	s.AsElement().SetUI("syncstatus", o.Commit())
+	return s
+}
+
+func SyncIndicatorFromRef(ref *ui.Element) SyncIndicator {
+	return SyncIndicator{ref}
+}
+
+func NewSyncIndicator(d *doc.Document, id string, options ...string) SyncIndicator {
+	return SyncIndicator{newsyncindicator(d, id, options...)}
+}
+
+func newsyncindicator(document *doc.Document, id string, options ...string) *ui.Element {
+	s := document.Span.WithID(id, options...)
+	doc.AddClass(s.AsElement(), "sync-status")
+	doc.SetInlineCSS(s.AsElement(), "display:none")
+
+	var previous string
+	s.AsElement().Watch("ui", "syncstatus", s.AsElement(), ui.OnMutation(func(evt ui.MutationEvent) bool {
+		st := evt.NewValue().(ui.Object)
+		state := string(st.MustGetString("state"))
+		if previous != "" {
+			doc.RemoveClass(s.AsElement(), "sync-"+previous)
+		}
+		doc.AddClass(s.AsElement(), "sync-"+state)
+		previous = state
+		s.SetText(string(st.MustGetString("text")))
+		doc.SetInlineCSS(s.AsElement(), "display:inline")
+		return false
+	}))
+	return s.AsElement()
+}
@@ -7,6 +7,8 @@ import (
 
 	ui "github.com/atdiar/particleui"
 	. "github.com/atdiar/particleui/drivers/js"
+
+	"github.com/atdiar/todomvc2/src/markdown"
 )
 
 func newIDgenerator(charlen int, seed int64) func() string {
@@ -44,9 +46,86 @@ func NewTodo(title ui.String) Todo {
 	o.Set("id", ui.String(NewID()))
 	o.Set("completed", ui.Bool(false))
 	o.Set("title", title)
+	o.Set("created", ui.String(time.Now().UTC().Format(time.RFC3339)))
 	return o.Commit()
 }
 
+// setCompleted returns a copy of the todo with the given completion state,
+// recording when it was completed.
+func setCompleted(t Todo, completed ui.Bool) Todo {
+	if v, ok := t.Get("completed"); ok && v.(ui.Bool) == completed {
+		return t
+	}
+	var at ui.String
+	if completed {
+		at = ui.String(time.Now().UTC().Format(time.RFC3339))
+	}
+	return t.MakeCopy().Set("completed", completed).Set("completedat", at).Commit()
+}
+
+// NewTodoFrom creates a todo from an Object holding a title and any of the
+// optional todo properties.
+func NewTodoFrom(fields ui.Object) Todo {
+	t := NewTodo(fields.MustGetString("title")).MakeCopy()
+	for _, k := range []string{"completed", "tags", "priority", "due", "list", "recurrence"} {
+		if v, ok := fields.Get(k); ok {
+			t.Set(k, v)
+		}
+	}
+	return t.Commit()
+}
+
+// Besides id, title and completed, a todo may hold the following optional
+// properties:
+//   - tags: a List of String
+//   - priority: a String, one of "high", "medium" or "low"
+//   - due: a String holding an ISO 8601 date or date-time
+//   - list: a String naming the list the todo belongs to
+//   - recurrence: a String holding an RFC 5545 recurrence rule
+//   - notes: a String holding Markdown text
+//   - checklist: a List of Objects with a "text" String and a "done" Bool
+//   - created, completedat: Strings holding ISO 8601 dates or date-times
+//   - updated: a String holding the RFC 3339 time of the last saved change
+//   - extensions: a List of Strings holding the extensions of imported
+//     todo.txt tasks (see model.Extensions)
+//   - ical: a List of Strings holding the content lines kept from imported
+//     iCalendar to-dos
+
+// TodoTags returns the tags of a todo.
+func TodoTags(t Todo) []string {
+	v, ok := t.Get("tags")
+	if !ok {
+		return nil
+	}
+	var tags []string
+	for _, tag := range v.(ui.List).UnsafelyUnwrap() {
+		tags = append(tags, string(tag.(ui.String)))
+	}
+	return tags
+}
+
+func addTag(t Todo, tag string) Todo {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+	if tag == "" {
+		return t
+	}
+	tags := ui.NewList()
+	for _, s := range TodoTags(t) {
+		if s == tag {
+			return t
+		}
+		tags = tags.Append(ui.String(s))
+	}
+	tags = tags.Append(ui.String(tag))
+	return t.MakeCopy().Set("tags", tags.Commit()).Commit()
+}
+
+// todoMeta returns a short description of the optional properties of a todo.
+// The server renders the same (see model.Todo.Meta).
+func todoMeta(t Todo) string {
+	return todoToModel(t).Meta()
+}
+
 type TodoElement struct {
 	*ui.Element
 }
@@ -74,6 +153,10 @@ func newtodo(document *Document, id string, options ...string) *ui.Element {
 	var i *ui.Element
 	var l *ui.Element
 	var b *ui.Element
+	var m *ui.Element
+	var d *ui.Element
+	var notes *ui.Element
+	var notesedit *ui.Element
 
 	t := E(document.Li.WithID(id, options...),
 		Ref(&li),
@@ -85,9 +168,17 @@ func newtodo(document *Document, id string, options ...string) *ui.Element {
 						Ref(&i),
 						Class("toggle"),
 					),
-					E(document.Label(),
+					E(document.Label.WithID(id+"-label"),
 						Ref(&l),
 					),
+					E(document.Span.WithID(id+"-meta"),
+						Ref(&m),
+						Class("meta"),
+					),
+					E(document.Button.WithID(id+"-details-btn", "button"),
+						Ref(&d),
+						Class("details-toggle"),
+					),
 					E(document.Button.WithID(id+"-btn", "button"),
 						Ref(&b),
 						Class("destroy"),
@@ -97,6 +188,24 @@ func newtodo(document *Document, id string, options ...string) *ui.Element {
 		),
 	)
 
+	details := E(document.Div.WithID(id+"-details"),
+		Class("details"),
+		Children(
+			E(document.Div.WithID(id+"-notes"),
+				Ref(&notes),
+				Class("notes"),
+			),
+			E(document.TextArea.WithID(id+"-notes-edit", 4, 50),
+				Ref(&notesedit),
+				Class("notes-edit"),
+			),
+			E(newChecklistEditor(document, id+"-checklist", li)),
+			E(newTodoHistory(document, id+"-history", li)),
+		),
+	)
+	SetAttribute(notesedit, "placeholder", "Notes (Markdown)")
+	details.ShareLifetimeOf(li.AsElement())
+
 	edit := document.Input.WithID(id+"-edit", "")
 	AddClass(edit.AsElement(), "edit")
 
@@ -114,6 +223,7 @@ func newtodo(document *Document, id string, options ...string) *ui.Element {
 		}
 
 		LabelElement{l}.SetText(string(titlestr))
+		SpanElement{m}.SetText(todoMeta(t))
 		edit.SetUI("value", titlestr)
 
 		todocomplete, ok := t.Get("completed")
@@ -130,26 +240,74 @@ func newtodo(document *Document, id string, options ...string) *ui.Element {
 
 		i.SetUI("checked", todocompletebool)
 
+		var notesstr ui.String
+		if n, ok := t.Get("notes"); ok {
+			notesstr = n.(ui.String)
+		}
+		// The rendered Markdown is sanitised: user input is escaped and only
+		// safe links are kept.
+		SetInnerHTML(notes, markdown.Render(string(notesstr)))
+		notesedit.SetUI("value", notesstr)
+
 		return false
 	}))
 
-	li.WatchEvent("toggle", li, ui.OnMutation(func(evt ui.MutationEvent) bool {
-		res, ok := evt.Origin().Get("ui", "todo")
+	li.Watch("ui", "expanded", li, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		if evt.NewValue().(ui.Bool) {
+			AddClass(li.AsElement(), "expanded")
+			li.AsElement().AppendChild(details)
+		} else {
+			RemoveClass(li.AsElement(), "expanded")
+			li.AsElement().RemoveChild(details)
+		}
+		return false
+	}))
+
+	li.WatchEvent("newnotes", li, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		res, ok := li.GetData("todo")
 		if !ok {
-			panic("Cannot find corresponding todo element.")
+			panic("todo data should be present")
 		}
-		todo := res.(ui.Object)
+		todo := res.(Todo)
+		todo = todo.MakeCopy().Set("notes", evt.NewValue()).Commit()
+		li.SetDataSetUI("todo", todo)
+		return false
+	}))
 
-		b, ok := todo.Get("completed")
+	li.WatchEvent("newchecklist", li, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		res, ok := li.GetData("todo")
 		if !ok {
-			panic("wrong todo format. Should have completed property")
+			panic("todo data should be present")
 		}
-		complete := !(b.(ui.Bool))
+		todo := res.(Todo)
+		todo = todo.MakeCopy().Set("checklist", evt.NewValue()).Commit()
+		li.SetDataSetUI("todo", todo)
+		return false
+	}))
 
-		todo = todo.MakeCopy().Set("completed", ui.Bool(complete)).Commit()
+	li.Watch("ui", "selected", li, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		if evt.NewValue().(ui.Bool) {
+			AddClass(li.AsElement(), "selected")
+		} else {
+			RemoveClass(li.AsElement(), "selected")
+		}
+		return false
+	}))
 
-		evt.Origin().SetDataSetUI("todo", todo)
+	// Read-only todos can be looked at, details included, but not changed.
+	readonly := func() bool {
+		v, ok := li.AsElement().GetUI("readonly")
+		return ok && bool(v.(ui.Bool))
+	}
 
+	li.Watch("ui", "readonly", li, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		if !evt.NewValue().(ui.Bool) {
+			RemoveClass(li.AsElement(), "readonly")
+			return false
+		}
+		AddClass(li.AsElement(), "readonly")
+		SetAttribute(i, "disabled", "")
+		SetAttribute(notesedit, "readonly", "")
 		return false
 	}))
 
@@ -187,20 +345,63 @@ func newtodo(document *Document, id string, options ...string) *ui.Element {
 
 	i.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
 		//evt.PreventDefault()
+		if readonly() {
+			evt.PreventDefault()
+			return false
+		}
 		li.TriggerEvent("toggle")
 		return false
 	}))
 
+	l.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		e, ok := evt.(MouseEvent)
+		if !ok {
+			return false
+		}
+		if e.ShiftKey() {
+			evt.PreventDefault()
+			li.AsElement().TriggerEvent("select", ui.String("range"))
+		} else if e.CtrlKey() || e.MetaKey() {
+			evt.PreventDefault()
+			li.AsElement().TriggerEvent("select", ui.String("toggle"))
+		}
+		return false
+	}))
+
 	l.AsElement().AddEventListener("dblclick", ui.NewEventHandler(func(evt ui.Event) bool {
+		if readonly() {
+			return false
+		}
 		li.AsElement().TriggerEvent("edit", ui.Bool(true))
 		return false
 	}))
 
 	b.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		if readonly() {
+			return false
+		}
 		li.AsElement().TriggerEvent("delete", ui.Bool(true))
 		return false
 	}))
 
+	d.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		var expanded ui.Bool
+		if v, ok := li.AsElement().Get("ui", "expanded"); ok {
+			expanded = v.(ui.Bool)
+		}
+		li.AsElement().SetUI("expanded", !expanded)
+		return false
+	}))
+
+	notesedit.AddEventListener("change", ui.NewEventHandler(func(evt ui.Event) bool {
+		v, ok := evt.Value().(ui.Object).Get("value")
+		if !ok {
+			return false
+		}
+		li.AsElement().TriggerEvent("newnotes", ui.String(strings.TrimSpace(string(v.(ui.String)))))
+		return false
+	}))
+
 	edit.AsElement().AddEventListener("change", ui.NewEventHandler(func(evt ui.Event) bool {
 
 		v, ok := evt.Value().(ui.Object).Get("value")
@@ -256,16 +457,11 @@ func newtodo(document *Document, id string, options ...string) *ui.Element {
 		return false
 	}))
 
+	// Toggling and renaming are domain events of the list (see
+	// TodosListElement.Dispatch): the todo element only triggers them.
 	li.AsElement().WatchEvent("newtitle", edit, ui.OnMutation(func(evt ui.MutationEvent) bool {
-		res, ok := li.AsElement().GetData("todo")
-		if !ok {
-			panic("todo data should be present")
-		}
-		todo := res.(Todo)
-
-		todo = todo.MakeCopy().Set("title", evt.NewValue()).Commit()
-		li.AsElement().SetDataSetUI("todo", todo)
 		edit.AsElement().TriggerEvent("edit", ui.Bool(false))
+		li.AsElement().TriggerEvent("newtitle", evt.NewValue())
 		return false
 	}))
 
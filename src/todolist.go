@@ -1,8 +1,16 @@
 package main
 
 import (
+	"errors"
+	"log"
+	"reflect"
+	"time"
+
 	. "github.com/atdiar/particleui"
 	doc "github.com/atdiar/particleui/drivers/js"
+
+	"github.com/atdiar/todomvc2/src/eventlog"
+	"github.com/atdiar/todomvc2/src/model"
 )
 
 type TodosListElement struct {
@@ -27,6 +35,321 @@ func (t TodosListElement) SetList(tdl List) TodosListElement {
 	return t
 }
 
+// SetReadOnly prevents the todos from being edited, toggled or deleted, for
+// lists that the user can only look at.
+func (t TodosListElement) SetReadOnly(readonly bool) TodosListElement {
+	t.AsElement().SetUI("readonly", Bool(readonly))
+	return t
+}
+
+// errReadOnly is returned by the changes of a read-only list.
+var errReadOnly = errors.New("the list is read-only")
+
+// ReadOnly reports whether the list can only be looked at.
+func (t TodosListElement) ReadOnly() bool {
+	v, ok := t.AsElement().GetUI("readonly")
+	return ok && bool(v.(Bool))
+}
+
+// visibleIDs returns the ids of the todos that the current filter lets through,
+// in display order.
+func (t TodosListElement) visibleIDs() []string {
+	filter := "all"
+	if f, ok := t.AsElement().Get("ui", "filter"); ok {
+		filter = string(f.(String))
+	}
+	var ids []string
+	t.GetList().Range(func(i int, v Value) bool {
+		o := v.(Todo)
+		if displayWhen(filter)(o) {
+			ids = append(ids, string(o.MustGetString("id")))
+		}
+		return false
+	})
+	return ids
+}
+
+// Selection returns the ids of the selected todos that are still in the list.
+func (t TodosListElement) Selection() []string {
+	res, ok := t.AsElement().Get("ui", "selection")
+	if !ok {
+		return nil
+	}
+	selected := make(map[string]bool)
+	for _, v := range res.(List).UnsafelyUnwrap() {
+		selected[string(v.(String))] = true
+	}
+	var ids []string
+	for _, v := range t.GetList().UnsafelyUnwrap() {
+		id := string(v.(Todo).MustGetString("id"))
+		if selected[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (t TodosListElement) SetSelection(ids ...string) TodosListElement {
+	l := NewList()
+	for _, id := range ids {
+		l = l.Append(String(id))
+	}
+	t.AsElement().SetUI("selection", l.Commit())
+	return t
+}
+
+func (t TodosListElement) ClearSelection() TodosListElement {
+	if len(t.Selection()) == 0 {
+		return t
+	}
+	return t.SetSelection()
+}
+
+// ToggleSelected adds or removes a todo from the selection. The todo becomes the
+// anchor of any subsequent range selection.
+func (t TodosListElement) ToggleSelected(id string) TodosListElement {
+	var ids []string
+	var found bool
+	for _, s := range t.Selection() {
+		if s == id {
+			found = true
+			continue
+		}
+		ids = append(ids, s)
+	}
+	if !found {
+		ids = append(ids, id)
+	}
+	t.AsElement().SetUI("selectionanchor", String(id))
+	return t.SetSelection(ids...)
+}
+
+// SelectRange selects every visible todo between the selection anchor and the
+// given todo, both included.
+func (t TodosListElement) SelectRange(id string) TodosListElement {
+	a, ok := t.AsElement().Get("ui", "selectionanchor")
+	if !ok {
+		return t.ToggleSelected(id)
+	}
+	anchor := string(a.(String))
+
+	visible := t.visibleIDs()
+	start, end := -1, -1
+	for i, v := range visible {
+		if v == anchor {
+			start = i
+		}
+		if v == id {
+			end = i
+		}
+	}
+	if start < 0 || end < 0 {
+		return t.ToggleSelected(id)
+	}
+	if start > end {
+		start, end = end, start
+	}
+
+	ids := t.Selection()
+	for _, v := range visible[start : end+1] {
+		if !containsString(ids, v) {
+			ids = append(ids, v)
+		}
+	}
+	return t.SetSelection(ids...)
+}
+
+func containsString(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatch records domain events in the event journal and sets the list to
+// the state of the log they lead to, or, when the journal cannot be written,
+// to the result of the reducer of package eventlog. Events that change nothing
+// are not recorded. The events of a dispatch are undone together.
+func (t TodosListElement) Dispatch(events ...eventlog.Event) TodosListElement {
+	if t.ReadOnly() {
+		return t
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	todos := listToModel(t.GetList())
+	var changes []eventlog.Event
+	for _, e := range events {
+		e.Time = now
+		next := eventlog.Reduce(todos, e)
+		if reflect.DeepEqual(next, todos) {
+			continue
+		}
+		todos = next
+		changes = append(changes, e)
+	}
+	if len(changes) == 0 {
+		return t
+	}
+	if derived, err := journal.Append(changes...); err != nil {
+		log.Print("unable to record events: ", err)
+	} else {
+		todos = derived
+	}
+	t.Checkpoint()
+	return t.SetList(listFromModel(todos))
+}
+
+// Preview shows todos in place of the list without saving them nor recording
+// them in the event journal, and makes the list read-only, until EndPreview.
+// It lets the time-travel inspector show past states of the list.
+func (t TodosListElement) Preview(todos List) TodosListElement {
+	if !t.Previewing() {
+		t.AsElement().SetUI("previewfrom", t.GetList())
+		t.AsElement().SetUI("previewreadonly", Bool(t.ReadOnly()))
+		t.AsElement().SetUI("preview", Bool(true))
+		t.SetReadOnly(true)
+	}
+	return t.SetList(todos)
+}
+
+// Previewing reports whether the list shows a preview.
+func (t TodosListElement) Previewing() bool {
+	v, ok := t.AsElement().GetUI("preview")
+	return ok && bool(v.(Bool))
+}
+
+// EndPreview shows the list again as it was before the preview.
+func (t TodosListElement) EndPreview() TodosListElement {
+	if !t.Previewing() {
+		return t
+	}
+	from, _ := t.AsElement().GetUI("previewfrom")
+	readonly, _ := t.AsElement().GetUI("previewreadonly")
+	t.SetList(from.(List))
+	t.AsElement().SetUI("preview", Bool(false))
+	t.SetReadOnly(bool(readonly.(Bool)))
+	return t
+}
+
+const maxUndoSteps = 50
+
+// Checkpoint records the current list so that the next change can be reverted
+// as a single step with Undo. Dispatch and the changes made to a todo by its
+// element checkpoint on their own; other changes of the list call it first.
+func (t TodosListElement) Checkpoint() TodosListElement {
+	var steps []Value
+	if h, ok := t.AsElement().Get("ui", "history"); ok {
+		steps = h.(List).UnsafelyUnwrap()
+	}
+	steps = append(steps, t.GetList())
+	if len(steps) > maxUndoSteps {
+		steps = steps[len(steps)-maxUndoSteps:]
+	}
+	t.AsElement().SetUI("history", NewListFrom(steps))
+	return t
+}
+
+// Undo restores the list recorded by the last Checkpoint. It returns false when
+// there is nothing to undo.
+func (t TodosListElement) Undo() bool {
+	if t.ReadOnly() {
+		return false
+	}
+	h, ok := t.AsElement().Get("ui", "history")
+	if !ok {
+		return false
+	}
+	steps := h.(List).UnsafelyUnwrap()
+	if len(steps) == 0 {
+		return false
+	}
+	prev := steps[len(steps)-1].(List)
+	t.AsElement().SetUI("history", NewListFrom(steps[:len(steps)-1]))
+
+	// Todo elements that were deleted since the checkpoint are recreated by the
+	// todoslist watcher.
+	t.SetList(prev)
+	return true
+}
+
+// bulkActions are the actions ApplyBulk supports.
+var bulkActions = map[string]bool{
+	"complete": true, "reopen": true, "delete": true, "tag": true, "priority": true, "due": true, "move": true,
+}
+
+// Import replaces the todos with the imported ones, as a single, undoable
+// change of the list.
+func (t TodosListElement) Import(todos List) error {
+	if t.ReadOnly() {
+		return errReadOnly
+	}
+	t.Checkpoint()
+	t.SetList(todos)
+	return nil
+}
+
+// ApplyBulk applies an action to every selected todo and commits the result as
+// a single, undoable change of the list.
+// Supported actions are "complete", "reopen", "delete", "tag", "priority", "due"
+// and "move". The tag, priority, due date and list name are passed as a String.
+func (t TodosListElement) ApplyBulk(action string, arg Value) error {
+	if t.ReadOnly() {
+		return errReadOnly
+	}
+	if !bulkActions[action] {
+		return errors.New("unknown bulk action: " + action)
+	}
+	selection := t.Selection()
+	if len(selection) == 0 {
+		return nil
+	}
+	selected := make(map[string]bool)
+	for _, id := range selection {
+		selected[id] = true
+	}
+
+	t.Checkpoint()
+
+	tdl := t.GetList()
+	ntdl := NewList()
+	for _, rawtodo := range tdl.UnsafelyUnwrap() {
+		todo := rawtodo.(Todo)
+		if !selected[string(todo.MustGetString("id"))] {
+			ntdl = ntdl.Append(todo)
+			continue
+		}
+
+		switch action {
+		case "complete":
+			todo = setCompleted(todo, Bool(true))
+		case "reopen":
+			todo = setCompleted(todo, Bool(false))
+		case "delete":
+			e, ok := FindTodoElement(doc.GetDocument(t.AsElement()), todo)
+			if ok {
+				Delete(e.AsElement())
+			}
+			continue
+		case "tag":
+			todo = addTag(todo, string(arg.(String)))
+		case "priority":
+			todo = todo.MakeCopy().Set("priority", arg).Commit()
+		case "due":
+			todo = todo.MakeCopy().Set("due", arg).Commit()
+		case "move":
+			todo = todo.MakeCopy().Set("list", arg).Commit()
+		}
+		ntdl = ntdl.Append(todo)
+	}
+
+	if action == "delete" {
+		t.ClearSelection()
+	}
+	t.SetList(ntdl.Commit())
+	return nil
+}
+
 func TodoListFromRef(ref *Element) TodosListElement {
 	return TodosListElement{ref}
 }
@@ -54,21 +377,28 @@ func displayWhen(filter string) func(Value) bool {
 			}
 			return true
 		}
+
+		// The activity view shows the history of the list instead of its
+		// todos.
+		if filter == "activity" {
+			return false
+		}
 		return true
 	}
 }
 
-func newTodoListElement(document *doc.Document, id string, options ...string) *Element {
+func newTodoListElement(document *doc.Document, id string, store TodoStore, options ...string) *Element {
 	t := document.Ul.WithID(id, options...)
 	doc.AddClass(t.AsElement(), "todo-list")
 
-	tview := NewViewElement(t.AsElement(), NewView("all"), NewView("active"), NewView("completed"))
+	tview := NewViewElement(t.AsElement(), NewView("all"), NewView("active"), NewView("completed"), NewView("activity"))
 	t.OnRouterMounted(func(r *Router) {
-		names := NewList(String("all"), String("active"), String("completed")).Commit()
+		names := NewList(String("all"), String("active"), String("completed"), String("activity")).Commit()
 		links := NewList(
 			String(r.NewLink("all").URI()),
 			String(r.NewLink("active").URI()),
 			String(r.NewLink("completed").URI()),
+			String(r.NewLink("activity").URI()),
 		).Commit()
 		filterslist := NewObject()
 		filterslist.Set("names", names)
@@ -78,6 +408,7 @@ func newTodoListElement(document *doc.Document, id string, options ...string) *E
 	})
 
 	tview.AsElement().Watch("ui", "filter", tview, OnMutation(func(evt MutationEvent) bool {
+		TodosListElement{evt.Origin()}.ClearSelection()
 		evt.Origin().TriggerEvent("renderlist")
 		return false
 	}))
@@ -91,6 +422,11 @@ func newTodoListElement(document *doc.Document, id string, options ...string) *E
 			if !ok {
 				ntd = TodosListElement{evt.Origin()}.NewTodo(o)
 			} else {
+				// Unchanged todos are skipped so that a list-wide commit does not
+				// bounce back from every todo element as a separate commit.
+				if old, ok := ntd.GetData("todo"); ok && Equal(old, o) {
+					continue
+				}
 				ntd.SetDataSetUI("todo", o)
 			}
 		}
@@ -117,6 +453,39 @@ func newTodoListElement(document *doc.Document, id string, options ...string) *E
 		//evt.Origin().TriggerEvent("renderlist")
 		return false
 	}))
+	tview.OnActivated("activity", OnMutation(func(evt MutationEvent) bool {
+		evt.Origin().SetUI("filter", String("activity"))
+		doc.GetDocument(evt.Origin()).Window().SetTitle("TODOMVC-activity")
+		return false
+	}))
+
+	t.AsElement().Watch("ui", "selection", t, OnMutation(func(evt MutationEvent) bool {
+		tlist := TodosListElement{evt.Origin()}
+		selected := make(map[string]bool)
+		for _, id := range tlist.Selection() {
+			selected[id] = true
+		}
+		for _, v := range tlist.GetList().UnsafelyUnwrap() {
+			o := v.(Todo)
+			ntd, ok := FindTodoElement(doc.GetDocument(evt.Origin()), o)
+			if !ok {
+				continue
+			}
+			ntd.SetUI("selected", Bool(selected[string(o.MustGetString("id"))]))
+		}
+		return false
+	}))
+
+	t.AsElement().Watch("ui", "readonly", t, OnMutation(func(evt MutationEvent) bool {
+		tlist := TodosListElement{evt.Origin()}
+		for _, v := range tlist.GetList().UnsafelyUnwrap() {
+			ntd, ok := FindTodoElement(doc.GetDocument(evt.Origin()), v.(Todo))
+			if ok {
+				ntd.AsElement().SetUI("readonly", evt.NewValue())
+			}
+		}
+		return false
+	}))
 
 	t.WatchEvent("renderlist", t, OnMutation(func(evt MutationEvent) bool {
 		t := evt.Origin()
@@ -157,16 +526,104 @@ func newTodoListElement(document *doc.Document, id string, options ...string) *E
 		return false
 	}))
 
+	// Persistence: every change of the list is saved to the store, and
+	// recorded in the activity log. Changes made to the store by other
+	// writers, such as other tabs, are merged into the list todo by todo. base
+	// is the last list known to be in the store.
+	//
+	// The event journal records every change that was not dispatched as a
+	// domain event, those of other writers included.
+	save := persister(store)
+	var loading bool
+	var base []model.Todo
+
+	t.AsElement().Watch("data", "todoslist", t, OnMutation(func(evt MutationEvent) bool {
+		if TodoListFromRef(t.AsElement()).Previewing() {
+			return false
+		}
+		cause := eventlog.CauseEdit
+		if loading {
+			cause = eventlog.CauseRemote
+		}
+		if err := journal.Sync(listToModel(evt.NewValue().(List)), cause, time.Now()); err != nil {
+			log.Print("unable to record events: ", err)
+		}
+		if !loading {
+			todos := listToModel(evt.NewValue().(List))
+			now := time.Now()
+			model.Touch(base, todos, now)
+			if err := activity.Record(model.History(base, todos, session.User, now)); err != nil {
+				log.Print("unable to record the activity: ", err)
+			}
+			save(base, todos)
+			base = todos
+		}
+		return false
+	}))
+
+	// editing returns the ids of the todos being edited, which keep their
+	// local version when changes are merged: todos whose title is edited, and
+	// todos whose details are open, which holds the notes and checklist
+	// editors, unless they are read-only.
+	editing := func(tlist TodosListElement) map[string]bool {
+		ids := make(map[string]bool)
+		for _, v := range tlist.GetList().UnsafelyUnwrap() {
+			o := v.(Todo)
+			ntd, ok := FindTodoElement(doc.GetDocument(tlist.AsElement()), o)
+			if !ok {
+				continue
+			}
+			e := ntd.AsElement()
+			if m, ok := e.GetUI("editmode"); ok && bool(m.(Bool)) {
+				ids[string(o.MustGetString("id"))] = true
+			}
+			if x, ok := e.GetUI("expanded"); ok && bool(x.(Bool)) {
+				if r, ok := e.GetUI("readonly"); !ok || !bool(r.(Bool)) {
+					ids[string(o.MustGetString("id"))] = true
+				}
+			}
+		}
+		return ids
+	}
+
+	load := func(todos []model.Todo) {
+		tlist := TodosListElement{t.AsElement()}
+		// Changes of other writers end previews.
+		tlist.EndPreview()
+		local := listToModel(tlist.GetList())
+		merged := model.MergeLists(base, local, todos, editing(tlist))
+		base = todos
+		l := listFromModel(merged)
+		if Equal(tlist.GetList(), l) {
+			return
+		}
+		loading = true
+		tlist.SetList(l)
+		loading = false
+	}
+
+	todos, err := store.Load()
+	if err != nil {
+		log.Print("unable to load todos: ", err)
+	} else {
+		load(todos)
+	}
+	store.Watch(load)
+
 	return t.AsElement()
 }
 
-func NewTodoList(d *doc.Document, id string, options ...string) TodosListElement {
-	return TodosListElement{newTodoListElement(d, id, options...)}
+// NewTodoList returns the list of todos, persisted in store.
+func NewTodoList(d *doc.Document, id string, store TodoStore, options ...string) TodosListElement {
+	return TodosListElement{newTodoListElement(d, id, store, options...)}
 }
 
 func (t TodosListElement) NewTodo(o Todo) TodoElement {
 
 	ntd := newTodoElement(doc.GetDocument(t.AsElement()), o)
+	if t.ReadOnly() {
+		ntd.AsElement().SetUI("readonly", Bool(true))
+	}
 	id, _ := o.Get("id")
 	idstr := id.(String)
 
@@ -181,47 +638,61 @@ func (t TodosListElement) NewTodo(o Todo) TodoElement {
 
 		newval := evt.NewValue()
 
-		rawlist := tdl.UnsafelyUnwrap()
-
-		for i, rawtodo := range rawlist {
+		for i, rawtodo := range tdl.UnsafelyUnwrap() {
 			todo := rawtodo.(Todo)
 			oldid, _ := todo.Get("id")
 
 			if oldid == idstr {
-				rawlist[i] = newval
-				t.SetList(NewListFrom(rawlist))
+				if Equal(rawtodo, newval) {
+					break
+				}
+				ntdl := tdl.MakeCopy()
+				ntdl.Set(i, newval)
+				t.Checkpoint()
+				t.SetList(ntdl.Commit())
 				break
 			}
 		}
 		return false
 	}))
 
-	t.WatchEvent("delete", ntd, OnMutation(func(evt MutationEvent) bool {
-		var tdl List
-		res, ok := t.AsElement().GetUI("todoslist")
-		if !ok {
-			tdl = NewList().Commit()
+	t.WatchEvent("select", ntd, OnMutation(func(evt MutationEvent) bool {
+		mode := evt.NewValue().(String)
+		if mode == "range" {
+			t.SelectRange(string(idstr))
 		} else {
-			tdl = res.(List)
+			t.ToggleSelected(string(idstr))
 		}
-		ntdl := NewList()
-		var i int
+		return false
+	}))
 
-		for _, rawtodo := range tdl.UnsafelyUnwrap() {
-			todo := rawtodo.(Todo)
-			oldid, _ := todo.Get("id")
-			if oldid == idstr {
-				t, ok := FindTodoElement(doc.GetDocument(evt.Origin()), rawtodo.(Todo))
-				if ok {
-					Delete(t.AsElement())
-				}
-				continue
-			}
-			ntdl = ntdl.Append(rawtodo)
-			i++
+	t.WatchEvent("toggle", ntd, OnMutation(func(evt MutationEvent) bool {
+		t.Dispatch(eventlog.Event{Type: eventlog.Toggle, ID: string(idstr)})
+		return false
+	}))
+
+	t.WatchEvent("newtitle", ntd, OnMutation(func(evt MutationEvent) bool {
+		title := string(evt.NewValue().(String))
+		if title == "" {
+			evt.Origin().TriggerEvent("delete")
+			return false
 		}
+		t.Dispatch(eventlog.Event{Type: eventlog.NewTitle, ID: string(idstr), Title: title})
+		return false
+	}))
 
-		t.SetList(ntdl.Commit())
+	// The element is only removed once the todo is gone from the list.
+	t.WatchEvent("delete", ntd, OnMutation(func(evt MutationEvent) bool {
+		if t.ReadOnly() {
+			return false
+		}
+		t.Dispatch(eventlog.Event{Type: eventlog.Delete, ID: string(idstr)})
+		for _, v := range t.GetList().UnsafelyUnwrap() {
+			if v.(Todo).MustGetString("id") == idstr {
+				return false
+			}
+		}
+		Delete(evt.Origin())
 		return false
 	}))
 
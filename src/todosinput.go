@@ -1,12 +1,43 @@
 package main
 
 import (
+	"strconv"
 	"strings"
+	"time"
 
 	ui "github.com/atdiar/particleui"
 	doc "github.com/atdiar/particleui/drivers/js"
+	js "github.com/atdiar/particleui/drivers/js/compat"
+
+	"github.com/atdiar/todomvc2/src/quickadd"
 )
 
+// parseQuickAdd turns the text of the new todo input into an Object holding the
+// title of the todo and the optional properties found in the text.
+func parseQuickAdd(s string) ui.Object {
+	r := quickadd.Parse(s, time.Now())
+
+	o := ui.NewObject()
+	o.Set("title", ui.String(r.Title))
+	if due := r.DueString(); due != "" {
+		o.Set("due", ui.String(due))
+	}
+	if len(r.Tags) > 0 {
+		tags := ui.NewList()
+		for _, tag := range r.Tags {
+			tags = tags.Append(ui.String(tag))
+		}
+		o.Set("tags", tags.Commit())
+	}
+	if r.Priority != "" {
+		o.Set("priority", ui.String(r.Priority))
+	}
+	if r.Recurrence != "" {
+		o.Set("recurrence", ui.String(r.Recurrence))
+	}
+	return o.Commit()
+}
+
 func NewTodoInput(document *doc.Document, id string, options ...string) doc.InputElement {
 	todosinput := document.Input.WithID(id, "text", options...)
 	doc.SetAttribute(todosinput.AsElement(), "placeholder", "What needs to be done?")
@@ -26,6 +57,56 @@ func NewTodoInput(document *doc.Document, id string, options ...string) doc.Inpu
 		return false
 	}))
 
+	todosinput.AsElement().AddEventListener("input", ui.NewEventHandler(func(evt ui.Event) bool {
+		v, ok := evt.Value().(ui.Object).Get("value")
+		if !ok {
+			return false
+		}
+		evt.CurrentTarget().SetUI("quickadd", parseQuickAdd(string(v.(ui.String))))
+		return false
+	}))
+
+	// Pasting several lines offers to create one todo per line instead of a
+	// single todo out of the whole text. The value the input would have had
+	// is kept in "pastedvalue", should the text be pasted as one after all.
+	todosinput.AsElement().AddEventListener("paste", ui.NewEventHandler(func(evt ui.Event) bool {
+		native, ok := evt.Native().(js.Value)
+		if !ok {
+			return false
+		}
+		data := native.Get("clipboardData")
+		if data.IsUndefined() || data.IsNull() {
+			return false
+		}
+		text := data.Call("getData", "text").String()
+		lines := quickadd.ParseLines(text)
+		if len(lines) < 2 {
+			return false
+		}
+		evt.PreventDefault()
+
+		// Selection offsets count UTF-16 code units, as slice does.
+		target := native.Get("target")
+		value := target.Get("value")
+		before, after := value.String(), ""
+		if start := target.Get("selectionStart"); !start.IsUndefined() && !start.IsNull() {
+			before = value.Call("slice", 0, start).String()
+			after = value.Call("slice", target.Get("selectionEnd")).String()
+		}
+		evt.CurrentTarget().SetUI("pastedvalue", ui.String(before+text+after))
+
+		todos := ui.NewList()
+		for _, l := range lines {
+			fields := parseQuickAdd(l.Text).MakeCopy().Set("completed", ui.Bool(l.Done)).Commit()
+			if fields.MustGetString("title") == "" {
+				continue
+			}
+			todos = todos.Append(fields)
+		}
+		evt.CurrentTarget().SetUI("pasted", todos.Commit())
+		return false
+	}))
+
 	todosinput.AsElement().AddEventListener("keyup", ui.NewEventHandler(func(evt ui.Event) bool {
 		todosinput := doc.InputElement{evt.CurrentTarget()}
 
@@ -39,11 +120,13 @@ func NewTodoInput(document *doc.Document, id string, options ...string) doc.Inpu
 				return false
 			}
 
-			if !ui.Equal(val, ui.String("")) {
-				todosinput.TriggerEvent("newtodo", val)
+			fields := parseQuickAdd(strings.TrimSpace(string(val.(ui.String))))
+			if fields.MustGetString("title") != "" {
+				todosinput.TriggerEvent("newtodo", fields)
 			}
 			//todosinput.SyncUISetData("value", ui.String(""))
 			todosinput.Clear()
+			todosinput.SetUI("quickadd", parseQuickAdd(""))
 
 			// todo: apply mutation to state and watch state instead of todo event?
 			// require clearing state because of idempotency.
@@ -53,3 +136,79 @@ func NewTodoInput(document *doc.Document, id string, options ...string) doc.Inpu
 
 	return todosinput
 }
+
+// NewQuickAddPreview returns an element that displays the fields parsed from the
+// text being typed into the new todo input.
+func NewQuickAddPreview(document *doc.Document, id string, input doc.InputElement, options ...string) *ui.Element {
+	p := document.Div.WithID(id, options...)
+	doc.AddClass(p.AsElement(), "quickadd-preview")
+
+	p.AsElement().Watch("ui", "quickadd", input, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		fields := evt.NewValue().(ui.Object)
+		meta := todoMeta(fields)
+		if meta == "" {
+			doc.SetInlineCSS(p.AsElement(), "display:none")
+			return false
+		}
+		doc.SetInlineCSS(p.AsElement(), "display:block")
+		p.SetText(string(fields.MustGetString("title")) + "  " + meta)
+		return false
+	}))
+	doc.SetInlineCSS(p.AsElement(), "display:none")
+
+	return p.AsElement()
+}
+
+// NewPastePrompt returns an element that asks whether the lines pasted into the
+// new todo input should become separate todos. On confirmation, the input
+// triggers a "newtodos" event holding the List of todo fields. Otherwise, the
+// text is pasted as it was copied, which the input turns into a single line.
+func NewPastePrompt(document *doc.Document, id string, input doc.InputElement, options ...string) *ui.Element {
+	p := document.Div.WithID(id, options...)
+	doc.AddClass(p.AsElement(), "paste-prompt")
+	doc.SetInlineCSS(p.AsElement(), "display:none")
+
+	msg := document.Span.WithID(id + "-msg")
+	create := document.Button.WithID(id+"-create", "button")
+	create.SetText("Create todos")
+	single := document.Button.WithID(id+"-single", "button")
+	single.SetText("Paste as one")
+
+	pasted := func() ui.List {
+		v, ok := input.AsElement().Get("ui", "pasted")
+		if !ok {
+			return ui.NewList().Commit()
+		}
+		return v.(ui.List)
+	}
+
+	create.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		input.TriggerEvent("newtodos", pasted())
+		input.SetUI("pasted", ui.NewList().Commit())
+		return false
+	}))
+
+	single.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		if v, ok := input.AsElement().Get("ui", "pastedvalue"); ok {
+			input.SetUI("value", v)
+		}
+		input.SetUI("pasted", ui.NewList().Commit())
+		input.Focus()
+		return false
+	}))
+
+	p.AsElement().SetChildren(msg.AsElement(), create.AsElement(), single.AsElement())
+
+	p.AsElement().Watch("ui", "pasted", input, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		n := len(evt.NewValue().(ui.List).UnsafelyUnwrap())
+		if n == 0 {
+			doc.SetInlineCSS(p.AsElement(), "display:none")
+			return false
+		}
+		doc.SetInlineCSS(p.AsElement(), "display:block")
+		msg.SetText("Create " + strconv.Itoa(n) + " todos from the pasted lines?")
+		return false
+	}))
+
+	return p.AsElement()
+}
@@ -0,0 +1,369 @@
+// Package todotxt reads and writes the todo.txt format
+// (https://github.com/todotxt/todo.txt).
+//
+// Tasks map to todos as follows:
+//   - "x" and the completion date map to Completed and CompletedAt
+//   - the creation date maps to Created
+//   - priorities (A), (B) and (C) map to high, medium and low. Lower
+//     priorities also map to low, the letter being kept in a pri: extension
+//   - +project and @context map to tags, in order, contexts keeping their @
+//     prefix. Those followed by words of the description stay in the title
+//   - the first due:, rec:, list: and id: map to Due, Recurrence, List and ID
+//
+// Every extension is kept in Extensions, as written, and written back in the
+// same order after the description and tags, those mapped to a field with its
+// current value. Fields that were not read from an extension follow them: ID
+// does not, so that the ids given to imported todos are not exported.
+//
+// A description made of projects and contexts only is also the title of the
+// todo, and one made of extensions only is the title alone.
+package todotxt
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+var letters = map[string]string{"A": "high", "B": "medium", "C": "low"}
+var priorityLetters = map[string]string{"high": "A", "medium": "B", "low": "C"}
+
+func isDate(s string) bool {
+	_, err := time.Parse("2006-01-02", s)
+	return err == nil
+}
+
+func isLetter(s string) bool {
+	return len(s) == 1 && s[0] >= 'A' && s[0] <= 'Z'
+}
+
+func isPriority(s string) bool {
+	return len(s) == 3 && s[0] == '(' && s[2] == ')' && isLetter(s[1:2])
+}
+
+// extension splits a key:value token. URLs are not extensions.
+func extension(tok string) (key string, value string, ok bool) {
+	key, value, ok = strings.Cut(tok, ":")
+	if !ok || key == "" || value == "" || strings.HasPrefix(value, "//") {
+		return "", "", false
+	}
+	for _, r := range key {
+		if !(r == '-' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return "", "", false
+		}
+	}
+	return key, value, true
+}
+
+// ParseLine parses a task. It returns false for blank lines and tasks without
+// a description. The ID of the todo is empty unless the task has an id:
+// extension.
+func ParseLine(line string) (model.Todo, bool) {
+	var t model.Todo
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return t, false
+	}
+
+	if fields[0] == "x" {
+		t.Completed = true
+		fields = fields[1:]
+		if len(fields) > 0 && isDate(fields[0]) {
+			t.CompletedAt = fields[0]
+			fields = fields[1:]
+		}
+	}
+	var letter string
+	if len(fields) > 0 && isPriority(fields[0]) {
+		letter = fields[0][1:2]
+		fields = fields[1:]
+	}
+	// A completed task has a creation date only if it has a completion date.
+	if len(fields) > 0 && isDate(fields[0]) && (!t.Completed || t.CompletedAt != "") {
+		t.Created = fields[0]
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return t, false
+	}
+
+	var title []string
+	trailing := 0 // projects and contexts ending the title
+	for _, f := range fields {
+		switch {
+		case len(f) > 1 && f[0] == '+':
+			t.Tags = append(t.Tags, f[1:])
+			title = append(title, f)
+			trailing++
+			continue
+		case len(f) > 1 && f[0] == '@':
+			t.Tags = append(t.Tags, f)
+			title = append(title, f)
+			trailing++
+			continue
+		}
+		if _, _, ok := extension(f); ok {
+			t.Extensions = append(t.Extensions, f)
+			continue
+		}
+		title = append(title, f)
+		trailing = 0
+	}
+	if trailing < len(title) {
+		title = title[:len(title)-trailing]
+	}
+	if len(title) == 0 {
+		t.Title = strings.Join(fields, " ")
+		t.Extensions = nil
+	} else {
+		t.Title = strings.Join(title, " ")
+	}
+
+	mapped := mappedExtensions(t.Extensions)
+	for key, i := range mapped {
+		_, value, _ := strings.Cut(t.Extensions[i], ":")
+		switch key {
+		case "due":
+			t.Due = value
+		case "id":
+			t.ID = value
+		case "list":
+			t.List = value
+		case "rec":
+			t.Recurrence, _ = recurrenceRule(value)
+		}
+	}
+	pri, ok := mapped["pri"]
+	if !ok {
+		pri = -1
+	}
+
+	// Completed tasks conventionally keep their priority in a pri: extension.
+	switch {
+	case letter == "" && pri >= 0:
+		letter = t.Extensions[pri][len("pri:"):]
+	case letter != "" && pri >= 0:
+		t.Extensions[pri] = "pri:" + letter
+	}
+	if letter != "" {
+		if p, ok := letters[letter]; ok {
+			t.Priority = p
+		} else {
+			t.Priority = "low"
+			if pri < 0 {
+				t.Extensions = append(t.Extensions, "pri:"+letter)
+			}
+		}
+	}
+	return t, true
+}
+
+// Parse reads every task of a todo.txt file.
+func Parse(r io.Reader) ([]model.Todo, error) {
+	var todos []model.Todo
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		if t, ok := ParseLine(s.Text()); ok {
+			todos = append(todos, t)
+		}
+	}
+	return todos, s.Err()
+}
+
+// date returns the date part of an ISO 8601 date or date-time.
+func date(s string) string {
+	if len(s) > 10 {
+		return s[:10]
+	}
+	return s
+}
+
+// mappedExtensions returns the index of the extension mapped to a field, by
+// key: the first one with this key and a valid value.
+func mappedExtensions(ext model.Extensions) map[string]int {
+	mapped := make(map[string]int)
+	for i, e := range ext {
+		key, value, _ := strings.Cut(e, ":")
+		if _, ok := mapped[key]; ok {
+			continue
+		}
+		switch key {
+		case "due", "id", "list":
+		case "rec":
+			if _, ok := recurrenceRule(value); !ok {
+				continue
+			}
+		case "pri":
+			if !isLetter(value) {
+				continue
+			}
+		default:
+			continue
+		}
+		mapped[key] = i
+	}
+	return mapped
+}
+
+// FormatLine returns the todo.txt task of a todo.
+func FormatLine(t model.Todo) string {
+	var parts []string
+
+	letter := priorityLetters[t.Priority]
+	mapped := mappedExtensions(t.Extensions)
+	pri, ok := mapped["pri"]
+	if !ok {
+		pri = -1
+	}
+	if pri >= 0 && t.Priority == "low" {
+		if l := t.Extensions[pri][len("pri:"):]; l > "C" {
+			letter = l
+		}
+	}
+
+	if t.Completed {
+		parts = append(parts, "x")
+		if t.CompletedAt != "" {
+			parts = append(parts, date(t.CompletedAt))
+		}
+	} else if letter != "" {
+		parts = append(parts, "("+letter+")")
+	}
+	if t.Created != "" && (!t.Completed || t.CompletedAt != "") {
+		parts = append(parts, date(t.Created))
+	}
+
+	title := strings.Fields(t.Title)
+	parts = append(parts, title...)
+	for _, tag := range t.Tags {
+		if !strings.HasPrefix(tag, "@") {
+			tag = "+" + tag
+		}
+		if !containsString(title, tag) {
+			parts = append(parts, tag)
+		}
+	}
+
+	// field returns the extension of a field, or "" if it has no value.
+	// Extensions with the same value are written as they are.
+	field := func(key string, e string) string {
+		_, value, _ := strings.Cut(e, ":")
+		switch key {
+		case "due":
+			value = t.Due
+		case "list":
+			value = t.List
+		case "id":
+			value = t.ID
+		case "rec":
+			if rule, _ := recurrenceRule(value); rule != t.Recurrence || t.Recurrence == "" {
+				value, _ = recurrenceExtension(t.Recurrence)
+			}
+		}
+		if value == "" {
+			return ""
+		}
+		return key + ":" + value
+	}
+	for i, e := range t.Extensions {
+		key, _, _ := strings.Cut(e, ":")
+		j, ok := mapped[key]
+		switch {
+		case i == pri:
+			if t.Completed && letter != "" {
+				parts = append(parts, "pri:"+letter)
+			}
+		case ok && i == j:
+			if f := field(key, e); f != "" {
+				parts = append(parts, f)
+			}
+		default:
+			parts = append(parts, e)
+		}
+	}
+	for _, key := range []string{"due", "rec", "list"} {
+		if _, ok := mapped[key]; !ok {
+			if f := field(key, ""); f != "" {
+				parts = append(parts, f)
+			}
+		}
+	}
+	if t.Completed && letter != "" && pri < 0 {
+		parts = append(parts, "pri:"+letter)
+	}
+	return strings.Join(parts, " ")
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Format returns the todo.txt file of a list of todos.
+func Format(todos []model.Todo) string {
+	var b strings.Builder
+	for _, t := range todos {
+		b.WriteString(FormatLine(t))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+var recFrequencies = map[byte]string{'d': "DAILY", 'w': "WEEKLY", 'm': "MONTHLY", 'y': "YEARLY"}
+var recUnits = map[string]string{"DAILY": "d", "WEEKLY": "w", "MONTHLY": "m", "YEARLY": "y"}
+
+// recurrenceRule converts a rec: extension, such as 1w or +2m, to an RFC 5545
+// recurrence rule. Business day recurrences, such as 1b, are not converted.
+func recurrenceRule(rec string) (string, bool) {
+	rec = strings.TrimPrefix(rec, "+")
+	if len(rec) < 2 {
+		return "", false
+	}
+	freq, ok := recFrequencies[rec[len(rec)-1]]
+	if !ok {
+		return "", false
+	}
+	n, err := strconv.Atoi(rec[:len(rec)-1])
+	if err != nil || n < 1 {
+		return "", false
+	}
+	rule := "FREQ=" + freq
+	if n > 1 {
+		rule += ";INTERVAL=" + strconv.Itoa(n)
+	}
+	return rule, true
+}
+
+// recurrenceExtension converts an RFC 5545 recurrence rule to a rec:
+// extension. Only the frequency and interval are kept: the due date sets the
+// day of the recurrence.
+func recurrenceExtension(rule string) (string, bool) {
+	var unit string
+	n := 1
+	for _, part := range strings.Split(rule, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "FREQ":
+			unit = recUnits[v]
+		case "INTERVAL":
+			i, err := strconv.Atoi(v)
+			if err != nil || i < 1 {
+				return "", false
+			}
+			n = i
+		}
+	}
+	if unit == "" {
+		return "", false
+	}
+	return strconv.Itoa(n) + unit, true
+}
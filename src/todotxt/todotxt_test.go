@@ -0,0 +1,125 @@
+package todotxt
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/atdiar/todomvc2/src/model"
+)
+
+// The tasks of testdata/roundtrip.txt are written back as they are read.
+func TestRoundTrip(t *testing.T) {
+	b, err := os.ReadFile("testdata/roundtrip.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		todo, ok := ParseLine(line)
+		if !ok {
+			t.Errorf("ParseLine(%q) failed", line)
+			continue
+		}
+		if got := FormatLine(todo); got != line {
+			t.Errorf("FormatLine(ParseLine(%q)) = %q", line, got)
+		}
+	}
+
+	todos, err := Parse(strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Format(todos); got != string(b) {
+		t.Errorf("Format(Parse(file)) =\n%s\nwant\n%s", got, b)
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want model.Todo
+	}{
+		{"(A) 2026-10-01 Call mom +family @phone due:2026-10-20", model.Todo{
+			Title: "Call mom", Priority: "high", Created: "2026-10-01",
+			Tags: []string{"family", "@phone"}, Due: "2026-10-20",
+			Extensions: model.Extensions{"due:2026-10-20"},
+		}},
+		{"x 2026-10-03 2026-10-01 Done pri:B", model.Todo{
+			Title: "Done", Completed: true, CompletedAt: "2026-10-03", Created: "2026-10-01",
+			Priority: "medium", Extensions: model.Extensions{"pri:B"},
+		}},
+		{"(E) Someday", model.Todo{
+			Title: "Someday", Priority: "low", Extensions: model.Extensions{"pri:E"},
+		}},
+		{"Pay rent rec:1m rec:2w", model.Todo{
+			Title: "Pay rent", Recurrence: "FREQ=MONTHLY",
+			Extensions: model.Extensions{"rec:1m", "rec:2w"},
+		}},
+		{"Pay rent rec:1b", model.Todo{
+			Title: "Pay rent", Extensions: model.Extensions{"rec:1b"},
+		}},
+		{"Report id:r1 t:1 t:2", model.Todo{
+			ID: "r1", Title: "Report", Extensions: model.Extensions{"id:r1", "t:1", "t:2"},
+		}},
+		{"Call +mom about @phone plans +family", model.Todo{
+			Title: "Call +mom about @phone plans", Tags: []string{"mom", "@phone", "family"},
+		}},
+		{"@phone +errands", model.Todo{
+			Title: "@phone +errands", Tags: []string{"@phone", "errands"},
+		}},
+		{"due:2026-10-20", model.Todo{Title: "due:2026-10-20"}},
+	}
+	for _, tt := range tests {
+		got, ok := ParseLine(tt.line)
+		if !ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLine(%q) = %+v, %v, want %+v", tt.line, got, ok, tt.want)
+		}
+	}
+
+	for _, line := range []string{"", "   ", "x", "(A)", "x 2026-10-03 2026-10-01"} {
+		if got, ok := ParseLine(line); ok {
+			t.Errorf("ParseLine(%q) = %+v, want no task", line, got)
+		}
+	}
+}
+
+func TestFormatLine(t *testing.T) {
+	tests := []struct {
+		todo model.Todo
+		want string
+	}{
+		// Ids given on import or in the app are not exported.
+		{model.Todo{ID: "x1", Title: "Buy milk", Tags: []string{"@shop"}}, "Buy milk @shop"},
+		{model.Todo{ID: "x1", Title: "Buy milk", Extensions: model.Extensions{"id:x1"}}, "Buy milk id:x1"},
+
+		// Fields changed in the app replace the extensions they were read from.
+		{model.Todo{Title: "Pay rent", Due: "2026-12-01", Extensions: model.Extensions{"due:2026-10-20", "foo:bar"}}, "Pay rent due:2026-12-01 foo:bar"},
+		{model.Todo{Title: "Pay rent", Extensions: model.Extensions{"due:2026-10-20", "foo:bar"}}, "Pay rent foo:bar"},
+		{model.Todo{Title: "Pay rent", Due: "2026-12-01", List: "home", Extensions: model.Extensions{"foo:bar"}}, "Pay rent foo:bar due:2026-12-01 list:home"},
+		{model.Todo{Title: "Pay rent", Recurrence: "FREQ=WEEKLY", Extensions: model.Extensions{"rec:+1m"}}, "Pay rent rec:1w"},
+		{model.Todo{Title: "Pay rent", Recurrence: "FREQ=MONTHLY", Extensions: model.Extensions{"rec:+1m"}}, "Pay rent rec:+1m"},
+		{model.Todo{Title: "Done", Completed: true, Priority: "high"}, "x Done pri:A"},
+		{model.Todo{Title: "Done", Completed: true, Priority: "high", Extensions: model.Extensions{"pri:E", "a:b"}}, "x Done pri:A a:b"},
+		{model.Todo{Title: "Open", Priority: "low", Extensions: model.Extensions{"a:b", "pri:E"}}, "(E) Open a:b"},
+	}
+	for _, tt := range tests {
+		if got := FormatLine(tt.todo); got != tt.want {
+			t.Errorf("FormatLine(%+v) = %q, want %q", tt.todo, got, tt.want)
+		}
+	}
+}
+
+// Tasks without words besides projects and contexts can be imported.
+func TestParseValid(t *testing.T) {
+	todos, err := Parse(strings.NewReader("+travel @airport\n+errands\ndue:2026-10-20\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range todos {
+		todos[i].ID = string(rune('a' + i))
+	}
+	if err := model.ValidateList(todos); err != nil {
+		t.Error(err)
+	}
+}
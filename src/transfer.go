@@ -0,0 +1,436 @@
+package main
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	ui "github.com/atdiar/particleui"
+	doc "github.com/atdiar/particleui/drivers/js"
+
+	"github.com/atdiar/todomvc2/src/csvio"
+	"github.com/atdiar/todomvc2/src/ical"
+	"github.com/atdiar/todomvc2/src/markdown"
+	"github.com/atdiar/todomvc2/src/model"
+	"github.com/atdiar/todomvc2/src/todotxt"
+)
+
+// TransferBar holds the export and import actions of the workspace.
+// Once an import is confirmed, it triggers an "importtodos" event whose value is
+// the resulting List of todos.
+type TransferBar struct {
+	*ui.Element
+}
+
+func TransferBarFromRef(ref *ui.Element) TransferBar {
+	return TransferBar{ref}
+}
+
+func NewTransferBar(d *doc.Document, id string, tlist TodosListElement, options ...string) TransferBar {
+	return TransferBar{newtransferbar(d, id, tlist, options...)}
+}
+
+func exportWorkspace(tlist TodosListElement) error {
+	w := model.NewWorkspace(listToModel(tlist.GetList()))
+	b, err := w.Marshal()
+	if err != nil {
+		return err
+	}
+	download("todos.json", "application/json", string(b))
+	return nil
+}
+
+// exportFormat describes a file format the todos can be exported to.
+type exportFormat struct {
+	name     string
+	filename string
+	mimetype string
+	format   func(tlist TodosListElement) string
+}
+
+var exportFormats = []exportFormat{
+	{"todo.txt", "todo.txt", "text/plain", func(tlist TodosListElement) string {
+		return todotxt.Format(listToModel(tlist.GetList()))
+	}},
+	{"iCalendar", "todos.ics", "text/calendar", func(tlist TodosListElement) string {
+		return ical.Format(listToModel(tlist.GetList()), time.Now())
+	}},
+}
+
+// visibleTodos returns the todos of the current view, filter applied.
+func visibleTodos(tlist TodosListElement) []model.Todo {
+	visible := tlist.visibleIDs()
+	var todos []model.Todo
+	for _, t := range listToModel(tlist.GetList()) {
+		if containsString(visible, t.ID) {
+			todos = append(todos, t)
+		}
+	}
+	return todos
+}
+
+// exportCSV downloads the todos of the current view with the given columns.
+func exportCSV(tlist TodosListElement, columns []string) error {
+	var b strings.Builder
+	if err := csvio.Write(&b, visibleTodos(tlist), columns); err != nil {
+		return err
+	}
+	download("todos.csv", "text/csv", b.String())
+	return nil
+}
+
+// previewMax is the number of todos of each kind of change an import preview
+// lists.
+const previewMax = 10
+
+// prepareImport gives a new id to the imported todos that have none, then
+// validates them.
+func prepareImport(todos []model.Todo) ([]model.Todo, error) {
+	for i := range todos {
+		if todos[i].ID == "" {
+			todos[i].ID = NewID()
+		}
+	}
+	return todos, model.ValidateList(todos)
+}
+
+// parseImport decodes an imported file into todos, according to its extension.
+// Imported todos without an id get a new one. CSV files are not handled here
+// since their columns must first be mapped to todo fields.
+func parseImport(filename string, content string) ([]model.Todo, error) {
+	var todos []model.Todo
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".txt":
+		t, err := todotxt.Parse(strings.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		todos = t
+	case ".ics":
+		t, err := ical.Parse(strings.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		todos = t
+	default:
+		w, err := model.ParseWorkspace([]byte(content))
+		if err != nil {
+			return nil, err
+		}
+		todos = w.Todos
+	}
+	return prepareImport(todos)
+}
+
+func newtransferbar(document *doc.Document, id string, tlist TodosListElement, options ...string) *ui.Element {
+	bar := document.Div.WithID(id, options...).AsElement()
+	doc.AddClass(bar, "transfer")
+
+	var incoming []model.Todo
+
+	exportjson := document.Button.WithID(id+"-export-json", "button")
+	exportjson.SetText("Export JSON")
+	exportjson.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		if err := exportWorkspace(tlist); err != nil {
+			bar.SetUI("exporterror", ui.String(err.Error()))
+		}
+		return false
+	}))
+
+	file := document.Input.WithID(id+"-file", "file")
+	doc.SetAttribute(file.AsElement(), "accept", ".json,.txt,.ics,.csv")
+	doc.SetInlineCSS(file.AsElement(), "display:none")
+
+	importbtn := document.Button.WithID(id+"-import", "button")
+	importbtn.SetText("Import…")
+	importbtn.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		if f, ok := doc.JSValue(file.AsElement()); ok {
+			f.Set("value", "")
+			f.Call("click")
+		}
+		return false
+	}))
+
+	preview := document.Div.WithID(id + "-preview")
+	doc.AddClass(preview.AsElement(), "transfer-preview")
+	doc.SetInlineCSS(preview.AsElement(), "display:none")
+	msg := document.Span.WithID(id + "-preview-msg")
+	// The todos the import changes, listed below the counts.
+	changes := document.Ul.WithID(id + "-preview-changes")
+	doc.AddClass(changes.AsElement(), "transfer-changes")
+	var changelines []string
+	var changerows []*ui.Element
+
+	apply := func(mode model.ImportMode) *ui.Element {
+		text := "Replace"
+		if mode == model.Merge {
+			text = "Merge"
+		}
+		b := document.Button.WithID(id+"-"+strings.ToLower(text), "button")
+		b.SetText(text)
+		b.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+			plan := model.PlanImport(listToModel(tlist.GetList()), incoming, mode)
+			bar.TriggerEvent("importtodos", listFromModel(plan.Result))
+			bar.SetUI("importpreview", ui.String(""))
+			return false
+		}))
+		return b.AsElement()
+	}
+	replace := apply(model.Replace)
+	merge := apply(model.Merge)
+
+	cancel := document.Button.WithID(id+"-cancel", "button")
+	cancel.SetText("Cancel")
+	cancel.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		bar.SetUI("importpreview", ui.String(""))
+		return false
+	}))
+
+	preview.AsElement().SetChildren(msg.AsElement(), changes.AsElement(), replace, merge, cancel.AsElement())
+
+	// Merging adds and updates the same todos as replacing, which also
+	// removes some: the todos changed are those replacing changes.
+	showPreview := func(name string, todos []model.Todo) {
+		incoming = todos
+		current := listToModel(tlist.GetList())
+		replaced := model.PlanImport(current, todos, model.Replace)
+		changelines = replaced.Details(current, previewMax)
+		bar.SetUI("importpreview", ui.String(name+": "+
+			"replace → "+replaced.Summary()+
+			"; merge → "+model.PlanImport(current, todos, model.Merge).Summary()))
+	}
+
+	setChanges := func(lines []string) {
+		for _, row := range changerows {
+			ui.Delete(row)
+		}
+		changerows = changerows[:0]
+		for _, line := range lines {
+			row := document.Li()
+			text := document.Span()
+			text.SetText(line)
+			row.AsElement().SetChildren(text.AsElement())
+			changerows = append(changerows, row.AsElement())
+		}
+		changes.AsElement().SetChildren(changerows...)
+	}
+
+	// CSV imports go through a mapping step where each column of the file is
+	// assigned a todo field, or ignored.
+	var csvname string
+	var csvrows [][]string
+	var mapping []string
+	var mappingrows []*ui.Element
+
+	mappingpanel := document.Div.WithID(id + "-csv-mapping")
+	doc.AddClass(mappingpanel.AsElement(), "transfer-mapping")
+	doc.SetInlineCSS(mappingpanel.AsElement(), "display:none")
+	mappingmsg := document.Span.WithID(id + "-csv-mapping-msg")
+	mappinglist := document.Ul.WithID(id + "-csv-mapping-list")
+
+	mapcontinue := document.Button.WithID(id+"-csv-continue", "button")
+	mapcontinue.SetText("Continue")
+	mapcontinue.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		bar.SetUI("csvmapping", ui.String(""))
+		todos, err := csvio.Import(csvrows, mapping)
+		if err == nil {
+			todos, err = prepareImport(todos)
+		}
+		if err != nil {
+			bar.SetUI("importerror", ui.String(csvname+": "+err.Error()))
+			return false
+		}
+		showPreview(csvname, todos)
+		return false
+	}))
+
+	mapcancel := document.Button.WithID(id+"-csv-cancel", "button")
+	mapcancel.SetText("Cancel")
+	mapcancel.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		bar.SetUI("csvmapping", ui.String(""))
+		return false
+	}))
+
+	mappingpanel.AsElement().SetChildren(mappingmsg.AsElement(), mappinglist.AsElement(), mapcontinue.AsElement(), mapcancel.AsElement())
+
+	fieldLabel := func(f string) string {
+		if f == "" {
+			return "(ignore)"
+		}
+		return f
+	}
+
+	bar.Watch("ui", "csvmapping", bar, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		s := string(evt.NewValue().(ui.String))
+		if s == "" {
+			doc.SetInlineCSS(mappingpanel.AsElement(), "display:none")
+			return false
+		}
+		for _, row := range mappingrows {
+			ui.Delete(row)
+		}
+		mappingrows = mappingrows[:0]
+
+		header, rows, err := csvio.Read(strings.NewReader(s))
+		if err != nil {
+			bar.SetUI("importerror", ui.String(csvname+": "+err.Error()))
+			return false
+		}
+		csvrows = rows
+		mapping = csvio.GuessMapping(header)
+
+		for i, h := range header {
+			i := i
+			row := document.Li()
+			name := document.Span()
+			name.SetText(h + " →")
+			target := document.Button()
+			target.SetText(fieldLabel(mapping[i]))
+			// Each click assigns the next field no other column is mapped to.
+			target.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+				mapping[i] = csvio.NextField(mapping, i)
+				target.SetText(fieldLabel(mapping[i]))
+				return false
+			}))
+			row.AsElement().SetChildren(name.AsElement(), target.AsElement())
+			mappinglist.AsElement().AppendChild(row.AsElement())
+			mappingrows = append(mappingrows, row.AsElement())
+		}
+		mappingmsg.SetText(csvname + ": " + strconv.Itoa(len(rows)) + " rows. Map each column to a todo field.")
+		doc.SetInlineCSS(preview.AsElement(), "display:none")
+		doc.SetInlineCSS(mappingpanel.AsElement(), "display:block")
+		return false
+	}))
+
+	file.AsElement().AddEventListener("change", ui.NewEventHandler(func(evt ui.Event) bool {
+		f, ok := doc.JSValue(file.AsElement())
+		if !ok {
+			return false
+		}
+		go func() {
+			name, content, err := readFile(f)
+			if err != nil {
+				bar.SetUI("importerror", ui.String(err.Error()))
+				return
+			}
+			if strings.ToLower(path.Ext(name)) == ".csv" {
+				csvname = name
+				bar.SetUI("csvmapping", ui.String(content))
+				return
+			}
+			todos, err := parseImport(name, content)
+			if err != nil {
+				bar.SetUI("importerror", ui.String(name+": "+err.Error()))
+				return
+			}
+			showPreview(name, todos)
+		}()
+		return false
+	}))
+
+	bar.Watch("ui", "importpreview", bar, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		s := string(evt.NewValue().(ui.String))
+		if s == "" {
+			doc.SetInlineCSS(preview.AsElement(), "display:none")
+			return false
+		}
+		msg.SetText(s)
+		setChanges(changelines)
+		doc.SetInlineCSS(replace, "display:inline")
+		doc.SetInlineCSS(merge, "display:inline")
+		doc.SetInlineCSS(preview.AsElement(), "display:block")
+		return false
+	}))
+
+	showError := func(text string) {
+		msg.SetText(text)
+		setChanges(nil)
+		doc.SetInlineCSS(replace, "display:none")
+		doc.SetInlineCSS(merge, "display:none")
+		doc.SetInlineCSS(preview.AsElement(), "display:block")
+	}
+
+	bar.Watch("ui", "importerror", bar, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		showError("Import failed: " + string(evt.NewValue().(ui.String)))
+		return false
+	}))
+
+	bar.Watch("ui", "exporterror", bar, ui.OnMutation(func(evt ui.MutationEvent) bool {
+		showError("Export failed: " + string(evt.NewValue().(ui.String)))
+		return false
+	}))
+
+	children := []*ui.Element{exportjson.AsElement()}
+	for _, f := range exportFormats {
+		f := f
+		b := document.Button.WithID(id+"-export-"+strings.ReplaceAll(f.name, ".", ""), "button")
+		b.SetText("Export " + f.name)
+		b.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+			download(f.filename, f.mimetype, f.format(tlist))
+			return false
+		}))
+		children = append(children, b.AsElement())
+	}
+
+	// Columns of the CSV export, chosen with checkboxes.
+	columns := make(map[string]bool)
+	for _, c := range csvio.DefaultColumns {
+		columns[c] = true
+	}
+	csvcolumns := document.Div.WithID(id + "-csv-columns")
+	doc.AddClass(csvcolumns.AsElement(), "transfer-columns")
+	for _, c := range csvio.Columns {
+		c := c
+		check := document.Input.WithID(id+"-csv-column-"+c, "checkbox")
+		check.SetUI("checked", ui.Bool(columns[c]))
+		check.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+			columns[c] = !columns[c]
+			return false
+		}))
+		label := document.Span()
+		label.SetText(c)
+		csvcolumns.AsElement().AppendChild(check.AsElement())
+		csvcolumns.AsElement().AppendChild(label.AsElement())
+	}
+
+	exportcsv := document.Button.WithID(id+"-export-csv", "button")
+	exportcsv.SetText("Export CSV")
+	exportcsv.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		var selected []string
+		for _, c := range csvio.Columns {
+			if columns[c] {
+				selected = append(selected, c)
+			}
+		}
+		if len(selected) == 0 {
+			return false
+		}
+		if err := exportCSV(tlist, selected); err != nil {
+			bar.SetUI("exporterror", ui.String(err.Error()))
+		}
+		return false
+	}))
+
+	// The current view as a Markdown task list, copied to the clipboard or,
+	// when the clipboard is unavailable, downloaded.
+	copymd := document.Button.WithID(id+"-copy-markdown", "button")
+	copymd.SetText("Copy as Markdown")
+	copymd.AsElement().AddEventListener("click", ui.NewEventHandler(func(evt ui.Event) bool {
+		md := markdown.TaskList(visibleTodos(tlist))
+		go func() {
+			if err := copyText(md); err != nil {
+				download("todos.md", "text/markdown", md)
+				return
+			}
+			copymd.SetText("Copied!")
+			time.Sleep(2 * time.Second)
+			copymd.SetText("Copy as Markdown")
+		}()
+		return false
+	}))
+
+	children = append(children, exportcsv.AsElement(), copymd.AsElement(), importbtn.AsElement(), file.AsElement(), csvcolumns.AsElement(), mappingpanel.AsElement(), preview.AsElement())
+	bar.SetChildren(children...)
+	return bar
+}